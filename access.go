@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Role is an access level of a user in a chat.
+type Role int
+
+// Roles in order of increasing access.
+const (
+	// RoleReadOnly can only read the state.
+	RoleReadOnly Role = iota
+	// RoleUser can change the state of their own private chat.
+	RoleUser
+	// RoleModerator can change the state of the chat they moderate.
+	RoleModerator
+	// RoleAdmin can change the state of any chat.
+	RoleAdmin
+)
+
+// String returns the role's name.
+func (r Role) String() string {
+	switch r {
+	case RoleReadOnly:
+		return "read-only"
+	case RoleUser:
+		return "user"
+	case RoleModerator:
+		return "moderator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return fmt.Sprintf("role(%d)", int(r))
+	}
+}
+
+// Access defines roles of users in chats.
+type Access struct {
+	admins     map[int64]bool
+	moderators map[int64]map[int64]bool
+	readOnly   map[int64]bool
+}
+
+// NewAccess creates access roles from the config. Nil config means that
+// there are no admins, moderators or read-only users.
+func NewAccess(conf *AccessConfig) *Access {
+	a := &Access{
+		admins:     map[int64]bool{},
+		moderators: map[int64]map[int64]bool{},
+		readOnly:   map[int64]bool{},
+	}
+	if conf == nil {
+		return a
+	}
+	for _, id := range conf.Admins {
+		a.admins[id] = true
+	}
+	for chat, users := range conf.Moderators {
+		a.moderators[chat] = make(map[int64]bool, len(users))
+		for _, id := range users {
+			a.moderators[chat][id] = true
+		}
+	}
+	for _, id := range conf.ReadOnly {
+		a.readOnly[id] = true
+	}
+	return a
+}
+
+// Role returns the role of the user in the chat. Users, that are not
+// listed in the config, can only change their own private chats.
+func (a *Access) Role(user, chat int64) Role {
+	switch {
+	case a.admins[user]:
+		return RoleAdmin
+	case a.moderators[chat][user]:
+		return RoleModerator
+	case a.readOnly[user]:
+		return RoleReadOnly
+	case user == chat:
+		return RoleUser
+	default:
+		return RoleReadOnly
+	}
+}
+
+// CanChange reports whether the user can change the state of the chat.
+func (a *Access) CanChange(user, chat int64) bool {
+	return a.Role(user, chat) >= RoleUser
+}
+
+// AuditEntry is a record of a state change.
+type AuditEntry struct {
+	Time time.Time `yaml:"time"`
+	// User is Telegram ID or name of the user, who made the change, and
+	// Role is their role.
+	User string `yaml:"user"`
+	Role string `yaml:"role"`
+	// Chat is ID of the chat, whose state was changed. Zero value means
+	// the change is not related to a chat.
+	Chat int64 `yaml:"chat,omitempty"`
+	// Action is the command, e.g. "add", and Target is its argument.
+	Action string `yaml:"action"`
+	Target string `yaml:"target,omitempty"`
+}
+
+// String returns a single line representation of the entry.
+func (e AuditEntry) String() string {
+	s := fmt.Sprintf("%s %s (%s) %s %s",
+		e.Time.Format(time.RFC3339), e.User, e.Role, e.Action, e.Target)
+	if e.Chat != 0 {
+		s += fmt.Sprintf(" in %d", e.Chat)
+	}
+	return s
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccess_Role(t *testing.T) {
+	a := NewAccess(&AccessConfig{
+		Admins:     []int64{1},
+		Moderators: map[int64][]int64{-100: {2, 3}},
+		ReadOnly:   []int64{3, 4},
+	})
+
+	for _, tc := range []struct {
+		user, chat int64
+		role       Role
+		change     bool
+	}{
+		{user: 1, chat: -200, role: RoleAdmin, change: true},
+		{user: 1, chat: 1, role: RoleAdmin, change: true},
+		{user: 2, chat: -100, role: RoleModerator, change: true},
+		{user: 2, chat: -200, role: RoleReadOnly, change: false},
+		{user: 2, chat: 2, role: RoleUser, change: true},
+		{user: 3, chat: -100, role: RoleModerator, change: true},
+		{user: 3, chat: 3, role: RoleReadOnly, change: false},
+		{user: 4, chat: 4, role: RoleReadOnly, change: false},
+		{user: 5, chat: 5, role: RoleUser, change: true},
+		{user: 5, chat: -100, role: RoleReadOnly, change: false},
+	} {
+		assert.Equal(t, tc.role, a.Role(tc.user, tc.chat), "%d in %d", tc.user, tc.chat)
+		assert.Equal(t, tc.change, a.CanChange(tc.user, tc.chat), "%d in %d", tc.user, tc.chat)
+	}
+
+	t.Run("no config", func(t *testing.T) {
+		a := NewAccess(nil)
+		assert.Equal(t, RoleUser, a.Role(1, 1))
+		assert.Equal(t, RoleReadOnly, a.Role(1, -100))
+	})
+}
+
+func TestRole_String(t *testing.T) {
+	assert.Equal(t, "read-only", RoleReadOnly.String())
+	assert.Equal(t, "user", RoleUser.String())
+	assert.Equal(t, "moderator", RoleModerator.String())
+	assert.Equal(t, "admin", RoleAdmin.String())
+	assert.Equal(t, "role(10)", Role(10).String())
+}
+
+func TestAuditEntry_String(t *testing.T) {
+	e := AuditEntry{
+		Time:   time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		User:   "1",
+		Role:   "admin",
+		Chat:   -100,
+		Action: "add",
+		Target: "https://example.com/rss.xml",
+	}
+	assert.Equal(t, "2024-01-01T10:00:00Z 1 (admin) add https://example.com/rss.xml in -100", e.String())
+
+	e = AuditEntry{Time: e.Time, User: "alice", Role: "admin", Action: "pause", Target: "https://example.com/rss.xml"}
+	assert.Equal(t, "2024-01-01T10:00:00Z alice (admin) pause https://example.com/rss.xml", e.String())
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// adminPageSize is the number of deliveries on a single history page.
+	adminPageSize = 50
+	// adminShutdownTimeout is a time for finishing active requests when
+	// the server stops.
+	adminShutdownTimeout = 5 * time.Second
+)
+
+//go:embed web
+var web embed.FS
+
+// AdminStorage keeps the state of feeds, delivered items and the audit log.
+type AdminStorage interface {
+	LastUpdates() map[string]time.Time
+	ResetLastUpdate(feed string) error
+	GetDeliveries() []Delivery
+	GetDelivery(id int) (Delivery, bool)
+	AddAuditEntry(e AuditEntry) error
+}
+
+// Admin is a web UI for managing feeds of the registry and their state,
+// and for browsing delivered items. Editors are authenticated with basic
+// auth. All changes are written to the audit log.
+type Admin struct {
+	editors  []Editor
+	registry *FeedRegistry
+	control  FeedControl
+	storage  AdminStorage
+	notifier Notifier
+	tmpl     *template.Template
+	log      *logrus.Logger
+
+	// api handles requests under /api/ path.
+	api http.Handler
+}
+
+// AdminOption is an optional admin UI setting.
+type AdminOption func(*Admin)
+
+// WithAPI makes the admin server handle requests under /api/ path with
+// the handler. Requests are authenticated the same way as the UI ones.
+func WithAPI(h http.Handler) AdminOption {
+	return func(a *Admin) { a.api = h }
+}
+
+// NewAdmin creates a web admin UI. Feeds are paused, resumed and fetched
+// through the control, and items are re-sent through the notifier.
+func NewAdmin(
+	conf AdminConfig,
+	r *FeedRegistry,
+	c FeedControl,
+	s AdminStorage,
+	n Notifier,
+	log *logrus.Logger,
+	opts ...AdminOption,
+) *Admin {
+	a := &Admin{
+		editors:  conf.Editors,
+		registry: r,
+		control:  c,
+		storage:  s,
+		notifier: n,
+		tmpl:     template.Must(template.ParseFS(web, "web/*.html")),
+		log:      log,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Serve handles requests on the listener until the context is cancelled.
+func (a *Admin) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           a.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			a.log.Errorf("Failed to stop admin server: %v", err)
+		}
+	}()
+
+	err := srv.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-done
+		return nil
+	}
+	return fmt.Errorf("serve: %w", err)
+}
+
+// Handler returns HTTP handler of the UI.
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", a.feeds)
+	mux.HandleFunc("GET /history", a.history)
+	mux.HandleFunc("POST /feeds/add", a.change("add", a.add))
+	mux.HandleFunc("POST /feeds/remove", a.change("remove", a.registry.Remove))
+	mux.HandleFunc("POST /feeds/pause", a.change("pause", a.control.Pause))
+	mux.HandleFunc("POST /feeds/resume", a.change("resume", a.control.Resume))
+	mux.HandleFunc("POST /feeds/fetch", a.change("fetch", a.control.FetchNow))
+	mux.HandleFunc("POST /feeds/reset", a.change("reset", a.reset))
+	mux.HandleFunc("POST /history/resend", a.resend)
+	if a.api != nil {
+		mux.Handle("/api/", a.api)
+	}
+	return a.auth(mux)
+}
+
+// auth checks credentials of editors. Changes can only be made from pages
+// of the UI, or without a browser.
+func (a *Admin) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := a.editor(r); !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="feed-bot", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			http.Error(w, "Cross-origin request", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// editor returns the name of the authenticated editor.
+func (a *Admin) editor(r *http.Request) (string, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	var found bool
+	for _, e := range a.editors {
+		// Credentials of all editors are compared in constant time
+		u := subtle.ConstantTimeCompare([]byte(user), []byte(e.Username))
+		p := subtle.ConstantTimeCompare([]byte(pass), []byte(e.Password))
+		if u&p == 1 {
+			found = true
+		}
+	}
+	return user, found
+}
+
+// sameOrigin reports whether the request is sent from the same host.
+// Requests without Origin header, e.g. from old browsers or scripts,
+// are allowed.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// feedRow is a feed on the main page.
+type feedRow struct {
+	FeedInfo
+	LastUpdate time.Time
+}
+
+// feeds renders the list of feeds with their state.
+func (a *Admin) feeds(w http.ResponseWriter, r *http.Request) {
+	updates := a.storage.LastUpdates()
+	list := a.registry.List()
+	rows := make([]feedRow, len(list))
+	for i, f := range list {
+		rows[i] = feedRow{FeedInfo: f, LastUpdate: updates[f.Feed.URL]}
+	}
+	a.render(w, "feeds.html", map[string]any{
+		"Feeds": rows,
+		"Error": r.URL.Query().Get("error"),
+	})
+}
+
+// history renders a page of delivered items, newest first.
+func (a *Admin) history(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	all := a.storage.GetDeliveries()
+	from := min((page-1)*adminPageSize, len(all))
+	to := min(from+adminPageSize, len(all))
+
+	data := map[string]any{
+		"Deliveries": all[from:to],
+		"Error":      r.URL.Query().Get("error"),
+		"Sent":       r.URL.Query().Get("sent"),
+	}
+	if page > 1 {
+		data["Prev"] = page - 1
+	}
+	if to < len(all) {
+		data["Next"] = page + 1
+	}
+	a.render(w, "history.html", data)
+}
+
+// change returns a handler of the form, that applies the action to
+// the feed, and redirects back to the list of feeds.
+func (a *Admin) change(action string, apply func(feed string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		feed := r.PostFormValue("url")
+		if err := apply(feed); err != nil {
+			a.log.Warnf("Failed to %s feed %s: %v", action, feed, err)
+			redirect(w, r, "/", err)
+			return
+		}
+		a.audit(r, action, feed)
+		redirect(w, r, "/", nil)
+	}
+}
+
+// add adds the feed with default settings to the registry.
+func (a *Admin) add(feed string) error {
+	return a.registry.Add(Feed{URL: feed})
+}
+
+// reset removes the state of the feed, so it's fetched as a new one.
+func (a *Admin) reset(feed string) error {
+	if !a.registry.Has(feed) {
+		return fmt.Errorf("%w: %s", ErrUnknownFeed, feed)
+	}
+	return a.storage.ResetLastUpdate(feed)
+}
+
+// resend sends the delivered item once again. Deduplication is bypassed.
+func (a *Admin) resend(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PostFormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid delivery id", http.StatusBadRequest)
+		return
+	}
+	d, ok := a.storage.GetDelivery(id)
+	if !ok {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if err := a.notifier.Notify(r.Context(), d.Item); err != nil {
+		a.log.Errorf("Failed to re-send item %s: %v", d.Item.Link, err)
+		redirect(w, r, "/history", err)
+		return
+	}
+	a.audit(r, "resend", d.Item.Link)
+	http.Redirect(w, r, "/history?sent="+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+// audit writes the successful change to the audit log.
+func (a *Admin) audit(r *http.Request, action, target string) {
+	auditEditor(a.storage, a.log, r, action, target)
+}
+
+// AuditStorage keeps the audit log.
+type AuditStorage interface {
+	AddAuditEntry(e AuditEntry) error
+}
+
+// auditEditor writes the change made by the authenticated editor to
+// the audit log. All editors are admins.
+func auditEditor(s AuditStorage, log *logrus.Logger, r *http.Request, action, target string) {
+	user, _, _ := r.BasicAuth()
+	e := AuditEntry{
+		Time:   time.Now(),
+		User:   user,
+		Role:   RoleAdmin.String(),
+		Action: action,
+		Target: target,
+	}
+	if err := s.AddAuditEntry(e); err != nil {
+		log.Errorf("Failed to write audit log: %v", err)
+	}
+}
+
+// render writes the page.
+func (a *Admin) render(w http.ResponseWriter, page string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := a.tmpl.ExecuteTemplate(w, page, data); err != nil {
+		a.log.Errorf("Failed to render %s: %v", page, err)
+	}
+}
+
+// redirect redirects to the page after submitting a form, showing
+// the error if it's not nil.
+func redirect(w http.ResponseWriter, r *http.Request, page string, err error) {
+	if err != nil {
+		page += "?error=" + url.QueryEscape(err.Error())
+	}
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmin_Auth(t *testing.T) {
+	a, _, _ := newTestAdmin(&testNotifier{})
+
+	for name, tc := range map[string]struct {
+		user, pass string
+		origin     string
+		method     string
+		code       int
+	}{
+		"no credentials":   {method: http.MethodGet, code: http.StatusUnauthorized},
+		"invalid password": {user: "root", pass: "fail", method: http.MethodGet, code: http.StatusUnauthorized},
+		"unknown user":     {user: "admin", pass: "secret", method: http.MethodGet, code: http.StatusUnauthorized},
+		"success":          {user: "root", pass: "secret", method: http.MethodGet, code: http.StatusOK},
+		"cross-origin": {
+			user: "root", pass: "secret", origin: "https://example.org",
+			method: http.MethodPost, code: http.StatusForbidden,
+		},
+		"same origin": {
+			user: "root", pass: "secret", origin: "http://example.com",
+			method: http.MethodPost, code: http.StatusSeeOther,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := "/"
+			if tc.method == http.MethodPost {
+				path = "/feeds/pause"
+			}
+			req := httptest.NewRequest(tc.method, "http://example.com"+path,
+				strings.NewReader("url=https%3A%2F%2Fexample.com%2F1"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.user != "" {
+				req.SetBasicAuth(tc.user, tc.pass)
+			}
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, req)
+			assert.Equal(t, tc.code, w.Code)
+		})
+	}
+}
+
+func TestAdmin_Feeds(t *testing.T) {
+	t.Run("list", func(t *testing.T) {
+		a, r, s := newTestAdmin(&testNotifier{})
+		s.updates = map[string]time.Time{
+			"https://example.com/1": time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+		assert.NoError(t, r.Pause("https://example.com/2"))
+		r.SetStatus("https://example.com/1", 2, nil)
+		r.SetStatus("https://example.com/2", 0, errors.New("fetch failed"))
+
+		w := testAdminRequest(a, http.MethodGet, "/", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.Contains(t, body, "2000-01-02 03:04:05")
+		assert.Contains(t, body, "ok</span>, 2 new")
+		assert.Contains(t, body, "paused")
+		assert.Contains(t, body, `action="/feeds/resume"`)
+	})
+
+	t.Run("change", func(t *testing.T) {
+		a, r, s := newTestAdmin(&testNotifier{})
+
+		for _, path := range []string{"/feeds/add", "/feeds/pause", "/feeds/fetch"} {
+			w := testAdminRequest(a, http.MethodPost, path, url.Values{"url": {"https://example.com/3"}})
+			assert.Equal(t, http.StatusSeeOther, w.Code)
+			assert.Equal(t, "/", w.Header().Get("Location"))
+		}
+		w := testAdminRequest(a, http.MethodPost, "/feeds/remove", url.Values{"url": {"https://example.com/1"}})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+
+		assert.Equal(t, []FeedInfo{
+			{Feed: Feed{URL: "https://example.com/2"}},
+			{Feed: Feed{URL: "https://example.com/3"}, Paused: true},
+		}, r.List())
+		assert.Len(t, s.audit, 4)
+		assert.Equal(t, "fetch", s.audit[2].Action)
+		assert.Equal(t, "root", s.audit[0].User)
+		assert.Equal(t, "admin", s.audit[0].Role)
+		assert.Equal(t, "add", s.audit[0].Action)
+		assert.Equal(t, "https://example.com/3", s.audit[0].Target)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		a, _, s := newTestAdmin(&testNotifier{})
+		s.updates = map[string]time.Time{"https://example.com/1": time.Now()}
+
+		w := testAdminRequest(a, http.MethodPost, "/feeds/reset", url.Values{"url": {"https://example.com/1"}})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Empty(t, s.updates)
+		assert.Len(t, s.audit, 1)
+	})
+
+	t.Run("invalid change", func(t *testing.T) {
+		a, _, s := newTestAdmin(&testNotifier{})
+
+		w := testAdminRequest(a, http.MethodPost, "/feeds/reset", url.Values{"url": {"https://example.com/3"}})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/?error=unknown+feed%3A+https%3A%2F%2Fexample.com%2F3", w.Header().Get("Location"))
+		assert.Empty(t, s.audit)
+
+		// Errors are shown on the page
+		w = testAdminRequest(a, http.MethodGet, w.Header().Get("Location"), nil)
+		assert.Contains(t, w.Body.String(), "unknown feed: https://example.com/3")
+	})
+}
+
+func TestAdmin_History(t *testing.T) {
+	t.Run("pages", func(t *testing.T) {
+		a, _, s := newTestAdmin(&testNotifier{})
+		for i := range adminPageSize + 1 {
+			s.deliveries = append(s.deliveries, Delivery{ID: i + 1, Item: Item{Title: "Item"}})
+		}
+		s.deliveries[adminPageSize].Item.Title = "Last item"
+
+		w := testAdminRequest(a, http.MethodGet, "/history", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, adminPageSize, strings.Count(w.Body.String(), `name="id"`))
+		assert.Contains(t, w.Body.String(), `href="/history?page=2"`)
+		assert.NotContains(t, w.Body.String(), "Last item")
+
+		w = testAdminRequest(a, http.MethodGet, "/history?page=2", nil)
+		assert.Equal(t, 1, strings.Count(w.Body.String(), `name="id"`))
+		assert.Contains(t, w.Body.String(), "Last item")
+		assert.Contains(t, w.Body.String(), `href="/history?page=1"`)
+	})
+
+	t.Run("resend", func(t *testing.T) {
+		n := &testNotifier{}
+		a, _, s := newTestAdmin(n)
+		s.deliveries = []Delivery{{ID: 7, Item: Item{Feed: "f1", Link: "One"}}}
+
+		w := testAdminRequest(a, http.MethodPost, "/history/resend", url.Values{"id": {"7"}})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/history?sent=7", w.Header().Get("Location"))
+		assert.Equal(t, []Item{{Feed: "f1", Link: "One"}}, n.items)
+		assert.Equal(t, "resend", s.audit[0].Action)
+
+		w = testAdminRequest(a, http.MethodPost, "/history/resend", url.Values{"id": {"8"}})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		w = testAdminRequest(a, http.MethodPost, "/history/resend", url.Values{"id": {"x"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("resend error", func(t *testing.T) {
+		a, _, s := newTestAdmin(&testFailingNotifier{})
+		s.deliveries = []Delivery{{ID: 1, Item: Item{Link: "One"}}}
+
+		w := testAdminRequest(a, http.MethodPost, "/history/resend", url.Values{"id": {"1"}})
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/history?error=fail", w.Header().Get("Location"))
+		assert.Empty(t, s.audit)
+	})
+}
+
+func TestAdmin_Serve(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	a, _, _ := newTestAdmin(&testNotifier{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Serve(ctx, ln) }()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String()+"/", nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth("root", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func newTestAdmin(n Notifier) (*Admin, *FeedRegistry, *testAdminStorage) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	conf := Config{Feeds: []Feed{{URL: "https://example.com/1"}, {URL: "https://example.com/2"}}}
+	r := NewFeedRegistry(conf, &testRegistryStorage{})
+	s := &testAdminStorage{}
+	admin := AdminConfig{Editors: []Editor{{Username: "root", Password: "secret"}}}
+	return NewAdmin(admin, r, newTestControl(r), s, n, log), r, s
+}
+
+func testAdminRequest(a *Admin, method, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("root", "secret")
+	w := httptest.NewRecorder()
+	a.Handler().ServeHTTP(w, req)
+	return w
+}
+
+type testAdminStorage struct {
+	updates    map[string]time.Time
+	deliveries []Delivery
+	audit      []AuditEntry
+	mx         sync.Mutex
+}
+
+func (s *testAdminStorage) LastUpdates() map[string]time.Time {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.updates
+}
+
+func (s *testAdminStorage) SaveLastUpdate(feed string, t time.Time) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.updates == nil {
+		s.updates = map[string]time.Time{}
+	}
+	s.updates[feed] = t
+	return nil
+}
+
+func (s *testAdminStorage) ResetLastUpdate(feed string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	delete(s.updates, feed)
+	return nil
+}
+
+func (s *testAdminStorage) GetDeliveries() []Delivery {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.deliveries
+}
+
+func (s *testAdminStorage) GetDelivery(id int) (Delivery, bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, d := range s.deliveries {
+		if d.ID == id {
+			return d, true
+		}
+	}
+	return Delivery{}, false
+}
+
+func (s *testAdminStorage) AddAuditEntry(e AuditEntry) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.audit = append(s.audit, e)
+	return nil
+}
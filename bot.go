@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,34 +15,211 @@ type Notifier interface {
 	Notify(context.Context, Item) error
 }
 
+// Runner is a component with a background loop, that runs until
+// the context is cancelled.
+type Runner interface {
+	Run(context.Context)
+}
+
+// startRunner starts the background loop of the notifier, if it has one.
+// Returned function waits for the loop to stop.
+func startRunner(ctx context.Context, n Notifier) (wait func()) {
+	r, ok := n.(Runner)
+	if !ok {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	return func() { <-done }
+}
+
+// Updater is a notifier, that can update sent messages of the feed. Current
+// are all items of the feed as they are fetched, and processed are items of
+// sent messages after processing. Messages of items, that are not current,
+// are removed from the feed, and messages of processed items may be edited.
+// Items, that are current, but not processed, e.g. dropped by a filter,
+// keep their messages as is.
+type Updater interface {
+	Update(ctx context.Context, feed string, current, processed []Item) error
+}
+
+// update passes items of the feed to the notifier, if it can update sent
+// messages.
+func update(ctx context.Context, n Notifier, feed string, current, processed []Item) error {
+	u, ok := n.(Updater)
+	if !ok {
+		return nil
+	}
+	return u.Update(ctx, feed, current, processed)
+}
+
 // Fetcher fetches items from the given source.
 type Fetcher interface {
-	Fetch(url string) ([]Item, error)
+	// Fetch returns new items of the feed, and all its current items.
+	Fetch(feed Feed) (items, all []Item, err error)
+}
+
+// Parser reads all current items of the feed.
+type Parser interface {
+	Parse(url string) ([]Item, error)
+}
+
+// FeedSource provides feeds, that are added and removed at runtime.
+type FeedSource interface {
+	Feeds() []Feed
+	// Changed receives a value when the list of feeds changes.
+	Changed() <-chan struct{}
+}
+
+// FeedStopHandler is a feed source, that is notified when fetching of its
+// removed feed is finished, e.g. for resetting the state of the feed.
+type FeedStopHandler interface {
+	FeedStopped(feed string)
+}
+
+// StatusRecorder keeps results of feed fetches.
+type StatusRecorder interface {
+	SetStatus(feed string, items int, err error)
+}
+
+// SentMessages provides sent messages of feeds, that can be updated.
+type SentMessages interface {
+	GetMessages(feed string) []Message
+}
+
+// HistoryStorage keeps delivered items.
+type HistoryStorage interface {
+	AddDelivery(item Item, t time.Time) error
 }
 
 // Bot fetches new items from data feeds, and sends it to all clients.
 type Bot struct {
 	notifier Notifier
 	fetcher  Fetcher
-	feeds    []string
+	feeds    []Feed
 	interval time.Duration
 	log      *logrus.Logger
+
+	// window is a time for collecting items from all feeds before sending
+	// them in order of publication.
+	window time.Duration
+
+	// dedup suppresses items that were already delivered.
+	dedup *Deduplicator
+
+	// messages are sent messages, that can be updated, if they are set.
+	messages SentMessages
+
+	// pipelines process fetched items of each feed before sending.
+	pipelines map[string]*Pipeline
+
+	// sources provide feeds in addition to the static list.
+	sources []FeedSource
+
+	// status keeps results of fetches.
+	status StatusRecorder
+
+	// history keeps delivered items.
+	history HistoryStorage
+
+	// triggers start immediate fetches of running feeds.
+	triggers map[string]chan struct{}
+	mx       *sync.Mutex
+}
+
+// BotOption is an optional bot setting.
+type BotOption func(*Bot)
+
+// WithDeliveryWindow makes the bot collect items from all feeds during
+// the window, and send them ordered by publication time.
+func WithDeliveryWindow(d time.Duration) BotOption {
+	return func(b *Bot) { b.window = d }
+}
+
+// WithDeduplicator makes the bot normalize item links and skip items that
+// were already delivered from any feed.
+func WithDeduplicator(d *Deduplicator) BotOption {
+	return func(b *Bot) { b.dedup = d }
+}
+
+// WithUpdates makes the bot pass all current items of each feed to
+// the notifier after fetching, for editing or deleting sent messages.
+// Only items of sent messages are processed for updates.
+func WithUpdates(m SentMessages) BotOption {
+	return func(b *Bot) { b.messages = m }
+}
+
+// WithPipelines makes the bot pass fetched items of each feed through
+// the feed's pipeline. Pipeline with empty key is used for feeds, that
+// don't have their own pipelines.
+func WithPipelines(p map[string]*Pipeline) BotOption {
+	return func(b *Bot) { b.pipelines = p }
+}
+
+// WithFeedSource makes the bot fetch feeds of the source, in addition to
+// the static list. Fetching starts and stops when the source changes.
+// Feeds, that are provided by multiple sources, are fetched once.
+func WithFeedSource(s FeedSource) BotOption {
+	return func(b *Bot) { b.sources = append(b.sources, s) }
+}
+
+// WithStatus makes the bot report results of each fetch.
+func WithStatus(s StatusRecorder) BotOption {
+	return func(b *Bot) { b.status = s }
+}
+
+// WithHistory makes the bot save delivered items. Service messages are
+// not saved.
+func WithHistory(h HistoryStorage) BotOption {
+	return func(b *Bot) { b.history = h }
 }
 
 // NewBot creates new bot.
-func NewBot(n Notifier, f Fetcher, feeds []string, interval time.Duration, log *logrus.Logger) *Bot {
-	return &Bot{notifier: n, fetcher: f, feeds: feeds, interval: interval, log: log}
+func NewBot(
+	n Notifier,
+	f Fetcher,
+	feeds []Feed,
+	interval time.Duration,
+	log *logrus.Logger,
+	opts ...BotOption,
+) *Bot {
+	b := &Bot{
+		notifier: n,
+		fetcher:  f,
+		feeds:    feeds,
+		interval: interval,
+		log:      log,
+		triggers: map[string]chan struct{}{},
+		mx:       &sync.Mutex{},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 // Run starts listening for updates.
 func (b *Bot) Run(ctx context.Context) {
 	items := make(chan Item)
 
+	// Background loop of the notifier, e.g. for sending digests
+	defer startRunner(ctx, b.notifier)()
+
 	var wg sync.WaitGroup
 	wg.Add(len(b.feeds))
 	for _, f := range b.feeds {
 		go func() {
-			b.runFetches(ctx, f, items)
+			b.runFetches(ctx, func() Feed { return f }, items)
+			wg.Done()
+		}()
+	}
+	if len(b.sources) > 0 {
+		wg.Add(1)
+		go func() {
+			b.runSources(ctx, &wg, items)
 			wg.Done()
 		}()
 	}
@@ -49,36 +228,329 @@ func (b *Bot) Run(ctx context.Context) {
 		close(items)
 	}()
 
-	for item := range items {
-		if err := b.notifier.Notify(ctx, item); err != nil {
-			b.log.Errorf("Failed to send notification: %v", err)
+	b.deliver(ctx, items)
+}
+
+// runSources fetches feeds of the sources, starting and stopping fetching
+// of separate feeds when any source changes. Feeds, whose fetch settings
+// have changed, are restarted, after the previous fetching is finished,
+// and other settings are applied on the next fetch. Sources are notified
+// of removed feeds, when their fetching is finished. Fetching goroutines
+// are added to the wait group.
+func (b *Bot) runSources(ctx context.Context, wg *sync.WaitGroup, out chan Item) {
+	changed := make(chan struct{}, 1)
+	for _, s := range b.sources {
+		go func() {
+			for {
+				select {
+				case <-s.Changed():
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	running := map[string]*fetching{}
+	// Last fetching goroutine of each feed, including stopped ones
+	done := map[string]chan struct{}{}
+	start := func(f Feed) {
+		b.log.Debugf("Start fetching [%s]", f.URL)
+		fctx, cancel := context.WithCancel(ctx)
+		r := &fetching{feed: f, cancel: cancel}
+		running[f.URL] = r
+		prev, finished := done[f.URL], make(chan struct{})
+		done[f.URL] = finished
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(finished)
+			if prev != nil {
+				<-prev
+			}
+			if fctx.Err() == nil {
+				b.runFetches(fctx, r.get, out)
+			}
+		}()
+	}
+	stopped := func(url string, finished chan struct{}) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-finished
+			for _, s := range b.sources {
+				if h, ok := s.(FeedStopHandler); ok {
+					h.FeedStopped(url)
+				}
+			}
+		}()
+	}
+	refresh := func() {
+		current := map[string]bool{}
+		for _, s := range b.sources {
+			for _, f := range s.Feeds() {
+				// The first source of the feed wins
+				if current[f.URL] {
+					continue
+				}
+				current[f.URL] = true
+				r, ok := running[f.URL]
+				if ok && sameFetch(r.get(), f) {
+					r.set(f)
+					continue
+				}
+				if ok {
+					b.log.Debugf("Restart fetching [%s]", f.URL)
+					r.cancel()
+				}
+				start(f)
+			}
+		}
+		for url, r := range running {
+			if !current[url] {
+				b.log.Debugf("Stop fetching [%s]", url)
+				r.cancel()
+				delete(running, url)
+				stopped(url, done[url])
+			}
+		}
+		for url, d := range done {
+			select {
+			case <-d:
+				if running[url] == nil {
+					delete(done, url)
+				}
+			default:
+			}
+		}
+	}
+
+	refresh()
+	for {
+		select {
+		case <-changed:
+			refresh()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fetching is a running fetching of the feed. Settings of the feed, that
+// don't affect fetching, can be changed while it's running.
+type fetching struct {
+	feed   Feed
+	cancel context.CancelFunc
+	mx     sync.Mutex
+}
+
+func (r *fetching) get() Feed {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.feed
+}
+
+func (r *fetching) set(f Feed) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.feed = f
+}
+
+// sameFetch checks if feeds have the same settings of fetching. Settings
+// of processing are not compared, because pipelines are created once.
+func sameFetch(a, b Feed) bool {
+	return a.URL == b.URL && a.CatchUp == b.CatchUp && a.MaxItems == b.MaxItems
+}
+
+// deliver sends items to the notifier. If the delivery window is set, items
+// are buffered from the moment the first one arrives until the window ends,
+// and then sent oldest first.
+func (b *Bot) deliver(ctx context.Context, items <-chan Item) {
+	var buf []Item
+	var timer <-chan time.Time
+	flush := func() {
+		sort.SliceStable(buf, func(i, j int) bool {
+			return buf[i].Published.Before(buf[j].Published)
+		})
+		for _, item := range buf {
+			b.notify(ctx, item)
+		}
+		buf, timer = nil, nil
+	}
+
+	for {
+		select {
+		case item, ok := <-items:
+			if !ok {
+				flush()
+				return
+			}
+			if b.window == 0 {
+				b.notify(ctx, item)
+				continue
+			}
+			buf = append(buf, item)
+			if timer == nil {
+				timer = time.After(b.window)
+			}
+		case <-timer:
+			flush()
+		}
+	}
+}
+
+func (b *Bot) notify(ctx context.Context, item Item) {
+	// Service messages are not deduplicated
+	if b.dedup != nil && item.Text == "" {
+		item.Link = CanonicalURL(item.Link)
+		if b.dedup.Seen(item) {
+			b.log.Debugf("Skip duplicate item [%s]: %s", item.Feed, item.Link)
+			return
+		}
+	}
+
+	if err := b.notifier.Notify(ctx, item); err != nil {
+		b.log.Errorf("Failed to send notification: %v", err)
+		return
+	}
+	if b.history != nil && item.Text == "" {
+		if err := b.history.AddDelivery(item, time.Now()); err != nil {
+			b.log.Errorf("Failed to save delivery: %v", err)
+		}
+	}
+
+	if b.dedup != nil && item.Text == "" {
+		if err := b.dedup.Add(item); err != nil {
+			b.log.Errorf("Failed to save delivered item: %v", err)
 		}
 	}
 }
 
-func (b *Bot) runFetches(ctx context.Context, f string, out chan Item) {
+func (b *Bot) runFetches(ctx context.Context, feed func() Feed, out chan Item) {
+	url := feed().URL
+	trigger := make(chan struct{}, 1)
+	b.mx.Lock()
+	b.triggers[url] = trigger
+	b.mx.Unlock()
+	defer func() {
+		b.mx.Lock()
+		if b.triggers[url] == trigger {
+			delete(b.triggers, url)
+		}
+		b.mx.Unlock()
+	}()
+
 	// Run first fetch when started
-	b.fetch(f, out)
+	b.fetch(ctx, feed(), out)
 
 	t := time.NewTicker(b.interval)
 	defer t.Stop()
 	for {
 		select {
 		case <-t.C:
-			b.fetch(f, out)
+			b.fetch(ctx, feed(), out)
+		case <-trigger:
+			b.log.Debugf("Fetch now [%s]", url)
+			b.fetch(ctx, feed(), out)
+			t.Reset(b.interval)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (b *Bot) fetch(f string, out chan Item) {
-	items, err := b.fetcher.Fetch(f)
+// FetchNow makes the bot fetch the feed immediately, without waiting for
+// the next update. The feed must be fetched by the bot, e.g. not paused.
+func (b *Bot) FetchNow(feed string) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	trigger, ok := b.triggers[feed]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownFeed, feed)
+	}
+	// Pending fetch is enough
+	select {
+	case trigger <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (b *Bot) fetch(ctx context.Context, f Feed, out chan Item) {
+	items, all, err := b.fetcher.Fetch(f)
 	if err != nil {
-		b.log.Errorf("Failed to fetch items [%s]: %v", f, err)
+		b.setStatus(f.URL, 0, err)
+		b.log.Errorf("Failed to fetch items [%s]: %v", f.URL, err)
 		return
 	}
-	for _, item := range items {
-		out <- item
+	fetched := len(items)
+	// Failed items are sent as is, the error is kept in the feed's status
+	items, err = b.process(ctx, f, items)
+	if err != nil {
+		err = fmt.Errorf("process items: %w", err)
+	}
+	b.setStatus(f.URL, fetched, err)
+	// Items are fetched newest first
+	for i := len(items) - 1; i >= 0; i-- {
+		out <- items[i]
+	}
+	b.update(ctx, f, all)
+}
+
+func (b *Bot) setStatus(feed string, items int, err error) {
+	if b.status != nil {
+		b.status.SetStatus(feed, items, err)
+	}
+}
+
+// update passes current items of the feed to the notifier, so it can edit
+// or delete sent messages. Only items of sent messages are processed, and
+// get the same changes as the fetched ones. Pipelines keep results of
+// items, so they are not processed again until they change. Items, that
+// fail processing, are not updated, so their messages are kept as is.
+func (b *Bot) update(ctx context.Context, f Feed, all []Item) {
+	if b.messages == nil || len(all) == 0 {
+		return
+	}
+	sent := map[string]bool{}
+	for _, m := range b.messages.GetMessages(f.URL) {
+		sent[m.GUID] = true
+	}
+	var items []Item
+	for _, item := range all {
+		if !sent[item.GUID] {
+			continue
+		}
+		res, err := b.process(ctx, f, []Item{item})
+		if err != nil {
+			continue
+		}
+		items = append(items, res...)
+	}
+	if b.dedup != nil {
+		for i := range items {
+			items[i].Link = CanonicalURL(items[i].Link)
+		}
+	}
+	if err := update(ctx, b.notifier, f.URL, all, items); err != nil {
+		b.log.Errorf("Failed to update messages [%s]: %v", f.URL, err)
+	}
+}
+
+// process passes items through the feed's pipeline, or the default one.
+// Failures are logged by the pipeline, and failed items are kept as is.
+func (b *Bot) process(ctx context.Context, f Feed, items []Item) ([]Item, error) {
+	p, ok := b.pipelines[f.URL]
+	if !ok {
+		p, ok = b.pipelines[""]
+	}
+	if !ok || len(items) == 0 {
+		return items, nil
 	}
+	return p.Process(ctx, items)
 }
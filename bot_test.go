@@ -16,10 +16,15 @@ import (
 func TestNewBot(t *testing.T) {
 	log := logrus.New()
 	log.Out = io.Discard
-	b := NewBot(&testNotifier{}, &testFetcher{}, []string{"test"}, 5*time.Second, log)
+	b := NewBot(&testNotifier{}, &testFetcher{}, []Feed{{URL: "test"}}, 5*time.Second, log)
 	assert.NotNil(t, b.notifier)
 	assert.Len(t, b.feeds, 1)
 	assert.Equal(t, b.interval, 5*time.Second)
+	assert.Zero(t, b.window)
+
+	b = NewBot(&testNotifier{}, &testFetcher{}, nil, time.Second, log,
+		WithDeliveryWindow(time.Minute))
+	assert.Equal(t, time.Minute, b.window)
 }
 
 func TestBot_Run(t *testing.T) {
@@ -37,7 +42,7 @@ func TestBot_Run(t *testing.T) {
 				"f2": {{Link: "Three"}, {Link: "Four"}},
 			},
 		}
-		b := NewBot(n, f, []string{"f1", "f2"}, 1*time.Millisecond, log)
+		b := NewBot(n, f, []Feed{{URL: "f1"}, {URL: "f2"}}, 1*time.Millisecond, log)
 
 		b.Run(ctx)
 
@@ -47,6 +52,403 @@ func TestBot_Run(t *testing.T) {
 		assert.ElementsMatch(t, expected, n.items)
 	})
 
+	t.Run("oldest first", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		ts := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+		n := &testNotifier{}
+		f := &testFetcher{
+			items: map[string][]Item{
+				"f1": {
+					{Link: "Two", Published: ts.Add(time.Minute)},
+					{Link: "One", Published: ts},
+				},
+			},
+		}
+		b := NewBot(n, f, []Feed{{URL: "f1"}}, 1*time.Millisecond, log)
+
+		b.Run(ctx)
+
+		expected := []Item{
+			{Link: "One", Published: ts},
+			{Link: "Two", Published: ts.Add(time.Minute)},
+		}
+		assert.Equal(t, expected, n.items)
+	})
+
+	t.Run("delivery window", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		ts := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+		n := &testNotifier{}
+		f := &testFetcher{
+			items: map[string][]Item{
+				"f1": {
+					{Link: "Three", Published: ts.Add(3 * time.Minute)},
+					{Link: "One", Published: ts.Add(1 * time.Minute)},
+				},
+				"f2": {
+					{Link: "Four", Published: ts.Add(4 * time.Minute)},
+					{Link: "Two", Published: ts.Add(2 * time.Minute)},
+				},
+			},
+		}
+		b := NewBot(n, f, []Feed{{URL: "f1"}, {URL: "f2"}}, 1*time.Millisecond, log,
+			WithDeliveryWindow(10*time.Millisecond))
+
+		b.Run(ctx)
+
+		links := make([]string, len(n.items))
+		for i, item := range n.items {
+			links[i] = item.Link
+		}
+		assert.Equal(t, []string{"One", "Two", "Three", "Four"}, links)
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		n := &testNotifier{}
+		f := &testFetcher{
+			items: map[string][]Item{
+				"f1": {{Link: "https://example.com/one?utm_source=f1"}},
+				"f2": {{Link: "https://example.com/one#top"}, {Link: "https://example.com/two"}},
+			},
+		}
+		d := NewDeduplicator(&testSeenStorage{seen: map[string]time.Time{}}, time.Hour, false)
+		b := NewBot(n, f, []Feed{{URL: "f1"}, {URL: "f2"}}, 1*time.Millisecond, log,
+			WithDeduplicator(d))
+
+		b.Run(ctx)
+
+		expected := []Item{
+			{Link: "https://example.com/one"},
+			{Link: "https://example.com/two"},
+		}
+		assert.ElementsMatch(t, expected, n.items)
+	})
+
+	t.Run("pipelines", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		n := &testNotifier{}
+		f := &testFetcher{
+			items: map[string][]Item{
+				"f1": {{Link: "One"}, {Link: "drop"}, {Text: "...and 3 more"}},
+				"f2": {{Link: "Two"}},
+			},
+		}
+		p := NewPipeline("f1", log)
+		p.Add("test", &testProcessor{})
+		b := NewBot(n, f, []Feed{{URL: "f1"}, {URL: "f2"}}, 1*time.Millisecond, log,
+			WithPipelines(map[string]*Pipeline{"f1": p}))
+
+		b.Run(ctx)
+
+		expected := []Item{
+			{Text: "...and 3 more"},
+			{Link: "One", Title: "processed"},
+			{Link: "Two"},
+		}
+		assert.ElementsMatch(t, expected, n.items)
+	})
+
+	t.Run("feed source", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		n := &testNotifier{}
+		f := &testFetcher{
+			items: map[string][]Item{
+				"f1": {{Link: "One"}},
+				"f2": {{Link: "Two"}},
+				"f3": {{Link: "Three"}},
+			},
+		}
+		s := &testFeedSource{feeds: []Feed{{URL: "f2"}}, changed: make(chan struct{})}
+		p := NewPipeline("users", log)
+		p.Add("test", &testProcessor{})
+		b := NewBot(n, f, []Feed{{URL: "f1"}}, 1*time.Millisecond, log,
+			WithFeedSource(s),
+			WithPipelines(map[string]*Pipeline{"": p}))
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			s.set([]Feed{{URL: "f3"}})
+			s.changed <- struct{}{}
+		}()
+		b.Run(ctx)
+
+		// Default pipeline is used for all feeds without pipelines
+		expected := []Item{
+			{Link: "One", Title: "processed"},
+			{Link: "Two", Title: "processed"},
+			{Link: "Three", Title: "processed"},
+		}
+		assert.ElementsMatch(t, expected, n.items)
+		// Sources are notified of removed feeds
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		assert.Equal(t, []string{"f2"}, s.stopped)
+	})
+
+	t.Run("changed feed", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		f := &testFetcher{}
+		s := &testFeedSource{feeds: []Feed{{URL: "f1", Title: "Old"}}, changed: make(chan struct{})}
+		b := NewBot(&testNotifier{}, f, nil, time.Hour, log, WithFeedSource(s))
+
+		go func() {
+			// Unchanged feeds keep running
+			time.Sleep(10 * time.Millisecond)
+			s.changed <- struct{}{}
+			// Settings, that don't affect fetching, are used on the next fetch
+			time.Sleep(10 * time.Millisecond)
+			s.set([]Feed{{URL: "f1", Title: "New"}})
+			s.changed <- struct{}{}
+			time.Sleep(5 * time.Millisecond)
+			assert.NoError(t, b.FetchNow("f1"))
+			// Feeds with changed fetch settings are restarted
+			time.Sleep(10 * time.Millisecond)
+			s.set([]Feed{{URL: "f1", Title: "New", MaxItems: 1}})
+			s.changed <- struct{}{}
+		}()
+		b.Run(ctx)
+
+		f.mx.Lock()
+		defer f.mx.Unlock()
+		assert.Equal(t, 3, f.fetches["f1"])
+		assert.Equal(t, Feed{URL: "f1", Title: "New", MaxItems: 1}, f.feeds["f1"])
+	})
+
+	t.Run("restart after fetch", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		f := &testFetcher{delay: 20 * time.Millisecond}
+		s := &testFeedSource{feeds: []Feed{{URL: "f1"}}, changed: make(chan struct{})}
+		b := NewBot(&testNotifier{}, f, nil, time.Hour, log, WithFeedSource(s))
+
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			s.set([]Feed{{URL: "f1", MaxItems: 1}})
+			s.changed <- struct{}{}
+		}()
+		b.Run(ctx)
+
+		// Restarted feed waits for the running fetch
+		f.mx.Lock()
+		defer f.mx.Unlock()
+		assert.Equal(t, 2, f.fetches["f1"])
+		assert.False(t, f.overlap)
+	})
+
+	t.Run("stop after fetch", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		f := &testFetcher{delay: 20 * time.Millisecond}
+		var active int
+		s := &testFeedSource{feeds: []Feed{{URL: "f1"}}, changed: make(chan struct{})}
+		s.onStop = func() {
+			f.mx.Lock()
+			defer f.mx.Unlock()
+			active = f.active
+		}
+		b := NewBot(&testNotifier{}, f, nil, time.Hour, log, WithFeedSource(s))
+
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			s.set(nil)
+			s.changed <- struct{}{}
+		}()
+		b.Run(ctx)
+
+		// Source is notified, when the running fetch is finished
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		assert.Equal(t, []string{"f1"}, s.stopped)
+		assert.Zero(t, active)
+	})
+
+	t.Run("status and history", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		n := &testNotifier{}
+		f := &testFetcher{
+			items: map[string][]Item{"f1": {{Link: "One"}, {Text: "And 1 more"}}},
+		}
+		s := &testStatusRecorder{}
+		h := &testHistoryStorage{}
+		b := NewBot(n, f, []Feed{{URL: "f1"}}, 1*time.Millisecond, log,
+			WithStatus(s),
+			WithHistory(h))
+
+		b.Run(ctx)
+
+		// Service messages are not saved
+		assert.Len(t, n.items, 2)
+		assert.Equal(t, []Item{{Link: "One"}}, h.items)
+		assert.Equal(t, 2, s.items["f1"])
+	})
+
+	t.Run("fetch now", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		n := &testNotifier{}
+		f := &testFetcher{}
+		b := NewBot(n, f, []Feed{{URL: "f1"}}, time.Hour, log)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			assert.NoError(t, b.FetchNow("f1"))
+			assert.ErrorIs(t, b.FetchNow("f2"), ErrUnknownFeed)
+		}()
+		b.Run(ctx)
+
+		assert.Equal(t, 2, f.fetches["f1"])
+		// Stopped feeds can't be fetched
+		assert.ErrorIs(t, b.FetchNow("f1"), ErrUnknownFeed)
+	})
+
+	t.Run("processing error", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := logrus.New()
+		log.SetOutput(&buf)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+		defer cancel()
+
+		n := &testNotifier{}
+		f := &testFetcher{
+			items: map[string][]Item{"f1": {{Feed: "f1", Link: "fail"}}},
+		}
+		p := NewPipeline("f1", log)
+		p.Add("test", &testProcessor{})
+		s := &testStatusRecorder{}
+		b := NewBot(n, f, []Feed{{URL: "f1"}}, 1*time.Millisecond, log,
+			WithPipelines(map[string]*Pipeline{"f1": p}),
+			WithStatus(s))
+
+		b.Run(ctx)
+
+		// Items are sent unchanged, the error is kept in the status
+		assert.Equal(t, []Item{{Feed: "f1", Link: "fail"}}, n.items)
+		assert.Contains(t, buf.String(), "level=warning")
+		assert.Contains(t, buf.String(), "Failed to process item [f1]: test [fail]: fail")
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		assert.EqualError(t, s.errors["f1"], "process items: test [fail]: fail")
+	})
+
+	t.Run("updates", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+		defer cancel()
+
+		log := logrus.New()
+		log.Out = io.Discard
+
+		n := &testUpdater{}
+		f := &testFetcher{
+			all: map[string][]Item{
+				"f1": {
+					{GUID: "1", Link: "https://example.com/one?utm_source=f1"},
+					{GUID: "2", Link: "drop"},
+					{GUID: "3", Link: "fail"},
+					{GUID: "4", Link: "https://example.com/new"},
+				},
+			},
+		}
+		s := &testMessageStorage{messages: []Message{
+			{Feed: "f1", GUID: "1"}, {Feed: "f1", GUID: "2"}, {Feed: "f1", GUID: "3"}, {Feed: "f2", GUID: "4"},
+		}}
+		d := NewDeduplicator(&testSeenStorage{seen: map[string]time.Time{}}, time.Hour, false)
+		pl := NewPipeline("f1", log)
+		pl.Add("test", &testProcessor{})
+		feeds := []Feed{{URL: "f1", Title: "Feed", Category: "News"}}
+		b := NewBot(n, f, feeds, 1*time.Millisecond, log,
+			WithDeduplicator(d), WithUpdates(s), WithPipelines(map[string]*Pipeline{"f1": pl}))
+
+		b.Run(ctx)
+
+		n.mx.Lock()
+		defer n.mx.Unlock()
+		// All fetched items are current
+		current := []Item{
+			{GUID: "1", Link: "https://example.com/one?utm_source=f1", FeedTitle: "Feed", Category: "News"},
+			{GUID: "2", Link: "drop", FeedTitle: "Feed", Category: "News"},
+			{GUID: "3", Link: "fail", FeedTitle: "Feed", Category: "News"},
+			{GUID: "4", Link: "https://example.com/new", FeedTitle: "Feed", Category: "News"},
+		}
+		assert.Equal(t, current, n.current["f1"])
+		// Only items of sent messages are processed, failed items are
+		// not updated
+		processed := []Item{
+			{GUID: "1", Link: "https://example.com/one", Title: "processed", FeedTitle: "Feed", Category: "News"},
+		}
+		assert.Equal(t, processed, n.updates["f1"])
+	})
+
+	t.Run("update error", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := logrus.New()
+		log.SetOutput(&buf)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+		defer cancel()
+
+		n := &testUpdater{}
+		f := &testFetcher{
+			all: map[string][]Item{"f1": {{Link: "https://example.com/one"}}},
+			err: errors.New("fail"),
+		}
+		b := NewBot(n, f, []Feed{{URL: "f1"}}, 1*time.Millisecond, log,
+			WithUpdates(&testMessageStorage{}))
+
+		b.Run(ctx)
+
+		assert.Contains(t, buf.String(), "Failed to fetch items [f1]: fail")
+		assert.Empty(t, n.updates)
+	})
+
 	t.Run("no data", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 
@@ -55,7 +457,7 @@ func TestBot_Run(t *testing.T) {
 
 		n := &testNotifier{}
 		f := &testFetcher{}
-		b := NewBot(n, f, []string{"f1", "f2"}, 1*time.Millisecond, log)
+		b := NewBot(n, f, []Feed{{URL: "f1"}, {URL: "f2"}}, 1*time.Millisecond, log)
 
 		cancel()
 		b.Run(ctx)
@@ -73,7 +475,7 @@ func TestBot_Run(t *testing.T) {
 
 		n := &testNotifier{}
 		f := &testFetcher{err: errors.New("fail")}
-		b := NewBot(n, f, []string{"f1"}, 1*time.Millisecond, log)
+		b := NewBot(n, f, []Feed{{URL: "f1"}}, 1*time.Millisecond, log)
 
 		b.Run(ctx)
 
@@ -90,23 +492,166 @@ func (n *testNotifier) Notify(_ context.Context, item Item) error {
 	return nil
 }
 
+type testUpdater struct {
+	testNotifier
+	current map[string][]Item
+	updates map[string][]Item
+	mx      sync.Mutex
+}
+
+func (u *testUpdater) Update(_ context.Context, feed string, current, processed []Item) error {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+
+	if u.updates == nil {
+		u.current = map[string][]Item{}
+		u.updates = map[string][]Item{}
+	}
+	u.current[feed] = current
+	u.updates[feed] = processed
+	return nil
+}
+
+type testFeedSource struct {
+	feeds   []Feed
+	changed chan struct{}
+	stopped []string
+	onStop  func()
+	mx      sync.Mutex
+}
+
+func (s *testFeedSource) Feeds() []Feed {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.feeds
+}
+
+func (s *testFeedSource) Changed() <-chan struct{} {
+	return s.changed
+}
+
+func (s *testFeedSource) FeedStopped(feed string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.onStop != nil {
+		s.onStop()
+	}
+	s.stopped = append(s.stopped, feed)
+}
+
+func (s *testFeedSource) set(feeds []Feed) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.feeds = feeds
+}
+
+// testProcessor drops items with "drop" link, fails on items with "fail"
+// link, and sets title of other items.
+type testProcessor struct{}
+
+func (p *testProcessor) Process(_ context.Context, item Item) ([]Item, error) {
+	switch item.Link {
+	case "drop":
+		return nil, nil
+	case "fail":
+		return nil, errors.New("fail")
+	default:
+		item.Title = "processed"
+		return []Item{item}, nil
+	}
+}
+
+// testFetcher returns items of each feed on the first fetch, and current
+// items on every fetch.
 type testFetcher struct {
-	items map[string][]Item
-	err   error
-	done  map[string]bool
-	mx    sync.Mutex
+	items   map[string][]Item
+	all     map[string][]Item
+	err     error
+	delay   time.Duration
+	done    map[string]bool
+	fetches map[string]int
+	feeds   map[string]Feed
+	active  int
+	overlap bool
+	mx      sync.Mutex
 }
 
-func (f *testFetcher) Fetch(url string) ([]Item, error) {
+func (f *testFetcher) Fetch(feed Feed) ([]Item, []Item, error) {
+	if f.delay > 0 {
+		f.mx.Lock()
+		f.active++
+		f.overlap = f.overlap || f.active > 1
+		f.mx.Unlock()
+		time.Sleep(f.delay)
+		defer func() {
+			f.mx.Lock()
+			f.active--
+			f.mx.Unlock()
+		}()
+	}
+
 	f.mx.Lock()
 	defer f.mx.Unlock()
 
 	if f.done == nil {
 		f.done = map[string]bool{}
+		f.fetches = map[string]int{}
+		f.feeds = map[string]Feed{}
 	}
-	if f.done[url] {
-		return nil, nil
+	f.fetches[feed.URL]++
+	f.feeds[feed.URL] = feed
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	all := make([]Item, len(f.all[feed.URL]))
+	for i, item := range f.all[feed.URL] {
+		all[i] = feed.annotate(item)
+	}
+	if f.done[feed.URL] {
+		return nil, all, nil
 	}
-	f.done[url] = true
-	return f.items[url], f.err
+	f.done[feed.URL] = true
+	return f.items[feed.URL], all, nil
+}
+
+type testParser struct {
+	items map[string][]Item
+	err   error
+}
+
+func (p *testParser) Parse(url string) ([]Item, error) {
+	return p.items[url], p.err
+}
+
+type testStatusRecorder struct {
+	items  map[string]int
+	errors map[string]error
+	mx     sync.Mutex
+}
+
+func (r *testStatusRecorder) SetStatus(feed string, items int, err error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.items == nil {
+		r.items = map[string]int{}
+		r.errors = map[string]error{}
+	}
+	// Empty fetches are not interesting
+	if items > 0 {
+		r.items[feed] = items
+		r.errors[feed] = err
+	}
+}
+
+type testHistoryStorage struct {
+	items []Item
+}
+
+func (h *testHistoryStorage) AddDelivery(item Item, _ time.Time) error {
+	h.items = append(h.items, item)
+	return nil
 }
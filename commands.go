@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runCheck validates the config and probes every feed.
+func runCheck(configFile string, w io.Writer) error {
+	conf, err := ReadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+
+	var failed int
+	for _, r := range NewRSSFetcher(nil).ProbeFeeds(conf.Feeds) {
+		if r.Err != nil {
+			failed++
+		}
+		fmt.Fprintln(w, r)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d feeds failed", failed, len(conf.Feeds))
+	}
+	return nil
+}
+
+// runFetch prints parsed items of the feed.
+//
+//	feed-bot fetch <url>
+func runFetch(args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: fetch <url>")
+	}
+	items, err := NewRSSFetcher(nil).Parse(args[0])
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		fmt.Fprintln(w, item)
+	}
+	return nil
+}
+
+// runState prints or edits the state of the feeds.
+//
+//	feed-bot state show
+//	feed-bot state reset [feed]
+//	feed-bot state set <feed> <time>
+//	feed-bot state audit
+func runState(configFile string, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: state show | state reset [feed] | state set <feed> <time> | state audit")
+	}
+
+	conf, err := ReadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	fs, err := NewFileStorage(conf.DataFile)
+	if err != nil {
+		return fmt.Errorf("init state storage: %w", err)
+	}
+
+	switch args[0] {
+	case "show":
+		updates := fs.LastUpdates()
+		feeds := make([]string, 0, len(updates))
+		for f := range updates {
+			feeds = append(feeds, f)
+		}
+		sort.Strings(feeds)
+		for _, f := range feeds {
+			fmt.Fprintf(w, "%s %s\n", updates[f].Format(time.RFC3339), f)
+		}
+		return nil
+	case "reset":
+		if len(args) > 2 {
+			return errors.New("usage: state reset [feed]")
+		}
+		if len(args) == 2 {
+			return fs.ResetLastUpdate(args[1])
+		}
+		for f := range fs.LastUpdates() {
+			if err := fs.ResetLastUpdate(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "set":
+		if len(args) != 3 {
+			return errors.New("usage: state set <feed> <time>")
+		}
+		t, err := time.Parse(time.RFC3339, args[2])
+		if err != nil {
+			return fmt.Errorf("parse time: %w", err)
+		}
+		return fs.SaveLastUpdate(args[1], t)
+	case "audit":
+		for _, e := range fs.GetAuditLog() {
+			fmt.Fprintln(w, e)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown state command: %s", args[0])
+	}
+}
+
+// runFeed changes the feed of the running bot through the control socket.
+//
+//	feed-bot feed pause <url>
+//	feed-bot feed resume <url>
+//	feed-bot feed fetch <url>
+func runFeed(configFile string, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: feed pause <url> | feed resume <url> | feed fetch <url>")
+	}
+	switch args[0] {
+	case "pause", "resume", "fetch":
+	default:
+		return fmt.Errorf("unknown feed command: %s", args[0])
+	}
+
+	conf, err := ReadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	if conf.ControlSocket == "" {
+		return errors.New("control socket is not set in the config")
+	}
+	return SendControl(conf.ControlSocket, args[0], args[1])
+}
+
+// runSendTest sends a single test message through the configured notifier.
+//
+//	feed-bot send-test [link]
+func runSendTest(ctx context.Context, configFile string, args []string, log *logrus.Logger) error {
+	conf, err := ReadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	log.SetLevel(logrus.DebugLevel)
+
+	// Digests and quiet hours are bypassed, so the message is sent
+	// immediately
+	notifier, err := newNotifier(conf, nil, nil, log)
+	if err != nil {
+		return err
+	}
+
+	item := Item{
+		Published: time.Now(),
+		Link:      "https://github.com/tetafro/feed-bot",
+	}
+	if len(args) > 0 {
+		item.Link = args[0]
+	}
+	return notifier.Notify(ctx, item)
+}
+
+// runOPML imports feeds from an OPML file into the config file, skipping
+// invalid ones, or exports feeds as OPML. Exported feeds are feeds of
+// the config with changes made at runtime, e.g. in the admin UI, including
+// paused ones.
+//
+//	feed-bot opml import <file>
+//	feed-bot opml export [file]
+func runOPML(configFile string, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: opml import <file> | opml export [file]")
+	}
+
+	switch args[0] {
+	case "import":
+		if len(args) != 2 {
+			return errors.New("usage: opml import <file>")
+		}
+		f, err := os.Open(args[1])
+		if err != nil {
+			return fmt.Errorf("open file: %w", err)
+		}
+		defer f.Close()
+
+		feeds, err := ReadOPML(f)
+		if err != nil {
+			return fmt.Errorf("read opml: %w", err)
+		}
+		// Invalid feeds are skipped, the same as in /add command
+		valid := make([]Feed, 0, len(feeds))
+		for _, feed := range feeds {
+			if err := validateFeedURL(feed.URL); err != nil {
+				fmt.Fprintf(w, "Skipped invalid feed URL %q: %v\n", feed.URL, err)
+				continue
+			}
+			valid = append(valid, feed)
+		}
+		n, err := ImportFeeds(configFile, valid)
+		if err != nil {
+			return fmt.Errorf("import feeds: %w", err)
+		}
+		fmt.Fprintf(w, "Imported %d of %d feeds\n", n, len(feeds))
+		return nil
+	case "export":
+		conf, err := ReadConfig(configFile)
+		if err != nil {
+			return fmt.Errorf("read config: %w", err)
+		}
+		fs, err := NewFileStorage(conf.DataFile)
+		if err != nil {
+			return fmt.Errorf("init state storage: %w", err)
+		}
+		list := NewFeedRegistry(conf, fs).List()
+		feeds := make([]Feed, len(list))
+		for i, info := range list {
+			feeds[i] = info.Feed
+		}
+		if len(args) == 1 {
+			return WriteOPML(w, feeds)
+		}
+		f, err := os.Create(args[1])
+		if err != nil {
+			return fmt.Errorf("create file: %w", err)
+		}
+		if err := WriteOPML(f, feeds); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	default:
+		return fmt.Errorf("unknown opml command: %s", args[0])
+	}
+}
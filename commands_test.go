@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCheck(t *testing.T) {
+	server := httptest.NewServer(&testRSSServer{data: true})
+	defer server.Close()
+	failing := httptest.NewServer(&testRSSServer{err: true})
+	defer failing.Close()
+
+	t.Run("all feeds ok", func(t *testing.T) {
+		conf := testConfigFile(t, "debug: true\nfeeds: [\""+server.URL+"\"]\n")
+
+		var buf bytes.Buffer
+		assert.NoError(t, runCheck(conf, &buf))
+		assert.Equal(t, "OK   "+server.URL+": 1 items from 2020-01-01 15:00 to 2020-01-01 15:00\n", buf.String())
+	})
+
+	t.Run("failed feed", func(t *testing.T) {
+		conf := testConfigFile(t, "debug: true\n"+
+			"feeds: [\""+server.URL+"\", \""+failing.URL+"\"]\n")
+
+		var buf bytes.Buffer
+		assert.EqualError(t, runCheck(conf, &buf), "1 of 2 feeds failed")
+		assert.Contains(t, buf.String(),
+			"FAIL "+failing.URL+": parse url: http error: 500 Internal Server Error\n")
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		assert.ErrorContains(t, runCheck("abc.yaml", io.Discard), "read config")
+	})
+}
+
+func TestRunFetch(t *testing.T) {
+	server := httptest.NewServer(&testRSSServer{data: true})
+	defer server.Close()
+
+	var buf bytes.Buffer
+	assert.NoError(t, runFetch([]string{server.URL}, &buf))
+	assert.Equal(t, "[2020-01-01 15:00] https://example.com/content/\n", buf.String())
+
+	assert.EqualError(t, runFetch(nil, &buf), "usage: fetch <url>")
+}
+
+func TestRunState(t *testing.T) {
+	data := testConfigFile(t, "")
+	conf := testConfigFile(t, "debug: true\n"+
+		"data_file: "+data+"\n"+
+		"feeds: [\"https://example.com/rss.xml\"]\n")
+
+	var buf bytes.Buffer
+	assert.NoError(t, runState(conf, []string{"set", "feed1", "2020-01-01T10:00:00Z"}, &buf))
+	assert.NoError(t, runState(conf, []string{"set", "feed2", "2020-01-02T10:00:00Z"}, &buf))
+	assert.NoError(t, runState(conf, []string{"show"}, &buf))
+	assert.Equal(t,
+		"2020-01-01T10:00:00Z feed1\n"+
+			"2020-01-02T10:00:00Z feed2\n",
+		buf.String())
+
+	buf.Reset()
+	assert.NoError(t, runState(conf, []string{"reset", "feed1"}, &buf))
+	assert.NoError(t, runState(conf, []string{"show"}, &buf))
+	assert.Equal(t, "2020-01-02T10:00:00Z feed2\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, runState(conf, []string{"reset"}, &buf))
+	assert.NoError(t, runState(conf, []string{"show"}, &buf))
+	assert.Equal(t, "", buf.String())
+
+	audit := "audit:\n" +
+		"- time: 2020-01-01T10:00:00Z\n" +
+		"  user: 1\n" +
+		"  role: admin\n" +
+		"  chat: -100\n" +
+		"  action: add\n" +
+		"  target: https://example.com/rss.xml\n"
+	assert.NoError(t, os.WriteFile(data, []byte(audit), 0o600))
+	assert.NoError(t, runState(conf, []string{"audit"}, &buf))
+	assert.Equal(t, "2020-01-01T10:00:00Z 1 (admin) add https://example.com/rss.xml in -100\n", buf.String())
+
+	assert.ErrorContains(t, runState(conf, []string{"set", "feed1", "yesterday"}, &buf), "parse time")
+	assert.EqualError(t, runState(conf, []string{"drop"}, &buf), "unknown state command: drop")
+}
+
+func TestRunFeed(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	socket := filepath.Join(os.TempDir(), fmt.Sprintf("feed-bot-testing-%d.sock", time.Now().UnixNano()))
+	ln, err := listenControl(socket)
+	assert.NoError(t, err)
+	info, err := os.Stat(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	r := NewFeedRegistry(Config{Feeds: []Feed{{URL: "https://example.com/rss.xml"}}}, &testRegistryStorage{})
+	trigger := &testTrigger{known: r.Has}
+	c := NewControl(r, trigger, nil, log)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+	go c.Serve(ctx, ln) //nolint:errcheck
+
+	conf := testConfigFile(t, "debug: true\n"+
+		"control_socket: "+socket+"\n"+
+		"feeds: [\"https://example.com/rss.xml\"]\n")
+	assert.NoError(t, runFeed(conf, []string{"fetch", "https://example.com/rss.xml"}))
+	assert.Equal(t, []string{"https://example.com/rss.xml"}, trigger.feeds)
+	assert.NoError(t, runFeed(conf, []string{"pause", "https://example.com/rss.xml"}))
+	assert.Empty(t, r.Feeds())
+
+	assert.EqualError(t, runFeed(conf, []string{"resume", "https://example.com/atom.xml"}),
+		"unknown feed: https://example.com/atom.xml")
+	assert.EqualError(t, runFeed(conf, []string{"drop", "https://example.com/rss.xml"}),
+		"unknown feed command: drop")
+	assert.EqualError(t, runFeed(conf, nil), "usage: feed pause <url> | feed resume <url> | feed fetch <url>")
+
+	noSocket := testConfigFile(t, "debug: true\nfeeds: [\"https://example.com/rss.xml\"]\n")
+	assert.EqualError(t, runFeed(noSocket, []string{"pause", "https://example.com/rss.xml"}),
+		"control socket is not set in the config")
+}
+
+func TestRunSendTest(t *testing.T) {
+	conf := testConfigFile(t, "debug: true\nfeeds: [\"https://example.com/rss.xml\"]\n")
+
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+
+	err := runSendTest(context.Background(), conf, []string{"https://example.com/test"}, log)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "New item: ")
+	assert.Contains(t, buf.String(), "https://example.com/test")
+}
+
+func TestRunOPML(t *testing.T) {
+	data := testConfigFile(t, "feed_changes:\n"+
+		"  added: [{url: https://example.com/added.xml}]\n"+
+		"  removed: [https://example.com/removed.xml]\n")
+	conf := testConfigFile(t, "debug: true\n"+
+		"data_file: "+data+"\n"+
+		"feeds: [\"https://example.com/rss.xml\", \"https://example.com/removed.xml\"]\n")
+	opmlFile := testConfigFile(t, `<opml version="2.0"><body>`+
+		`<outline text="Atom" xmlUrl="https://example.com/atom.xml"/>`+
+		`<outline text="File" xmlUrl="file:///etc/passwd"/>`+
+		`</body></opml>`)
+
+	var buf bytes.Buffer
+	assert.NoError(t, runOPML(conf, []string{"import", opmlFile}, &buf))
+	assert.Equal(t, "Skipped invalid feed URL \"file:///etc/passwd\": scheme must be http or https\n"+
+		"Imported 1 of 2 feeds\n", buf.String())
+
+	// Feeds are exported with changes made at runtime
+	buf.Reset()
+	assert.NoError(t, runOPML(conf, []string{"export"}, &buf))
+	assert.Contains(t, buf.String(), `xmlUrl="https://example.com/rss.xml"`)
+	assert.Contains(t, buf.String(), `xmlUrl="https://example.com/atom.xml"`)
+	assert.Contains(t, buf.String(), `xmlUrl="https://example.com/added.xml"`)
+	assert.NotContains(t, buf.String(), `xmlUrl="https://example.com/removed.xml"`)
+
+	assert.EqualError(t, runOPML(conf, []string{"sync"}, &buf), "unknown opml command: sync")
+}
+
+// testConfigFile writes data to a temporary file, that is removed after
+// the test.
+func testConfigFile(t *testing.T, data string) string {
+	t.Helper()
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().UnixNano()),
+	)
+	assert.NoError(t, os.WriteFile(f, []byte(data), 0o600))
+	t.Cleanup(func() { os.Remove(f) })
+	return f
+}
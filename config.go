@@ -3,7 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"reflect"
+	"slices"
+	"strconv"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -11,20 +15,313 @@ import (
 
 // Config represents application configuration.
 type Config struct {
-	TelegramToken  string        `yaml:"telegram_token"`
+	TelegramToken  string        `yaml:"telegram_token" secret:"true"`
 	TelegramChat   string        `yaml:"telegram_chat"`
 	UpdateInterval time.Duration `yaml:"update_interval"`
 	DataFile       string        `yaml:"data_file"`
-	Feeds          []string      `yaml:"feeds"`
+	Feeds          []Feed        `yaml:"feeds"`
+
+	// CatchUp and MaxItems are defaults for all feeds, see Feed.
+	CatchUp  string `yaml:"catch_up"`
+	MaxItems int    `yaml:"max_items"`
+
+	// DeliveryWindow is a time for collecting new items from all feeds
+	// before sending them in order of publication. Zero value means
+	// sending items as soon as they are fetched.
+	DeliveryWindow time.Duration `yaml:"delivery_window"`
+
+	// DedupWindow is a time during which items with the same canonical URL,
+	// or the same title and description, are delivered only once across
+	// all feeds. In multi-tenant mode items are matched only within their
+	// feed. Zero value disables deduplication.
+	DedupWindow time.Duration `yaml:"dedup_window"`
+
+	// Destinations are chats that receive items. If not set, all items
+	// are sent to TelegramChat.
+	Destinations []Destination `yaml:"destinations"`
+
+	// TelegramTokenFile is a path to a file with the token, e.g. a mounted
+	// secret. Overrides TelegramToken from the config file, but not from
+	// the environment.
+	TelegramTokenFile string `yaml:"telegram_token_file"`
+
+	// TelegramAPIURL is a base URL of Telegram Bot API server, e.g. a local
+	// telegram-bot-api server. TelegramProxy is an HTTP or SOCKS5 proxy URL
+	// for API requests, and TelegramTimeout is a timeout of a single request.
+	TelegramAPIURL  string        `yaml:"telegram_api_url"`
+	TelegramProxy   string        `yaml:"telegram_proxy" secret:"true"`
+	TelegramTimeout time.Duration `yaml:"telegram_timeout"`
+
+	// Translation is a translation service for feeds, that have
+	// translation enabled.
+	Translation *TranslationConfig `yaml:"translation"`
+
+	// Processors are processing stages for items of all feeds. They run
+	// after processors of the feed.
+	Processors []ProcessorConfig `yaml:"processors"`
+
+	// Users enables multi-tenant mode, where any user can subscribe to
+	// feeds by messaging the bot, and receive items privately.
+	Users *UsersConfig `yaml:"users"`
+
+	// Access sets roles of users for bot commands. Without it users can
+	// only change their own feeds in private chats.
+	Access *AccessConfig `yaml:"access"`
+
+	// Admin enables the web admin UI for managing feeds and their state.
+	Admin *AdminConfig `yaml:"admin"`
+
+	// ControlSocket is a path to a Unix socket for pausing, resuming and
+	// fetching feeds of the running bot, see "feed" command. Empty value
+	// disables the socket.
+	ControlSocket string `yaml:"control_socket"`
+
+	// StartupCheck enables probing all feeds once on startup. Possible
+	// values: "warn" - only log failed feeds, "fail" - stop the bot
+	// if any feed fails. Empty value disables the check.
+	StartupCheck string `yaml:"startup_check"`
 
 	// Debug sets the log level and prints RSS items instead of sending
 	// them to Telegram.
 	Debug bool `yaml:"debug"`
 }
 
+// Feed is a single feed subscription. In the config file it can be set
+// either as a plain URL string, or as an object with optional fields.
+type Feed struct {
+	URL      string `json:"url" yaml:"url"`
+	Title    string `json:"title,omitempty" yaml:"title,omitempty"`
+	Category string `json:"category,omitempty" yaml:"category,omitempty"`
+
+	// CatchUp is a policy of posting existing items when the feed is
+	// fetched for the first time: "skip", "last <N>" or "since <duration>".
+	CatchUp string `json:"catch_up,omitempty" yaml:"catch_up,omitempty"`
+
+	// MaxItems limits the number of items posted after a single fetch.
+	// The rest are replaced with a summary message.
+	MaxItems int `json:"max_items,omitempty" yaml:"max_items,omitempty"`
+
+	// Urgent feeds are not affected by quiet hours.
+	Urgent bool `json:"urgent,omitempty" yaml:"urgent,omitempty"`
+
+	// Translate enables translation of item titles and descriptions. It's
+	// the same as adding translate processor before other processors.
+	Translate bool `json:"translate,omitempty" yaml:"translate,omitempty"`
+
+	// Processors are processing stages for items of the feed.
+	Processors []ProcessorConfig `json:"processors,omitempty" yaml:"processors,omitempty"`
+}
+
+// ProcessorConfig is an item processing stage. In the config file it can
+// be set either as a plain type string, or as an object with settings of
+// the type.
+type ProcessorConfig struct {
+	Type string `json:"type" yaml:"type"`
+
+	// Hosts are mirror hosts for link hosts, HTTPS forces https links,
+	// Unwrap replaces redirect links with their targets, and Replace are
+	// regular expression substitutions in item fields. Used by rewrite.
+	Hosts   map[string]string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	HTTPS   bool              `json:"https,omitempty" yaml:"https,omitempty"`
+	Unwrap  bool              `json:"unwrap,omitempty" yaml:"unwrap,omitempty"`
+	Replace []ReplaceRule     `json:"replace,omitempty" yaml:"replace,omitempty"`
+
+	// Command is an executable with arguments, that is run for each item,
+	// and Timeout limits a single run. Used by exec.
+	Command []string      `json:"command,omitempty" yaml:"command,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+
+	// Filter is an expression, that keeps items when it's true, and Set are
+	// expressions of new values of item fields. Used by expr.
+	Filter string            `json:"filter,omitempty" yaml:"filter,omitempty"`
+	Set    map[string]string `json:"set,omitempty" yaml:"set,omitempty"`
+}
+
+// UnmarshalYAML allows a processor to be defined as a plain type string.
+func (p *ProcessorConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	var typ string
+	if err := unmarshal(&typ); err == nil {
+		*p = ProcessorConfig{Type: typ}
+		return nil
+	}
+
+	type plain ProcessorConfig
+	return unmarshal((*plain)(p))
+}
+
+// Processor types.
+const (
+	ProcessorTranslate = "translate"
+	ProcessorFullText  = "fulltext"
+	ProcessorRewrite   = "rewrite"
+	ProcessorExec      = "exec"
+	ProcessorExpr      = "expr"
+)
+
+// UnmarshalYAML allows a feed to be defined as a plain URL string.
+func (f *Feed) UnmarshalYAML(unmarshal func(any) error) error {
+	var url string
+	if err := unmarshal(&url); err == nil {
+		*f = Feed{URL: url}
+		return nil
+	}
+
+	type plain Feed
+	return unmarshal((*plain)(f))
+}
+
+// MarshalYAML writes a feed without optional fields as a plain URL string.
+func (f Feed) MarshalYAML() (any, error) {
+	if reflect.DeepEqual(f, Feed{URL: f.URL}) {
+		return f.URL, nil
+	}
+	type plain Feed
+	return plain(f), nil
+}
+
+// TranslationConfig is a connection to LibreTranslate compatible API.
+type TranslationConfig struct {
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key,omitempty" secret:"true"`
+	// Target is a code of the language to translate to, e.g. "en".
+	Target string `yaml:"target"`
+}
+
+// UsersConfig is a configuration of multi-tenant mode.
+type UsersConfig struct {
+	// MaxFeeds limits the number of feeds of a single user.
+	MaxFeeds int `yaml:"max_feeds"`
+
+	// Message sets options of messages sent to users. Editing and deleting
+	// messages is not supported.
+	Message MessageOptions `yaml:"message,omitempty"`
+}
+
+// AccessConfig sets roles of users by their IDs.
+type AccessConfig struct {
+	// Admins can change feeds of any chat.
+	Admins []int64 `yaml:"admins"`
+	// Moderators can change feeds of the chats, that they moderate. Keys
+	// are chat IDs.
+	Moderators map[int64][]int64 `yaml:"moderators"`
+	// ReadOnly users can only list feeds, even in their private chats.
+	ReadOnly []int64 `yaml:"read_only"`
+}
+
+// AdminConfig is a configuration of the web admin UI.
+type AdminConfig struct {
+	// Listen is an address of the HTTP server, e.g. "127.0.0.1:8080".
+	Listen string `yaml:"listen"`
+	// Editors are users of the UI. All of them can change feeds and
+	// their state.
+	Editors []Editor `yaml:"editors"`
+}
+
+// Editor is a user of the web admin UI, authenticated with basic auth.
+type Editor struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password" secret:"true"`
+}
+
+// Destination is a chat that receives items from all or selected feeds.
+type Destination struct {
+	// Chat is a username of a public chat, or a numeric chat ID, e.g.
+	// -1001234567890 for a private channel or a group.
+	Chat string `json:"chat" yaml:"chat"`
+
+	// ChatType is one of "channel" (default), "group" or "private". Private
+	// chats (direct messages) require a numeric user ID.
+	ChatType string `json:"chat_type,omitempty" yaml:"chat_type,omitempty"`
+
+	// ThreadID is a forum topic of a group.
+	ThreadID int `json:"thread_id,omitempty" yaml:"thread_id,omitempty"`
+
+	// Feeds is a list of feed URLs. Empty list means all feeds.
+	Feeds []string `json:"feeds,omitempty" yaml:"feeds,omitempty"`
+
+	// Digest makes the destination receive items as periodic summaries
+	// instead of separate messages.
+	Digest *DigestConfig `json:"digest,omitempty" yaml:"digest,omitempty"`
+
+	// QuietHours is a daily period, when items are held back, and sent
+	// after the period is over.
+	QuietHours *QuietHoursConfig `json:"quiet_hours,omitempty" yaml:"quiet_hours,omitempty"`
+
+	// Message sets Telegram message options.
+	Message MessageOptions `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// MessageOptions are options of Telegram messages.
+type MessageOptions struct {
+	// DisablePreview disables link previews in text messages.
+	DisablePreview bool `json:"disable_preview,omitempty" yaml:"disable_preview,omitempty"`
+	// Silent sends messages without notification sound.
+	Silent bool `json:"silent,omitempty" yaml:"silent,omitempty"`
+	// ProtectContent forbids forwarding and saving of messages.
+	ProtectContent bool `json:"protect_content,omitempty" yaml:"protect_content,omitempty"`
+	// Buttons are added to messages as an inline keyboard.
+	Buttons []Button `json:"buttons,omitempty" yaml:"buttons,omitempty"`
+
+	// Template is an HTML template of the message text, that is executed
+	// with the item. Only messages with a template are sent with HTML
+	// formatting, otherwise the message is the item's link in plain text.
+	// When translation is configured, the default template shows the title
+	// and the link.
+	Template string `json:"template,omitempty" yaml:"template,omitempty"`
+
+	// Edit enables editing sent messages, when their items change.
+	Edit bool `json:"edit,omitempty" yaml:"edit,omitempty"`
+	// Delete enables deleting sent messages, when their items are removed
+	// from the feed.
+	Delete bool `json:"delete,omitempty" yaml:"delete,omitempty"`
+	// Retention is a time after sending, during which messages are edited
+	// or deleted.
+	Retention time.Duration `json:"retention,omitempty" yaml:"retention,omitempty"`
+}
+
+// Button is an inline keyboard button. Its URL is a template, that is
+// executed with the item, e.g. "{{.Comments}}". Buttons with empty URLs
+// are not shown.
+type Button struct {
+	Text string `json:"text" yaml:"text"`
+	URL  string `json:"url" yaml:"url"`
+}
+
+// QuietHoursConfig is a configuration of a daily quiet period.
+type QuietHoursConfig struct {
+	// From and To are times in 24-hour format, e.g. "22:00".
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+
+	// TimeZone is an IANA time zone name, e.g. "Europe/London". UTC is
+	// used by default.
+	TimeZone string `json:"time_zone" yaml:"time_zone"`
+}
+
+// DigestConfig is a configuration of periodic digest.
+type DigestConfig struct {
+	// Schedule is a cron expression, e.g. "0 9 * * *" or "@weekly".
+	// Time zone can be set with CRON_TZ prefix.
+	Schedule string `json:"schedule" yaml:"schedule"`
+
+	// GroupBy is either "feed" (default) or "category".
+	GroupBy string `json:"group_by" yaml:"group_by"`
+}
+
 const (
 	defaultUpdateInterval = 1 * time.Hour
 	defaultDataFile       = "./data.yaml"
+
+	defaultTelegramAPIURL  = "https://api.telegram.org"
+	defaultTelegramTimeout = 30 * time.Second
+
+	defaultMessageRetention = 24 * time.Hour
+
+	// defaultTranslatedTemplate shows translated titles, that are not seen
+	// in messages with just a link.
+	defaultTranslatedTemplate = "<b>{{.Title}}</b>\n{{.Link}}"
+
+	defaultUserMaxFeeds = 10
 )
 
 // ReadConfig returns configuration populated from the config file.
@@ -38,12 +335,24 @@ func ReadConfig(file string) (Config, error) {
 	if err := yaml.Unmarshal(data, &conf); err != nil {
 		return Config{}, fmt.Errorf("unmarshal file: %w", err)
 	}
+	// The token file is read before environment variables are applied,
+	// so they override it like any other field
+	if conf.TelegramTokenFile != "" {
+		conf.TelegramToken, err = readSecretFile(conf.TelegramTokenFile)
+		if err != nil {
+			return Config{}, fmt.Errorf("read telegram token: %w", err)
+		}
+	}
+	if err := applyEnv(&conf); err != nil {
+		return Config{}, fmt.Errorf("apply env: %w", err)
+	}
 
 	if !conf.Debug {
 		if conf.TelegramToken == "" {
 			return Config{}, errors.New("empty telegram token")
 		}
-		if conf.TelegramChat == "" {
+		// Destinations can be added with the API
+		if conf.TelegramChat == "" && len(conf.Destinations) == 0 && conf.Users == nil && conf.Admin == nil {
 			return Config{}, errors.New("empty telegram chat")
 		}
 	}
@@ -53,9 +362,417 @@ func ReadConfig(file string) (Config, error) {
 	if conf.DataFile == "" {
 		conf.DataFile = defaultDataFile
 	}
-	if len(conf.Feeds) == 0 {
+	if conf.TelegramAPIURL == "" {
+		conf.TelegramAPIURL = defaultTelegramAPIURL
+	}
+	if conf.TelegramTimeout == 0 {
+		conf.TelegramTimeout = defaultTelegramTimeout
+	}
+	if err := validateTelegram(conf); err != nil {
+		return Config{}, err
+	}
+	// Users can bring their own feeds
+	if len(conf.Feeds) == 0 && conf.Users == nil {
 		return Config{}, errors.New("empty feeds list")
 	}
+	if _, err := ParseCatchUp(conf.CatchUp); err != nil {
+		return Config{}, fmt.Errorf("invalid catch-up policy: %w", err)
+	}
+	if conf.MaxItems < 0 {
+		return Config{}, errors.New("negative max items")
+	}
+	if conf.DeliveryWindow < 0 {
+		return Config{}, errors.New("negative delivery window")
+	}
+	if conf.DedupWindow < 0 {
+		return Config{}, errors.New("negative dedup window")
+	}
+	if err := validateFeeds(conf.Feeds); err != nil {
+		return Config{}, err
+	}
+	if err := validateTranslation(conf.Translation); err != nil {
+		return Config{}, err
+	}
+	if err := validateProcessors(conf); err != nil {
+		return Config{}, err
+	}
+	if err := validateUsers(conf.Users); err != nil {
+		return Config{}, err
+	}
+	if conf.Access != nil && conf.Users == nil {
+		return Config{}, errors.New("access control requires users mode")
+	}
+	if err := validateAdmin(conf.Admin); err != nil {
+		return Config{}, err
+	}
+	if len(conf.Destinations) == 0 && conf.TelegramChat != "" {
+		conf.Destinations = []Destination{{Chat: conf.TelegramChat}}
+	}
+	if err := validateDestinations(conf.Destinations, conf.Feeds); err != nil {
+		return Config{}, err
+	}
+	for i, d := range conf.Destinations {
+		if (d.Message.Edit || d.Message.Delete) && d.Message.Retention == 0 {
+			conf.Destinations[i].Message.Retention = defaultMessageRetention
+		}
+		conf.Destinations[i].Message = conf.messageDefaults(conf.Destinations[i].Message, d.Feeds)
+	}
+	if conf.Users != nil {
+		// Users can subscribe to any feed of the config
+		conf.Users.Message = conf.messageDefaults(conf.Users.Message, nil)
+	}
+	for i := range conf.Feeds {
+		if conf.Feeds[i].CatchUp == "" {
+			conf.Feeds[i].CatchUp = conf.CatchUp
+		}
+		if conf.Feeds[i].MaxItems == 0 {
+			conf.Feeds[i].MaxItems = conf.MaxItems
+		}
+	}
+	switch conf.StartupCheck {
+	case "", StartupCheckWarn, StartupCheckFail:
+	default:
+		return Config{}, fmt.Errorf("invalid startup check: %s", conf.StartupCheck)
+	}
 
 	return conf, nil
 }
+
+// Destination chat types.
+const (
+	ChatTypeChannel = "channel"
+	ChatTypeGroup   = "group"
+	ChatTypePrivate = "private"
+)
+
+// Startup check modes.
+const (
+	StartupCheckWarn = "warn"
+	StartupCheckFail = "fail"
+)
+
+// validateTelegram checks Telegram API connection settings.
+func validateTelegram(conf Config) error {
+	u, err := url.Parse(conf.TelegramAPIURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid telegram api url: %s", conf.TelegramAPIURL)
+	}
+	if conf.TelegramProxy != "" {
+		u, err := url.Parse(conf.TelegramProxy)
+		if err != nil || u.Host == "" {
+			return errors.New("invalid telegram proxy")
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("invalid telegram proxy scheme: %s", u.Scheme)
+		}
+	}
+	if conf.TelegramTimeout < 0 {
+		return errors.New("negative telegram timeout")
+	}
+	return nil
+}
+
+// messageDefaults sets the default template of messages, when items of
+// the feeds are translated. Empty list means all feeds of the config.
+func (c Config) messageDefaults(opts MessageOptions, feeds []string) MessageOptions {
+	if opts.Template == "" && c.translated(feeds) {
+		opts.Template = defaultTranslatedTemplate
+	}
+	return opts
+}
+
+// translated checks if items of any of the feeds are translated, either
+// by global processors, or by processors of the feed. Empty list means
+// all feeds of the config.
+func (c Config) translated(feeds []string) bool {
+	translate := func(p ProcessorConfig) bool { return p.Type == ProcessorTranslate }
+	if slices.ContainsFunc(c.Processors, translate) {
+		return true
+	}
+	for _, f := range c.Feeds {
+		if len(feeds) > 0 && !slices.Contains(feeds, f.URL) {
+			continue
+		}
+		if f.Translate || slices.ContainsFunc(f.Processors, translate) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTranslation checks settings of translation service.
+func validateTranslation(conf *TranslationConfig) error {
+	if conf == nil {
+		return nil
+	}
+	u, err := url.Parse(conf.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid translation url: %s", conf.URL)
+	}
+	if conf.Target == "" {
+		return errors.New("empty translation target")
+	}
+	return nil
+}
+
+// validateProcessors checks global processors and processors of all feeds.
+func validateProcessors(conf Config) error {
+	if err := validateStages(conf.Processors, conf); err != nil {
+		return fmt.Errorf("invalid processor: %w", err)
+	}
+	for _, f := range conf.Feeds {
+		procs := f.Processors
+		if f.Translate {
+			procs = append([]ProcessorConfig{{Type: ProcessorTranslate}}, procs...)
+		}
+		if err := validateStages(procs, conf); err != nil {
+			return fmt.Errorf("invalid processor of %s: %w", f.URL, err)
+		}
+	}
+	return nil
+}
+
+func validateStages(procs []ProcessorConfig, conf Config) error {
+	for _, p := range procs {
+		switch p.Type {
+		case ProcessorTranslate:
+			if conf.Translation == nil {
+				return errors.New("translation is not configured")
+			}
+		case ProcessorFullText:
+		case ProcessorRewrite:
+			if _, err := parseReplaceRules(p.Replace); err != nil {
+				return fmt.Errorf("invalid rewrite rules: %w", err)
+			}
+		case ProcessorExec:
+			if len(p.Command) == 0 || p.Command[0] == "" {
+				return errors.New("empty command")
+			}
+			if p.Timeout < 0 {
+				return errors.New("negative timeout")
+			}
+		case ProcessorExpr:
+			if _, err := NewExpression(p.Filter, p.Set); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown type: %q", p.Type)
+		}
+	}
+	return nil
+}
+
+// validateUsers checks settings of multi-tenant mode, and sets defaults.
+func validateUsers(conf *UsersConfig) error {
+	if conf == nil {
+		return nil
+	}
+	if conf.MaxFeeds < 0 {
+		return errors.New("negative max feeds of users")
+	}
+	if conf.MaxFeeds == 0 {
+		conf.MaxFeeds = defaultUserMaxFeeds
+	}
+	if _, err := parseMessageTemplate(conf.Message.Template); err != nil {
+		return fmt.Errorf("invalid template of users: %w", err)
+	}
+	if _, err := parseButtons(conf.Message.Buttons); err != nil {
+		return fmt.Errorf("invalid buttons of users: %w", err)
+	}
+	if conf.Message.Edit || conf.Message.Delete {
+		return errors.New("editing and deleting messages of users is not supported")
+	}
+	return nil
+}
+
+// validateAdmin checks settings of the web admin UI.
+func validateAdmin(conf *AdminConfig) error {
+	if conf == nil {
+		return nil
+	}
+	if conf.Listen == "" {
+		return errors.New("empty listen address of admin")
+	}
+	if len(conf.Editors) == 0 {
+		return errors.New("empty editors list of admin")
+	}
+	known := map[string]bool{}
+	for _, e := range conf.Editors {
+		if e.Username == "" || e.Password == "" {
+			return errors.New("empty credentials of admin editor")
+		}
+		if known[e.Username] {
+			return fmt.Errorf("duplicate admin editor: %s", e.Username)
+		}
+		known[e.Username] = true
+	}
+	return nil
+}
+
+// validateFeeds checks that all feeds have valid absolute HTTP URLs, and
+// that there are no duplicates.
+func validateFeeds(feeds []Feed) error {
+	known := make(map[string]bool, len(feeds))
+	for _, f := range feeds {
+		if err := validateFeedURL(f.URL); err != nil {
+			return fmt.Errorf("invalid feed url %q: %w", f.URL, err)
+		}
+		if known[f.URL] {
+			return fmt.Errorf("duplicate feed: %s", f.URL)
+		}
+		if _, err := ParseCatchUp(f.CatchUp); err != nil {
+			return fmt.Errorf("invalid catch-up policy of %s: %w", f.URL, err)
+		}
+		if f.MaxItems < 0 {
+			return fmt.Errorf("negative max items of %s", f.URL)
+		}
+		known[f.URL] = true
+	}
+	return nil
+}
+
+// validateDestinations checks that all destinations have chats, refer to
+// known feeds, and have valid digest settings.
+func validateDestinations(dests []Destination, feeds []Feed) error {
+	known := make(map[string]bool, len(feeds))
+	for _, f := range feeds {
+		known[f.URL] = true
+	}
+	for _, d := range dests {
+		if err := validateDestination(d, func(feed string) bool { return known[feed] }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDestination checks a single destination. Known reports whether
+// the feed exists.
+func validateDestination(d Destination, known func(feed string) bool) error {
+	if d.Chat == "" {
+		return errors.New("empty destination chat")
+	}
+	switch d.ChatType {
+	case "", ChatTypeChannel, ChatTypeGroup:
+	case ChatTypePrivate:
+		if _, err := strconv.ParseInt(d.Chat, 10, 64); err != nil {
+			return fmt.Errorf("private chat requires numeric id: %s", d.Chat)
+		}
+	default:
+		return fmt.Errorf("invalid chat type of %s: %s", d.Chat, d.ChatType)
+	}
+	if d.ThreadID != 0 && d.ChatType != ChatTypeGroup {
+		return fmt.Errorf("thread id requires group chat: %s", d.Chat)
+	}
+	for _, f := range d.Feeds {
+		if !known(f) {
+			return fmt.Errorf("unknown feed of destination %s: %s", d.Chat, f)
+		}
+	}
+	if _, err := parseMessageTemplate(d.Message.Template); err != nil {
+		return fmt.Errorf("invalid template of %s: %w", d.Chat, err)
+	}
+	if d.Message.Retention < 0 {
+		return fmt.Errorf("negative message retention of %s", d.Chat)
+	}
+	if _, err := parseButtons(d.Message.Buttons); err != nil {
+		return fmt.Errorf("invalid buttons of %s: %w", d.Chat, err)
+	}
+	if d.QuietHours != nil {
+		if _, err := ParseQuietHours(*d.QuietHours); err != nil {
+			return fmt.Errorf("invalid quiet hours of %s: %w", d.Chat, err)
+		}
+	}
+	if d.Digest == nil {
+		return nil
+	}
+	if _, err := ParseSchedule(d.Digest.Schedule); err != nil {
+		return fmt.Errorf("invalid digest schedule of %s: %w", d.Chat, err)
+	}
+	switch d.Digest.GroupBy {
+	case "", GroupByFeed, GroupByCategory:
+	default:
+		return fmt.Errorf("invalid digest grouping of %s: %s", d.Chat, d.Digest.GroupBy)
+	}
+	return nil
+}
+
+func validateFeedURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return errors.New("malformed url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return errors.New("empty host")
+	}
+	return nil
+}
+
+// ImportFeeds merges feeds into the config file, skipping the ones that
+// are already there. The rest of the file is kept as is, except for the
+// comments. Returns the number of added feeds.
+func ImportFeeds(file string, feeds []Feed) (int, error) {
+	data, err := os.ReadFile(file) //nolint:gosec
+	if err != nil {
+		return 0, fmt.Errorf("read file: %w", err)
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return 0, fmt.Errorf("unmarshal file: %w", err)
+	}
+	var conf struct {
+		Feeds []Feed `yaml:"feeds"`
+	}
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return 0, fmt.Errorf("unmarshal feeds: %w", err)
+	}
+
+	merged, added := mergeFeeds(conf.Feeds, feeds)
+	if added == 0 {
+		return 0, nil
+	}
+
+	found := false
+	for i := range doc {
+		if doc[i].Key == "feeds" {
+			doc[i].Value = merged
+			found = true
+		}
+	}
+	if !found {
+		doc = append(doc, yaml.MapItem{Key: "feeds", Value: merged})
+	}
+
+	data, err = yaml.Marshal(doc)
+	if err != nil {
+		return 0, fmt.Errorf("marshal config: %w", err)
+	}
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		return 0, fmt.Errorf("write file: %w", err)
+	}
+	return added, nil
+}
+
+// mergeFeeds appends new feeds to the list, skipping existing URLs.
+func mergeFeeds(feeds, add []Feed) ([]Feed, int) {
+	known := make(map[string]bool, len(feeds))
+	for _, f := range feeds {
+		known[f.URL] = true
+	}
+	var added int
+	for _, f := range add {
+		if known[f.URL] {
+			continue
+		}
+		known[f.URL] = true
+		feeds = append(feeds, f)
+		added++
+	}
+	return feeds, added
+}
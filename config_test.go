@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -23,6 +24,7 @@ func TestReadConfig(t *testing.T) {
 			"telegram_chat: chat_name\n" +
 			"update_interval: 3h\n" +
 			"data_file: ./data.yaml\n" +
+			"startup_check: fail\n" +
 			"feeds: [\"https://example.com/rss.xml\"]\n")
 		assert.NoError(t, os.WriteFile(f, data, 0o600))
 
@@ -34,8 +36,13 @@ func TestReadConfig(t *testing.T) {
 			TelegramChat:   "chat_name",
 			UpdateInterval: 3 * time.Hour,
 			DataFile:       "./data.yaml",
-			Feeds:          []string{"https://example.com/rss.xml"},
+			Feeds:          []Feed{{URL: "https://example.com/rss.xml"}},
+			Destinations:   []Destination{{Chat: "chat_name"}},
+			StartupCheck:   StartupCheckFail,
 			Debug:          false,
+
+			TelegramAPIURL:  defaultTelegramAPIURL,
+			TelegramTimeout: defaultTelegramTimeout,
 		}
 		assert.Equal(t, expected, conf)
 	})
@@ -52,12 +59,66 @@ func TestReadConfig(t *testing.T) {
 		expected := Config{
 			UpdateInterval: defaultUpdateInterval,
 			DataFile:       "./data.yaml",
-			Feeds:          []string{"https://example.com/rss.xml"},
+			Feeds:          []Feed{{URL: "https://example.com/rss.xml"}},
 			Debug:          true,
+
+			TelegramAPIURL:  defaultTelegramAPIURL,
+			TelegramTimeout: defaultTelegramTimeout,
 		}
 		assert.Equal(t, expected, conf)
 	})
 
+	t.Run("telegram api", func(t *testing.T) {
+		data := []byte("debug: true\n" +
+			"telegram_api_url: http://localhost:8081\n" +
+			"telegram_proxy: socks5://localhost:1080\n" +
+			"telegram_timeout: 1m\n" +
+			"feeds: [\"https://example.com/rss.xml\"]\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		conf, err := ReadConfig(f)
+		assert.NoError(t, err)
+		assert.Equal(t, "http://localhost:8081", conf.TelegramAPIURL)
+		assert.Equal(t, "socks5://localhost:1080", conf.TelegramProxy)
+		assert.Equal(t, time.Minute, conf.TelegramTimeout)
+	})
+
+	t.Run("invalid telegram api", func(t *testing.T) {
+		for in, msg := range map[string]string{
+			"telegram_api_url: localhost:8081":  "invalid telegram api url: localhost:8081",
+			"telegram_api_url: ftp://localhost": "invalid telegram api url: ftp://localhost",
+			"telegram_proxy: ftp://localhost":   "invalid telegram proxy scheme: ftp",
+			"telegram_proxy: http://":           "invalid telegram proxy",
+			"telegram_timeout: -1s":             "negative telegram timeout",
+		} {
+			data := []byte("debug: true\n" + in + "\n" +
+				"feeds: [\"https://example.com/rss.xml\"]\n")
+			assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+			_, err := ReadConfig(f)
+			assert.EqualError(t, err, msg)
+		}
+	})
+
+	t.Run("feed objects", func(t *testing.T) {
+		data := []byte("feeds:\n" +
+			"  - https://example.com/rss.xml\n" +
+			"  - url: https://example.com/atom.xml\n" +
+			"    title: Example\n" +
+			"    category: News\n" +
+			"debug: true\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		conf, err := ReadConfig(f)
+		assert.NoError(t, err)
+
+		expected := []Feed{
+			{URL: "https://example.com/rss.xml"},
+			{URL: "https://example.com/atom.xml", Title: "Example", Category: "News"},
+		}
+		assert.Equal(t, expected, conf.Feeds)
+	})
+
 	t.Run("missing token", func(t *testing.T) {
 		data := []byte("telegram_chat: chat_name\n" +
 			"feeds: [\"https://example.com/rss.xml\"]\n" +
@@ -78,6 +139,346 @@ func TestReadConfig(t *testing.T) {
 		assert.ErrorContains(t, err, "empty feeds list")
 	})
 
+	t.Run("invalid feed url", func(t *testing.T) {
+		for in, msg := range map[string]string{
+			"example.com/rss.xml":      "scheme must be http or https",
+			"ftp://example.com/rss":    "scheme must be http or https",
+			"https:///rss.xml":         "empty host",
+			"https://exa mple.com/rss": "malformed url",
+		} {
+			data := []byte("debug: true\nfeeds: [\"" + in + "\"]\n")
+			assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+			_, err := ReadConfig(f)
+			assert.EqualError(t, err, fmt.Sprintf("invalid feed url %q: %s", in, msg))
+		}
+	})
+
+	t.Run("duplicate feed", func(t *testing.T) {
+		data := []byte("debug: true\n" +
+			"feeds:\n" +
+			"  - https://example.com/rss.xml\n" +
+			"  - url: https://example.com/rss.xml\n" +
+			"    title: Example\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		_, err := ReadConfig(f)
+		assert.EqualError(t, err, "duplicate feed: https://example.com/rss.xml")
+	})
+
+	t.Run("catch-up defaults", func(t *testing.T) {
+		data := []byte("debug: true\n" +
+			"catch_up: last 3\n" +
+			"max_items: 10\n" +
+			"feeds:\n" +
+			"  - https://example.com/rss.xml\n" +
+			"  - url: https://example.com/atom.xml\n" +
+			"    catch_up: since 24h\n" +
+			"    max_items: 5\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		conf, err := ReadConfig(f)
+		assert.NoError(t, err)
+
+		expected := []Feed{
+			{URL: "https://example.com/rss.xml", CatchUp: "last 3", MaxItems: 10},
+			{URL: "https://example.com/atom.xml", CatchUp: "since 24h", MaxItems: 5},
+		}
+		assert.Equal(t, expected, conf.Feeds)
+	})
+
+	t.Run("invalid catch-up", func(t *testing.T) {
+		data := []byte("debug: true\n" +
+			"feeds:\n" +
+			"  - url: https://example.com/rss.xml\n" +
+			"    catch_up: everything\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		_, err := ReadConfig(f)
+		assert.EqualError(t, err, "invalid catch-up policy of https://example.com/rss.xml: "+
+			"unknown policy: everything")
+	})
+
+	t.Run("destinations", func(t *testing.T) {
+		data := []byte("telegram_token: \"123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAA-AAAAA\"\n" +
+			"destinations:\n" +
+			"  - chat: all_feeds\n" +
+			"  - chat: \"-1001234567890\"\n" +
+			"    chat_type: group\n" +
+			"    thread_id: 42\n" +
+			"  - chat: quiet\n" +
+			"    quiet_hours: {from: \"22:00\", to: \"07:00\", time_zone: Europe/London}\n" +
+			"  - chat: digest\n" +
+			"    feeds: [\"https://example.com/rss.xml\"]\n" +
+			"    digest:\n" +
+			"      schedule: \"0 9 * * *\"\n" +
+			"      group_by: category\n" +
+			"  - chat: edits\n" +
+			"    message: {template: \"{{.Title}}\", edit: true, delete: true}\n" +
+			"feeds: [\"https://example.com/rss.xml\"]\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		conf, err := ReadConfig(f)
+		assert.NoError(t, err)
+
+		expected := []Destination{
+			{Chat: "all_feeds"},
+			{Chat: "-1001234567890", ChatType: ChatTypeGroup, ThreadID: 42},
+			{
+				Chat:       "quiet",
+				QuietHours: &QuietHoursConfig{From: "22:00", To: "07:00", TimeZone: "Europe/London"},
+			},
+			{
+				Chat:   "digest",
+				Feeds:  []string{"https://example.com/rss.xml"},
+				Digest: &DigestConfig{Schedule: "0 9 * * *", GroupBy: GroupByCategory},
+			},
+			{
+				Chat: "edits",
+				Message: MessageOptions{
+					Template:  "{{.Title}}",
+					Edit:      true,
+					Delete:    true,
+					Retention: defaultMessageRetention,
+				},
+			},
+		}
+		assert.Equal(t, expected, conf.Destinations)
+	})
+
+	t.Run("invalid destinations", func(t *testing.T) {
+		for dest, msg := range map[string]string{
+			"{feeds: [\"https://example.com/rss.xml\"]}": "empty destination chat",
+			"{chat: c, feeds: [\"https://example.com/atom.xml\"]}": "unknown feed of destination c: " +
+				"https://example.com/atom.xml",
+			"{chat: c, digest: {schedule: \"0 25 * * *\"}}": "invalid digest schedule of c: " +
+				"end of range (25) above maximum (23): 25",
+			"{chat: c, digest: {schedule: \"@daily\", group_by: author}}": "invalid digest grouping of c: author",
+			"{chat: c, quiet_hours: {from: \"22:00\", to: \"7am\"}}":      "invalid quiet hours of c: invalid end time: 7am",
+			"{chat: c, chat_type: private}":                               "private chat requires numeric id: c",
+			"{chat: c, chat_type: forum}":                                 "invalid chat type of c: forum",
+			"{chat: c, thread_id: 5}":                                     "thread id requires group chat: c",
+			"{chat: c, message: {template: \"{{.Link\"}}": "invalid template of c: " +
+				"parse: template: message:1: unclosed action",
+			"{chat: c, message: {edit: true, retention: -1h}}": "negative message retention of c",
+		} {
+			data := []byte("debug: true\n" +
+				"destinations: [" + dest + "]\n" +
+				"feeds: [\"https://example.com/rss.xml\"]\n")
+			assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+			_, err := ReadConfig(f)
+			assert.EqualError(t, err, msg)
+		}
+	})
+
+	t.Run("translation", func(t *testing.T) {
+		data := []byte("debug: true\n" +
+			"translation: {url: \"http://localhost:5000\", target: en}\n" +
+			"destinations:\n" +
+			"  - chat: c1\n" +
+			"  - {chat: c2, message: {template: \"{{.Link}}\"}}\n" +
+			"  - {chat: c3, feeds: [https://example.com/other.xml]}\n" +
+			"feeds:\n" +
+			"  - url: https://example.com/rss.xml\n" +
+			"    translate: true\n" +
+			"  - url: https://example.com/other.xml\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		conf, err := ReadConfig(f)
+		assert.NoError(t, err)
+		assert.Equal(t, &TranslationConfig{URL: "http://localhost:5000", Target: "en"}, conf.Translation)
+		assert.True(t, conf.Feeds[0].Translate)
+		// Messages show translated titles by default
+		assert.Equal(t, defaultTranslatedTemplate, conf.Destinations[0].Message.Template)
+		assert.Equal(t, "{{.Link}}", conf.Destinations[1].Message.Template)
+		// Destinations without translated feeds keep the default format
+		assert.Empty(t, conf.Destinations[2].Message.Template)
+	})
+
+	t.Run("processors", func(t *testing.T) {
+		data := []byte("debug: true\n" +
+			"translation: {url: \"http://localhost:5000\", target: en}\n" +
+			"processors: [translate]\n" +
+			"feeds:\n" +
+			"  - url: https://example.com/rss.xml\n" +
+			"    processors: [{type: translate}, fulltext]\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		conf, err := ReadConfig(f)
+		assert.NoError(t, err)
+		assert.Equal(t, []ProcessorConfig{{Type: ProcessorTranslate}}, conf.Processors)
+		assert.Equal(t, []ProcessorConfig{
+			{Type: ProcessorTranslate},
+			{Type: ProcessorFullText},
+		}, conf.Feeds[0].Processors)
+	})
+
+	t.Run("rewrite processor", func(t *testing.T) {
+		data := []byte("debug: true\n" +
+			"processors:\n" +
+			"  - type: rewrite\n" +
+			"    hosts: {twitter.com: nitter.net}\n" +
+			"    https: true\n" +
+			"    unwrap: true\n" +
+			"    replace: [{field: title, pattern: \"^\\\\[AD\\\\] \", with: \"\"}]\n" +
+			"feeds: [\"https://example.com/rss.xml\"]\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		conf, err := ReadConfig(f)
+		assert.NoError(t, err)
+		assert.Equal(t, []ProcessorConfig{{
+			Type:    ProcessorRewrite,
+			Hosts:   map[string]string{"twitter.com": "nitter.net"},
+			HTTPS:   true,
+			Unwrap:  true,
+			Replace: []ReplaceRule{{Field: "title", Pattern: `^\[AD\] `}},
+		}}, conf.Processors)
+	})
+
+	t.Run("users", func(t *testing.T) {
+		// Feeds and chats are optional
+		data := []byte("telegram_token: qwerty\n" +
+			"users: {}\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		conf, err := ReadConfig(f)
+		assert.NoError(t, err)
+		assert.Equal(t, &UsersConfig{MaxFeeds: 10}, conf.Users)
+		assert.Empty(t, conf.Feeds)
+		assert.Empty(t, conf.Destinations)
+	})
+
+	t.Run("access", func(t *testing.T) {
+		data := []byte("debug: true\n" +
+			"users: {}\n" +
+			"access:\n" +
+			"  admins: [1]\n" +
+			"  moderators: {-1001234567890: [2, 3]}\n" +
+			"  read_only: [4]\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		conf, err := ReadConfig(f)
+		assert.NoError(t, err)
+		assert.Equal(t, &AccessConfig{
+			Admins:     []int64{1},
+			Moderators: map[int64][]int64{-1001234567890: {2, 3}},
+			ReadOnly:   []int64{4},
+		}, conf.Access)
+
+		data = []byte("debug: true\n" +
+			"access: {admins: [1]}\n" +
+			"feeds: [\"https://example.com/rss.xml\"]\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		_, err = ReadConfig(f)
+		assert.EqualError(t, err, "access control requires users mode")
+	})
+
+	t.Run("invalid users", func(t *testing.T) {
+		for in, msg := range map[string]string{
+			"users: {max_feeds: -1}":                  "negative max feeds of users",
+			"users: {message: {template: \"{{\"}}":    "invalid template of users: parse: template: message:1: unclosed action",
+			"users: {message: {buttons: [{url: x}]}}": "invalid buttons of users: empty button text",
+			"users: {message: {edit: true}}":          "editing and deleting messages of users is not supported",
+		} {
+			data := []byte("debug: true\n" + in + "\n")
+			assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+			_, err := ReadConfig(f)
+			assert.EqualError(t, err, msg)
+		}
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		// Telegram chat is not required
+		data := []byte("telegram_token: token\n" +
+			"admin: {listen: ':8080', editors: [{username: root, password: secret}]}\n" +
+			"feeds: [\"https://example.com/rss.xml\"]\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		conf, err := ReadConfig(f)
+		assert.NoError(t, err)
+		assert.Equal(t, &AdminConfig{
+			Listen:  ":8080",
+			Editors: []Editor{{Username: "root", Password: "secret"}},
+		}, conf.Admin)
+		assert.NotContains(t, conf.String(), "secret")
+	})
+
+	t.Run("invalid admin", func(t *testing.T) {
+		for in, msg := range map[string]string{
+			"admin: {editors: [{username: a, password: b}]}":                                            "empty listen address of admin",
+			"admin: {listen: ':80'}":                                                                    "empty editors list of admin",
+			"admin: {listen: ':80', editors: [{username: a}]}":                                          "empty credentials of admin editor",
+			"admin: {listen: ':80', editors: [{username: a, password: b}, {username: a, password: c}]}": "duplicate admin editor: a",
+		} {
+			data := []byte("debug: true\n" + in + "\nfeeds: [\"https://example.com/rss.xml\"]\n")
+			assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+			_, err := ReadConfig(f)
+			assert.EqualError(t, err, msg)
+		}
+	})
+
+	t.Run("invalid processors", func(t *testing.T) {
+		for in, msg := range map[string]string{
+			"processors: [translate]": "invalid processor: translation is not configured",
+			"processors: [magic]":     "invalid processor: unknown type: \"magic\"",
+			"feeds: [{url: \"https://example.com/rss.xml\", processors: [magic]}]": "invalid processor of " +
+				"https://example.com/rss.xml: unknown type: \"magic\"",
+			"processors: [{type: rewrite, replace: [{field: body, pattern: x}]}]": "invalid processor: " +
+				"invalid rewrite rules: unknown field: \"body\"",
+			"processors: [{type: rewrite, replace: [{field: title, pattern: \"(\"}]}]": "invalid processor: " +
+				"invalid rewrite rules: invalid pattern of title: error parsing regexp: " +
+				"missing closing ): `(`",
+			"processors: [exec]": "invalid processor: empty command",
+			"processors: [{type: exec, command: [./plugin], timeout: -1s}]": "invalid processor: " +
+				"negative timeout",
+			"processors: [expr]": "invalid processor: empty expression",
+			"feeds: [{url: \"https://example.com/rss.xml\", processors: [{type: expr, filter: item.Name}]}]": "" +
+				"invalid processor of https://example.com/rss.xml: invalid filter: " +
+				"type main.Item has no field Name (1:6)\n | item.Name\n | .....^",
+		} {
+			data := []byte("debug: true\n" + in + "\n")
+			if !strings.HasPrefix(in, "feeds") {
+				data = append(data, "feeds: [\"https://example.com/rss.xml\"]\n"...)
+			}
+			assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+			_, err := ReadConfig(f)
+			assert.EqualError(t, err, msg)
+		}
+	})
+
+	t.Run("invalid translation", func(t *testing.T) {
+		for in, msg := range map[string]string{
+			"translation: {url: localhost, target: en}":     "invalid translation url: localhost",
+			"translation: {url: \"http://localhost:5000\"}": "empty translation target",
+			"feeds: [{url: \"https://example.com/rss.xml\", translate: true}]": "invalid processor of " +
+				"https://example.com/rss.xml: translation is not configured",
+		} {
+			data := []byte("debug: true\n" + in + "\n")
+			if !strings.HasPrefix(in, "feeds") {
+				data = append(data, "feeds: [\"https://example.com/rss.xml\"]\n"...)
+			}
+			assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+			_, err := ReadConfig(f)
+			assert.EqualError(t, err, msg)
+		}
+	})
+
+	t.Run("invalid startup check", func(t *testing.T) {
+		data := []byte("debug: true\n" +
+			"startup_check: maybe\n" +
+			"feeds: [\"https://example.com/rss.xml\"]\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		_, err := ReadConfig(f)
+		assert.EqualError(t, err, "invalid startup check: maybe")
+	})
+
 	t.Run("invalid config", func(t *testing.T) {
 		data := []byte(`]`)
 		assert.NoError(t, os.WriteFile(f, data, 0o600))
@@ -91,3 +492,62 @@ func TestReadConfig(t *testing.T) {
 		assert.True(t, errors.Is(err, os.ErrNotExist))
 	})
 }
+
+func TestImportFeeds(t *testing.T) {
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().Nanosecond()),
+	)
+	defer os.Remove(f)
+
+	t.Run("merge feeds", func(t *testing.T) {
+		data := []byte("telegram_chat: chat_name\n" +
+			"feeds:\n" +
+			"  - https://example.com/rss.xml\n" +
+			"debug: true\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		n, err := ImportFeeds(f, []Feed{
+			{URL: "https://example.com/rss.xml", Title: "Duplicate"},
+			{URL: "https://example.com/atom.xml", Title: "Example", Category: "News"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		assertFile(t, f, "telegram_chat: chat_name\n"+
+			"feeds:\n"+
+			"- https://example.com/rss.xml\n"+
+			"- url: https://example.com/atom.xml\n"+
+			"  title: Example\n"+
+			"  category: News\n"+
+			"debug: true\n")
+	})
+
+	t.Run("no feeds key", func(t *testing.T) {
+		data := []byte("debug: true\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		n, err := ImportFeeds(f, []Feed{{URL: "https://example.com/rss.xml"}})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		assertFile(t, f, "debug: true\n"+
+			"feeds:\n"+
+			"- https://example.com/rss.xml\n")
+	})
+
+	t.Run("nothing to add", func(t *testing.T) {
+		data := []byte("# comment\nfeeds: [\"https://example.com/rss.xml\"]\n")
+		assert.NoError(t, os.WriteFile(f, data, 0o600))
+
+		n, err := ImportFeeds(f, []Feed{{URL: "https://example.com/rss.xml"}})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+		assertFile(t, f, string(data))
+	})
+
+	t.Run("non-existing file", func(t *testing.T) {
+		_, err := ImportFeeds("abc.yaml", nil)
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+	})
+}
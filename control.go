@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// controlTimeout limits time of a single command of the control socket.
+const controlTimeout = 10 * time.Second
+
+// ErrControlStopped is returned for commands, that are sent after
+// the bot is stopped.
+var ErrControlStopped = errors.New("bot is stopped")
+
+// FeedControl pauses, resumes and fetches feeds of the running bot.
+type FeedControl interface {
+	Pause(feed string) error
+	Resume(feed string) error
+	FetchNow(feed string) error
+}
+
+// FeedPauser stops and starts fetching of feeds, and keeps paused feeds
+// in the storage.
+type FeedPauser interface {
+	Pause(feed string) error
+	Resume(feed string) error
+}
+
+// FetchTrigger starts immediate fetches of feeds.
+type FetchTrigger interface {
+	FetchNow(feed string) error
+}
+
+// controlCommand is a command of the control channel with a channel
+// for its result.
+type controlCommand struct {
+	action string
+	feed   string
+	result chan error
+}
+
+// Control is a control channel of running feeds. Commands from the admin
+// UI, the API and the control socket are executed one by one in a single
+// goroutine. After the bot is stopped, e.g. by a signal, commands fail
+// instead of changing feeds during shutdown.
+//
+// Commands of the control socket are written to the audit log, commands
+// of the admin UI and the API are written there by their handlers.
+type Control struct {
+	pauser   FeedPauser
+	trigger  FetchTrigger
+	audit    AuditStorage
+	commands chan controlCommand
+	done     chan struct{}
+	log      *logrus.Logger
+}
+
+// NewControl creates a control channel. Commands are executed only
+// when it runs. Audit storage is optional.
+func NewControl(p FeedPauser, t FetchTrigger, s AuditStorage, log *logrus.Logger) *Control {
+	return &Control{
+		pauser:   p,
+		trigger:  t,
+		audit:    s,
+		commands: make(chan controlCommand),
+		done:     make(chan struct{}),
+		log:      log,
+	}
+}
+
+// Run executes commands until the context is cancelled.
+func (c *Control) Run(ctx context.Context) {
+	defer close(c.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cmd := <-c.commands:
+			cmd.result <- c.exec(cmd)
+		}
+	}
+}
+
+// Pause stops fetching of the feed.
+func (c *Control) Pause(feed string) error {
+	return c.do("pause", feed)
+}
+
+// Resume starts fetching of the paused feed.
+func (c *Control) Resume(feed string) error {
+	return c.do("resume", feed)
+}
+
+// FetchNow starts immediate fetching of the feed.
+func (c *Control) FetchNow(feed string) error {
+	return c.do("fetch", feed)
+}
+
+// do sends the command to the channel and waits for its result.
+func (c *Control) do(action, feed string) error {
+	cmd := controlCommand{action: action, feed: feed, result: make(chan error, 1)}
+	select {
+	case <-c.done:
+		return ErrControlStopped
+	case c.commands <- cmd:
+	}
+	return <-cmd.result
+}
+
+func (c *Control) exec(cmd controlCommand) error {
+	c.log.Infof("Control: %s %s", cmd.action, cmd.feed)
+	switch cmd.action {
+	case "pause":
+		return c.pauser.Pause(cmd.feed)
+	case "resume":
+		return c.pauser.Resume(cmd.feed)
+	case "fetch":
+		return c.trigger.FetchNow(cmd.feed)
+	default:
+		return fmt.Errorf("unknown command: %s", cmd.action)
+	}
+}
+
+// Serve handles commands of the control socket on the listener until
+// the context is cancelled. Each connection has a single command line
+// "<pause|resume|fetch> <feed>", and gets "ok" or "error: <message>"
+// in reply.
+func (c *Control) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		go c.handle(conn)
+	}
+}
+
+// handle executes the command of the connection.
+func (c *Control) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout)) //nolint:errcheck
+
+	reply := "ok"
+	action, feed, err := c.command(bufio.NewReader(conn))
+	if err != nil {
+		reply = "error: " + err.Error()
+	} else {
+		c.auditCommand(conn, action, feed)
+	}
+	if _, err := fmt.Fprintln(conn, reply); err != nil {
+		c.log.Errorf("Failed to reply to control command: %v", err)
+	}
+}
+
+// command reads and executes the command, and returns its action and feed.
+func (c *Control) command(r *bufio.Reader) (action, feed string, err error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", "", fmt.Errorf("read command: %w", err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return "", "", errors.New("usage: <pause|resume|fetch> <feed>")
+	}
+	return fields[0], fields[1], c.do(fields[0], fields[1])
+}
+
+// auditCommand writes the successful command of the connection to
+// the audit log. Only the owner of the bot can connect to the socket, so
+// the user is an admin, and their process is written, if it's known.
+func (c *Control) auditCommand(conn net.Conn, action, feed string) {
+	if c.audit == nil {
+		return
+	}
+	user := "control"
+	if p := peer(conn); p != "" {
+		user += " " + p
+	}
+	e := AuditEntry{
+		Time:   time.Now(),
+		User:   user,
+		Role:   RoleAdmin.String(),
+		Action: action,
+		Target: feed,
+	}
+	if err := c.audit.AddAuditEntry(e); err != nil {
+		c.log.Errorf("Failed to write audit log: %v", err)
+	}
+}
+
+// SendControl sends the command to the control socket of the running bot.
+func SendControl(socket, action, feed string) error {
+	conn, err := net.DialTimeout("unix", socket, controlTimeout)
+	if err != nil {
+		return fmt.Errorf("connect to the bot: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout)) //nolint:errcheck
+
+	if _, err := fmt.Fprintf(conn, "%s %s\n", action, feed); err != nil {
+		return fmt.Errorf("send command: %w", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("read reply: %w", err)
+	}
+	reply = strings.TrimSpace(reply)
+	if msg, ok := strings.CutPrefix(reply, "error: "); ok {
+		return errors.New(msg)
+	}
+	if reply != "ok" {
+		return fmt.Errorf("unexpected reply: %s", reply)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peer returns the process of the other side of the unix socket
+// connection, or an empty string, if it's unknown.
+func peer(conn net.Conn) string {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ""
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return ""
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED) //nolint:gosec
+	})
+	if err != nil || credErr != nil {
+		return ""
+	}
+	return fmt.Sprintf("uid=%d pid=%d", cred.Uid, cred.Pid)
+}
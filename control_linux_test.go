@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeer(t *testing.T) {
+	t.Run("unix socket", func(t *testing.T) {
+		socket := filepath.Join(os.TempDir(), fmt.Sprintf("feed-bot-testing-%d.sock", time.Now().UnixNano()))
+		ln, err := net.Listen("unix", socket)
+		assert.NoError(t, err)
+		defer ln.Close()
+
+		client, err := net.Dial("unix", socket)
+		assert.NoError(t, err)
+		defer client.Close()
+		conn, err := ln.Accept()
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		assert.Equal(t, fmt.Sprintf("uid=%d pid=%d", os.Getuid(), os.Getpid()), peer(conn))
+	})
+
+	t.Run("other connection", func(t *testing.T) {
+		client, conn := net.Pipe()
+		defer client.Close()
+		defer conn.Close()
+
+		assert.Empty(t, peer(conn))
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// peer returns the process of the other side of the unix socket
+// connection. Peer credentials are only read on Linux.
+func peer(_ net.Conn) string {
+	return ""
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControl(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	t.Run("commands", func(t *testing.T) {
+		r := NewFeedRegistry(Config{Feeds: []Feed{{URL: "f1"}, {URL: "f2"}}}, &testRegistryStorage{})
+		trigger := &testTrigger{known: r.Has}
+		c := NewControl(r, trigger, nil, log)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go c.Run(ctx)
+
+		assert.NoError(t, c.Pause("f1"))
+		assert.NoError(t, c.FetchNow("f2"))
+		assert.Equal(t, []Feed{{URL: "f2"}}, r.Feeds())
+		assert.Equal(t, []string{"f2"}, trigger.feeds)
+
+		assert.NoError(t, c.Resume("f1"))
+		assert.Equal(t, []Feed{{URL: "f1"}, {URL: "f2"}}, r.Feeds())
+
+		assert.ErrorIs(t, c.Pause("f3"), ErrUnknownFeed)
+		assert.ErrorIs(t, c.FetchNow("f3"), ErrUnknownFeed)
+		assert.EqualError(t, c.do("drop", "f1"), "unknown command: drop")
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		r := NewFeedRegistry(Config{Feeds: []Feed{{URL: "f1"}}}, &testRegistryStorage{})
+		c := NewControl(r, &testTrigger{known: r.Has}, nil, log)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			c.Run(ctx)
+		}()
+		cancel()
+		<-done
+
+		assert.ErrorIs(t, c.Pause("f1"), ErrControlStopped)
+		assert.Equal(t, []Feed{{URL: "f1"}}, r.Feeds())
+	})
+}
+
+func TestControl_Serve(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	socket := filepath.Join(os.TempDir(), fmt.Sprintf("feed-bot-testing-%d.sock", time.Now().UnixNano()))
+	ln, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+
+	r := NewFeedRegistry(Config{Feeds: []Feed{{URL: "f1"}}}, &testRegistryStorage{})
+	s := &testAdminStorage{}
+	c := NewControl(r, &testTrigger{known: r.Has}, s, log)
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.Run(ctx)
+	done := make(chan error)
+	go func() { done <- c.Serve(ctx, ln) }()
+
+	assert.NoError(t, SendControl(socket, "pause", "f1"))
+	assert.Empty(t, r.Feeds())
+	assert.EqualError(t, SendControl(socket, "pause", "f2"), "unknown feed: f2")
+	assert.EqualError(t, SendControl(socket, "pause", "f1 f2"), "usage: <pause|resume|fetch> <feed>")
+
+	// Only successful commands are written to the audit log
+	s.mx.Lock()
+	assert.Len(t, s.audit, 1)
+	assert.True(t, strings.HasPrefix(s.audit[0].User, "control"))
+	assert.Equal(t, "admin", s.audit[0].Role)
+	assert.Equal(t, "pause", s.audit[0].Action)
+	assert.Equal(t, "f1", s.audit[0].Target)
+	s.mx.Unlock()
+
+	cancel()
+	assert.NoError(t, <-done)
+	// The socket is removed on close
+	assert.ErrorContains(t, SendControl(socket, "resume", "f1"), "connect to the bot")
+}
+
+type testTrigger struct {
+	known func(feed string) bool
+	feeds []string
+	mx    sync.Mutex
+}
+
+func (t *testTrigger) FetchNow(feed string) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if !t.known(feed) {
+		return fmt.Errorf("%w: %s", ErrUnknownFeed, feed)
+	}
+	t.feeds = append(t.feeds, feed)
+	return nil
+}
+
+// testControl changes feeds of the registry directly, and saves feeds,
+// that are fetched.
+type testControl struct {
+	*FeedRegistry
+	*testTrigger
+}
+
+func newTestControl(r *FeedRegistry) *testControl {
+	return &testControl{FeedRegistry: r, testTrigger: &testTrigger{known: r.Has}}
+}
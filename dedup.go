@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SeenStorage describes storage for keys of delivered items.
+type SeenStorage interface {
+	GetSeen(key string) time.Time
+	SaveSeen(keys []string, t, expire time.Time) error
+}
+
+// Deduplicator suppresses items that were already delivered from any feed
+// within the time window. Items are matched by canonical URL, and by
+// fingerprint of their title and description, if they have a description.
+type Deduplicator struct {
+	storage SeenStorage
+	window  time.Duration
+
+	// perFeed limits matching to items of the same feed.
+	perFeed bool
+}
+
+// NewDeduplicator creates new deduplicator. If perFeed is set, items are
+// matched only within their feed, e.g. in multi-tenant mode, where feeds
+// have different subscribers, and an item from one feed must not hide
+// the same item from another.
+func NewDeduplicator(s SeenStorage, window time.Duration, perFeed bool) *Deduplicator {
+	return &Deduplicator{storage: s, window: window, perFeed: perFeed}
+}
+
+// Seen checks if the item was delivered within the window.
+func (d *Deduplicator) Seen(item Item) bool {
+	threshold := time.Now().Add(-d.window)
+	for _, key := range d.keys(item) {
+		if d.storage.GetSeen(key).After(threshold) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add marks the item as delivered, and forgets items that are out of
+// the window.
+func (d *Deduplicator) Add(item Item) error {
+	now := time.Now()
+	if err := d.storage.SaveSeen(d.keys(item), now, now.Add(-d.window)); err != nil {
+		return fmt.Errorf("save seen item: %w", err)
+	}
+	return nil
+}
+
+// keys returns keys of the item, that are prefixed with its feed, if items
+// are matched within feeds.
+func (d *Deduplicator) keys(item Item) []string {
+	keys := itemKeys(item)
+	if d.perFeed {
+		for i := range keys {
+			keys[i] = "feed:" + item.Feed + " " + keys[i]
+		}
+	}
+	return keys
+}
+
+// itemKeys returns keys that identify the item across feeds.
+func itemKeys(item Item) []string {
+	var keys []string
+	if item.Link != "" {
+		u := CanonicalURL(item.Link)
+		// Same article is often available over both http and https
+		u = strings.TrimPrefix(strings.TrimPrefix(u, "http://"), "https://")
+		keys = append(keys, "url:"+u)
+	}
+	// Generic titles, like "Release notes", are often the same in unrelated
+	// feeds, so items without text are matched only by URL
+	if item.Title != "" && strings.TrimSpace(item.Description) != "" {
+		keys = append(keys, "hash:"+fingerprint(item.Title, item.Description))
+	}
+	return keys
+}
+
+// fingerprint returns a hash of the text, that doesn't depend on letter
+// case and whitespace.
+func fingerprint(text ...string) string {
+	h := sha256.New()
+	for _, t := range text {
+		h.Write([]byte(strings.Join(strings.Fields(strings.ToLower(t)), " ")))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// trackingParams are query parameters used only for tracking visitors.
+// Parameters with utm_ prefix are removed as well.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"yclid":   true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+	"mkt_tok": true,
+}
+
+// redirectParams are query parameters that hold the target URL of known
+// tracking redirects, by redirect host.
+var redirectParams = map[string][]string{
+	"www.google.com": {"url", "q"},
+	"google.com":     {"url", "q"},
+	"l.facebook.com": {"u"},
+	"out.reddit.com": {"url"},
+	"t.umblr.com":    {"z"},
+}
+
+// CanonicalURL normalizes the URL: unwraps tracking redirects, removes
+// tracking query parameters and the fragment. Invalid URLs are returned
+// as is.
+func CanonicalURL(s string) string {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Host == "" {
+		return s
+	}
+
+	// Unwrap redirects, possibly nested
+	for range 3 {
+		target := redirectTarget(u)
+		if target == nil {
+			break
+		}
+		u = target
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Host = strings.TrimSuffix(u.Host, ":80")
+	u.Host = strings.TrimSuffix(u.Host, ":443")
+	u.Fragment, u.RawFragment = "", ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	q := u.Query()
+	for p := range q {
+		if strings.HasPrefix(strings.ToLower(p), "utm_") || trackingParams[strings.ToLower(p)] {
+			q.Del(p)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// redirectTarget returns the target URL if u is a known tracking redirect.
+func redirectTarget(u *url.URL) *url.URL {
+	params, ok := redirectParams[strings.ToLower(u.Host)]
+	if !ok {
+		return nil
+	}
+	q := u.Query()
+	for _, p := range params {
+		target, err := url.Parse(q.Get(p))
+		if err == nil && target.Host != "" &&
+			(target.Scheme == "http" || target.Scheme == "https") {
+			return target
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	testCases := []struct {
+		in  string
+		out string
+	}{
+		{
+			in:  "https://example.com/post?id=1",
+			out: "https://example.com/post?id=1",
+		},
+		{
+			in:  "HTTPS://Example.COM:443/post?utm_source=rss&utm_medium=feed&id=1#comments",
+			out: "https://example.com/post?id=1",
+		},
+		{
+			in:  "https://example.com?fbclid=abc",
+			out: "https://example.com/",
+		},
+		{
+			in:  "https://www.google.com/url?rct=j&url=https%3A%2F%2Fexample.com%2Fpost%3Futm_source%3Dgoogle",
+			out: "https://example.com/post",
+		},
+		{
+			in:  "https://l.facebook.com/l.php?u=https%3A%2F%2Fexample.com%2Fpost&h=abc",
+			out: "https://example.com/post",
+		},
+		{
+			in:  "https://www.google.com/search?q=golang",
+			out: "https://www.google.com/search?q=golang",
+		},
+		{
+			in:  "not a url",
+			out: "not a url",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			assert.Equal(t, tc.out, CanonicalURL(tc.in))
+		})
+	}
+}
+
+func TestItemKeys(t *testing.T) {
+	a := Item{Link: "http://example.com/post?utm_source=a", Title: "Hello  World", Description: "Text"}
+	b := Item{Link: "https://example.com/post", Title: "hello world", Description: "text"}
+	assert.Equal(t, itemKeys(a), itemKeys(b))
+
+	assert.Len(t, itemKeys(Item{Link: "https://example.com/post"}), 1)
+	assert.Len(t, itemKeys(Item{Title: "Title", Description: "Text"}), 1)
+	// Titles without text are too generic
+	assert.Len(t, itemKeys(Item{Title: "Title"}), 0)
+	assert.Len(t, itemKeys(Item{}), 0)
+}
+
+func TestDeduplicator(t *testing.T) {
+	storage := &testSeenStorage{seen: map[string]time.Time{}}
+	d := NewDeduplicator(storage, time.Hour, false)
+
+	item := Item{Link: "https://example.com/post", Title: "Title", Description: "Text"}
+	assert.False(t, d.Seen(item))
+	assert.NoError(t, d.Add(item))
+	assert.True(t, d.Seen(item))
+
+	// Same link from another feed
+	assert.True(t, d.Seen(Item{Link: "https://example.com/post?utm_source=other"}))
+	// Same title and description with another link
+	assert.True(t, d.Seen(Item{Link: "https://mirror.example.com/post", Title: "TITLE", Description: "text"}))
+	// Same generic title without text in another feed
+	assert.False(t, d.Seen(Item{Link: "https://other.example.com/notes", Title: "Title"}))
+	// Different item
+	assert.False(t, d.Seen(Item{Link: "https://example.com/other", Title: "Other", Description: "Text"}))
+
+	// Out of the window
+	for k := range storage.seen {
+		storage.seen[k] = time.Now().Add(-2 * time.Hour)
+	}
+	assert.False(t, d.Seen(item))
+}
+
+func TestDeduplicator_PerFeed(t *testing.T) {
+	d := NewDeduplicator(&testSeenStorage{seen: map[string]time.Time{}}, time.Hour, true)
+
+	item := Item{Feed: "f1", Link: "https://example.com/post"}
+	assert.NoError(t, d.Add(item))
+	assert.True(t, d.Seen(Item{Feed: "f1", Link: "https://example.com/post?utm_source=f1"}))
+	// Same link from another feed, that may have other subscribers
+	assert.False(t, d.Seen(Item{Feed: "f2", Link: "https://example.com/post"}))
+}
+
+type testSeenStorage struct {
+	seen map[string]time.Time
+}
+
+func (s *testSeenStorage) GetSeen(key string) time.Time {
+	return s.seen[key]
+}
+
+func (s *testSeenStorage) SaveSeen(keys []string, t, _ time.Time) error {
+	for _, k := range keys {
+		s.seen[k] = t
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Errors of destination changes.
+var (
+	// ErrUnknownDestination is returned for chats without destinations.
+	ErrUnknownDestination = errors.New("unknown destination")
+	// ErrDuplicateDestination is returned for chats, that already have
+	// destinations.
+	ErrDuplicateDestination = errors.New("duplicate destination chat")
+	// ErrInvalidDestination is returned for destinations with invalid
+	// settings.
+	ErrInvalidDestination = errors.New("invalid destination")
+	// ErrConfigDestination is returned for changes of destinations from
+	// the config.
+	ErrConfigDestination = errors.New("destination from the config can't be changed")
+)
+
+// DestinationStorage keeps destinations added at runtime.
+type DestinationStorage interface {
+	GetDestinations() []Destination
+	SaveDestinations(d []Destination) error
+}
+
+// DestinationInfo is a destination with its origin.
+type DestinationInfo struct {
+	Destination Destination `json:"destination"`
+	// Config is true for destinations from the config, that can't be
+	// changed at runtime.
+	Config bool `json:"config"`
+}
+
+// DestinationRegistry is a notifier for destinations, that are added at
+// runtime, and kept in the storage. Each chat can have a single runtime
+// destination. Destinations from the config are only listed, items are
+// sent to them by other notifiers.
+//
+// Digests, quiet hours and message updates need background loops and
+// the history of sent messages, so they can only be set in the config.
+type DestinationRegistry struct {
+	config  []Destination
+	storage DestinationStorage
+
+	// known reports whether the feed exists, and build creates a notifier
+	// of the destination.
+	known func(feed string) bool
+	build func(d Destination) (Notifier, error)
+
+	notifiers map[string]Notifier
+	mx        *sync.Mutex
+}
+
+// NewDestinationRegistry creates a registry of destinations.
+func NewDestinationRegistry(
+	config []Destination,
+	s DestinationStorage,
+	known func(feed string) bool,
+	build func(d Destination) (Notifier, error),
+) *DestinationRegistry {
+	return &DestinationRegistry{
+		config:    config,
+		storage:   s,
+		known:     known,
+		build:     build,
+		notifiers: map[string]Notifier{},
+		mx:        &sync.Mutex{},
+	}
+}
+
+// List returns all destinations, destinations from the config first.
+func (r *DestinationRegistry) List() []DestinationInfo {
+	added := r.storage.GetDestinations()
+	list := make([]DestinationInfo, 0, len(r.config)+len(added))
+	for _, d := range r.config {
+		list = append(list, DestinationInfo{Destination: d, Config: true})
+	}
+	for _, d := range added {
+		list = append(list, DestinationInfo{Destination: d})
+	}
+	return list
+}
+
+// Get returns the destination of the chat.
+func (r *DestinationRegistry) Get(chat string) (DestinationInfo, bool) {
+	for _, d := range r.List() {
+		if d.Destination.Chat == chat {
+			return d, true
+		}
+	}
+	return DestinationInfo{}, false
+}
+
+// Add adds the destination.
+func (r *DestinationRegistry) Add(d Destination) error {
+	if err := r.validate(d); err != nil {
+		return err
+	}
+	return r.change(func(list []Destination) ([]Destination, error) {
+		if r.isConfig(d.Chat) || slices.ContainsFunc(list, sameChat(d.Chat)) {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateDestination, d.Chat)
+		}
+		return append(list, d), nil
+	})
+}
+
+// Update replaces settings of the destination.
+func (r *DestinationRegistry) Update(d Destination) error {
+	if err := r.validate(d); err != nil {
+		return err
+	}
+	return r.change(func(list []Destination) ([]Destination, error) {
+		i := slices.IndexFunc(list, sameChat(d.Chat))
+		if i < 0 {
+			return nil, r.unknown(d.Chat)
+		}
+		list[i] = d
+		return list, nil
+	})
+}
+
+// Remove removes the destination of the chat.
+func (r *DestinationRegistry) Remove(chat string) error {
+	return r.change(func(list []Destination) ([]Destination, error) {
+		if !slices.ContainsFunc(list, sameChat(chat)) {
+			return nil, r.unknown(chat)
+		}
+		return slices.DeleteFunc(list, sameChat(chat)), nil
+	})
+}
+
+// Notify sends the item to all destinations of its feed. Items without
+// a feed are sent to all destinations.
+func (r *DestinationRegistry) Notify(ctx context.Context, item Item) error {
+	var errs []error
+	for _, d := range r.storage.GetDestinations() {
+		if item.Feed != "" && len(d.Feeds) > 0 && !slices.Contains(d.Feeds, item.Feed) {
+			continue
+		}
+		n, err := r.notifier(d)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := n.Notify(ctx, item); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notifier returns a notifier of the destination.
+func (r *DestinationRegistry) notifier(d Destination) (Notifier, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if n, ok := r.notifiers[d.Chat]; ok {
+		return n, nil
+	}
+	n, err := r.build(d)
+	if err != nil {
+		return nil, fmt.Errorf("init notifier of %s: %w", d.Chat, err)
+	}
+	r.notifiers[d.Chat] = n
+	return n, nil
+}
+
+// validate checks the destination, that is added at runtime.
+func (r *DestinationRegistry) validate(d Destination) error {
+	if err := validateDestination(d, r.known); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDestination, err)
+	}
+	if d.Digest != nil || d.QuietHours != nil || d.Message.Edit || d.Message.Delete {
+		return fmt.Errorf("%w: digests, quiet hours and message updates can only be set in the config",
+			ErrInvalidDestination)
+	}
+	return nil
+}
+
+// change applies the change to the stored destinations. Notifiers are
+// created again with the new settings.
+func (r *DestinationRegistry) change(apply func(list []Destination) ([]Destination, error)) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	list, err := apply(r.storage.GetDestinations())
+	if err != nil {
+		return err
+	}
+	if err := r.storage.SaveDestinations(list); err != nil {
+		return fmt.Errorf("save destinations: %w", err)
+	}
+	r.notifiers = map[string]Notifier{}
+	return nil
+}
+
+// unknown returns an error for the chat, that has no runtime destination.
+func (r *DestinationRegistry) unknown(chat string) error {
+	if r.isConfig(chat) {
+		return fmt.Errorf("%w: %s", ErrConfigDestination, chat)
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownDestination, chat)
+}
+
+// isConfig reports whether the chat has a destination in the config.
+func (r *DestinationRegistry) isConfig(chat string) bool {
+	return slices.ContainsFunc(r.config, sameChat(chat))
+}
+
+// sameChat returns a function, that matches destinations of the chat.
+func sameChat(chat string) func(d Destination) bool {
+	return func(d Destination) bool { return d.Chat == chat }
+}
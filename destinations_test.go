@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDestinationRegistry(t *testing.T) {
+	config := []Destination{{Chat: "config"}}
+	known := func(feed string) bool { return feed == "f1" || feed == "f2" }
+
+	t.Run("add and remove", func(t *testing.T) {
+		s := &testDestinationStorage{}
+		r := NewDestinationRegistry(config, s, known, testBuild(map[string]*testNotifier{}))
+
+		assert.NoError(t, r.Add(Destination{Chat: "one"}))
+		assert.NoError(t, r.Add(Destination{Chat: "two", Feeds: []string{"f1"}}))
+		assert.Equal(t, []DestinationInfo{
+			{Destination: Destination{Chat: "config"}, Config: true},
+			{Destination: Destination{Chat: "one"}},
+			{Destination: Destination{Chat: "two", Feeds: []string{"f1"}}},
+		}, r.List())
+
+		assert.NoError(t, r.Update(Destination{Chat: "two", Feeds: []string{"f2"}}))
+		d, ok := r.Get("two")
+		assert.True(t, ok)
+		assert.Equal(t, DestinationInfo{Destination: Destination{Chat: "two", Feeds: []string{"f2"}}}, d)
+
+		assert.NoError(t, r.Remove("one"))
+		assert.Equal(t, []Destination{{Chat: "two", Feeds: []string{"f2"}}}, s.dests)
+		_, ok = r.Get("one")
+		assert.False(t, ok)
+	})
+
+	t.Run("invalid changes", func(t *testing.T) {
+		s := &testDestinationStorage{}
+		r := NewDestinationRegistry(config, s, known, testBuild(map[string]*testNotifier{}))
+		assert.NoError(t, r.Add(Destination{Chat: "one"}))
+
+		runtime := "invalid destination: digests, quiet hours and message updates can only be set in the config"
+		for _, tc := range []struct {
+			dest Destination
+			msg  string
+		}{
+			{Destination{}, "invalid destination: empty destination chat"},
+			{Destination{Chat: "two", Feeds: []string{"f3"}}, "invalid destination: unknown feed of destination two: f3"},
+			{Destination{Chat: "two", Digest: &DigestConfig{Schedule: "@daily"}}, runtime},
+			{Destination{Chat: "two", Message: MessageOptions{Edit: true}}, runtime},
+			{Destination{Chat: "one"}, "duplicate destination chat: one"},
+			{Destination{Chat: "config"}, "duplicate destination chat: config"},
+		} {
+			assert.EqualError(t, r.Add(tc.dest), tc.msg)
+		}
+		assert.ErrorIs(t, r.Update(Destination{Chat: "two"}), ErrUnknownDestination)
+		assert.ErrorIs(t, r.Update(Destination{Chat: "config"}), ErrConfigDestination)
+		assert.ErrorIs(t, r.Remove("two"), ErrUnknownDestination)
+		assert.EqualError(t, r.Remove("config"), "destination from the config can't be changed: config")
+		assert.Equal(t, []Destination{{Chat: "one"}}, s.dests)
+	})
+
+	t.Run("notify", func(t *testing.T) {
+		notifiers := map[string]*testNotifier{}
+		s := &testDestinationStorage{dests: []Destination{
+			{Chat: "one"},
+			{Chat: "two", Feeds: []string{"f1"}},
+		}}
+		r := NewDestinationRegistry(config, s, known, testBuild(notifiers))
+
+		ctx := context.Background()
+		assert.NoError(t, r.Notify(ctx, Item{Feed: "f1", Link: "One"}))
+		assert.NoError(t, r.Notify(ctx, Item{Feed: "f2", Link: "Two"}))
+		// Items without feeds are sent to all destinations
+		assert.NoError(t, r.Notify(ctx, Item{Link: "Three"}))
+
+		assert.Equal(t, []Item{
+			{Feed: "f1", Link: "One"}, {Feed: "f2", Link: "Two"}, {Link: "Three"},
+		}, notifiers["one"].items)
+		assert.Equal(t, []Item{{Feed: "f1", Link: "One"}, {Link: "Three"}}, notifiers["two"].items)
+
+		// Notifiers are created again after changes
+		assert.NoError(t, r.Update(Destination{Chat: "two"}))
+		assert.NoError(t, r.Notify(ctx, Item{Feed: "f2", Link: "Four"}))
+		assert.Equal(t, []Item{{Feed: "f2", Link: "Four"}}, notifiers["two"].items)
+	})
+
+	t.Run("notify error", func(t *testing.T) {
+		s := &testDestinationStorage{dests: []Destination{{Chat: "one"}}}
+		r := NewDestinationRegistry(nil, s, known, func(Destination) (Notifier, error) {
+			return nil, errors.New("fail")
+		})
+		assert.EqualError(t, r.Notify(context.Background(), Item{}), "init notifier of one: fail")
+	})
+
+	t.Run("storage error", func(t *testing.T) {
+		s := &testDestinationStorage{err: errors.New("fail")}
+		r := NewDestinationRegistry(nil, s, known, testBuild(map[string]*testNotifier{}))
+		assert.EqualError(t, r.Add(Destination{Chat: "one"}), "save destinations: fail")
+	})
+}
+
+// testBuild returns a builder of test notifiers, that saves created
+// notifiers by their chats.
+func testBuild(notifiers map[string]*testNotifier) func(d Destination) (Notifier, error) {
+	return func(d Destination) (Notifier, error) {
+		n := &testNotifier{}
+		notifiers[d.Chat] = n
+		return n, nil
+	}
+}
+
+type testDestinationStorage struct {
+	dests []Destination
+	err   error
+	mx    sync.Mutex
+}
+
+func (s *testDestinationStorage) GetDestinations() []Destination {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return append([]Destination{}, s.dests...)
+}
+
+func (s *testDestinationStorage) SaveDestinations(d []Destination) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.dests = d
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Digest grouping modes.
+const (
+	GroupByFeed     = "feed"
+	GroupByCategory = "category"
+)
+
+// maxMessageLength is the max length of Telegram message text.
+const maxMessageLength = 4096
+
+// QueueStorage describes storage for named queues of items.
+type QueueStorage interface {
+	PushQueueItem(queue string, item Item) error
+	GetQueueItems(queue string) []Item
+	RemoveQueueItems(queue string, n int) error
+}
+
+// Schedule returns the next activation time after the given time.
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+// ParseSchedule parses a cron expression with 5 fields, or one of the
+// descriptors like @daily.
+func ParseSchedule(s string) (Schedule, error) {
+	sched, err := cron.ParseStandard(s)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	return sched, nil
+}
+
+// Digest is a notifier, that collects items in the storage, and sends
+// them as a single grouped message on schedule.
+type Digest struct {
+	queue    string
+	notifier Notifier
+	storage  QueueStorage
+	schedule Schedule
+	groupBy  string
+	log      *logrus.Logger
+}
+
+// NewDigest creates new digest. Name identifies the digest in the storage.
+func NewDigest(
+	name string,
+	n Notifier,
+	s QueueStorage,
+	conf DigestConfig,
+	log *logrus.Logger,
+) (*Digest, error) {
+	sched, err := ParseSchedule(conf.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("parse schedule: %w", err)
+	}
+	groupBy := conf.GroupBy
+	if groupBy == "" {
+		groupBy = GroupByFeed
+	}
+	return &Digest{
+		queue:    "digest:" + name,
+		notifier: n,
+		storage:  s,
+		schedule: sched,
+		groupBy:  groupBy,
+		log:      log,
+	}, nil
+}
+
+// Notify saves the item for the next digest.
+func (d *Digest) Notify(_ context.Context, item Item) error {
+	if err := d.storage.PushQueueItem(d.queue, item); err != nil {
+		return fmt.Errorf("save digest item: %w", err)
+	}
+	return nil
+}
+
+// Run sends digests on schedule until the context is cancelled.
+func (d *Digest) Run(ctx context.Context) {
+	defer startRunner(ctx, d.notifier)()
+
+	for {
+		now := time.Now()
+		t := time.NewTimer(d.schedule.Next(now).Sub(now))
+		select {
+		case <-t.C:
+			if err := d.Flush(ctx); err != nil {
+				d.log.Errorf("Failed to send digest [%s]: %v", d.queue, err)
+			}
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// Flush sends all collected items, and removes them from the storage.
+// Items are kept if sending fails.
+func (d *Digest) Flush(ctx context.Context) error {
+	items := d.storage.GetQueueItems(d.queue)
+	if len(items) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, msg := range renderDigest(items, d.groupBy) {
+		err := d.notifier.Notify(ctx, Item{Published: now, Text: msg})
+		if err != nil {
+			return fmt.Errorf("send digest: %w", err)
+		}
+	}
+	if err := d.storage.RemoveQueueItems(d.queue, len(items)); err != nil {
+		return fmt.Errorf("remove digest items: %w", err)
+	}
+	return nil
+}
+
+// renderDigest renders items as text grouped by feed or category. Text is
+// split to several messages if it doesn't fit into one.
+func renderDigest(items []Item, groupBy string) []string {
+	var groups []string
+	grouped := map[string][]Item{}
+	for _, item := range items {
+		g := item.FeedTitle
+		if g == "" {
+			g = item.Feed
+		}
+		if groupBy == GroupByCategory {
+			g = item.Category
+			if g == "" {
+				g = "Other"
+			}
+		}
+		if _, ok := grouped[g]; !ok {
+			groups = append(groups, g)
+		}
+		grouped[g] = append(grouped[g], item)
+	}
+
+	var msgs []string
+	var b strings.Builder
+	add := func(s string) {
+		if b.Len() > 0 && utf8.RuneCountInString(b.String())+utf8.RuneCountInString(s) > maxMessageLength {
+			msgs = append(msgs, strings.TrimSpace(b.String()))
+			b.Reset()
+		}
+		b.WriteString(truncate(s, maxMessageLength))
+	}
+
+	add(fmt.Sprintf("Digest: %d new items\n", len(items)))
+	for _, g := range groups {
+		add("\n" + g + "\n")
+		for _, item := range grouped[g] {
+			add(digestEntry(item))
+		}
+	}
+	msgs = append(msgs, strings.TrimSpace(b.String()))
+	return msgs
+}
+
+func digestEntry(item Item) string {
+	switch {
+	case item.Text != "":
+		return "- " + item.Text + "\n"
+	case item.Title != "":
+		return "- " + item.Title + "\n  " + item.Link + "\n"
+	default:
+		return "- " + item.Link + "\n"
+	}
+}
+
+// truncate cuts the string to n runes.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDigest(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	d, err := NewDigest("chat", &testNotifier{}, &testQueueStorage{}, DigestConfig{Schedule: "@daily"}, log)
+	assert.NoError(t, err)
+	assert.Equal(t, GroupByFeed, d.groupBy)
+
+	_, err = NewDigest("chat", &testNotifier{}, &testQueueStorage{}, DigestConfig{Schedule: "daily"}, log)
+	assert.ErrorContains(t, err, "parse schedule")
+}
+
+func TestDigest_Flush(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	t.Run("send digest", func(t *testing.T) {
+		n := &testNotifier{}
+		s := &testQueueStorage{}
+		d, err := NewDigest("chat", n, s, DigestConfig{Schedule: "@daily"}, log)
+		assert.NoError(t, err)
+
+		assert.NoError(t, d.Notify(context.Background(), Item{
+			Link: "https://example.com/1", Title: "One", FeedTitle: "Example",
+		}))
+		assert.NoError(t, d.Notify(context.Background(), Item{
+			Link: "https://example.com/2", FeedTitle: "Example",
+		}))
+		assert.Empty(t, n.items)
+
+		assert.NoError(t, d.Flush(context.Background()))
+		assert.Len(t, n.items, 1)
+		assert.Equal(t, "Digest: 2 new items\n\n"+
+			"Example\n"+
+			"- One\n"+
+			"  https://example.com/1\n"+
+			"- https://example.com/2", n.items[0].Text)
+		assert.Empty(t, s.GetQueueItems("digest:chat"))
+
+		// Nothing to send
+		assert.NoError(t, d.Flush(context.Background()))
+		assert.Len(t, n.items, 1)
+	})
+
+	t.Run("send error", func(t *testing.T) {
+		s := &testQueueStorage{}
+		d, err := NewDigest("chat", &testFailingNotifier{}, s, DigestConfig{Schedule: "@daily"}, log)
+		assert.NoError(t, err)
+
+		assert.NoError(t, d.Notify(context.Background(), Item{Link: "https://example.com/1"}))
+		assert.EqualError(t, d.Flush(context.Background()), "send digest: fail")
+		assert.Len(t, s.GetQueueItems("digest:chat"), 1)
+	})
+}
+
+func TestDigest_Run(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	n := &testNotifier{}
+	s := &testQueueStorage{}
+	d, err := NewDigest("chat", n, s, DigestConfig{Schedule: "@daily"}, log)
+	assert.NoError(t, err)
+	d.schedule = testSchedule(10 * time.Millisecond)
+	assert.NoError(t, d.Notify(context.Background(), Item{Link: "https://example.com/1"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+	defer cancel()
+	d.Run(ctx)
+
+	assert.Len(t, n.items, 1)
+}
+
+func TestRenderDigest(t *testing.T) {
+	t.Run("group by category", func(t *testing.T) {
+		items := []Item{
+			{Link: "https://example.com/1", Category: "Tech"},
+			{Link: "https://example.com/2"},
+			{Link: "https://example.com/3", Category: "Tech"},
+		}
+		msgs := renderDigest(items, GroupByCategory)
+		expected := []string{"Digest: 3 new items\n\n" +
+			"Tech\n" +
+			"- https://example.com/1\n" +
+			"- https://example.com/3\n\n" +
+			"Other\n" +
+			"- https://example.com/2"}
+		assert.Equal(t, expected, msgs)
+	})
+
+	t.Run("split long digest", func(t *testing.T) {
+		var items []Item
+		for range 100 {
+			items = append(items, Item{
+				Feed:  "https://example.com/rss.xml",
+				Link:  "https://example.com/" + strings.Repeat("a", 80),
+				Title: strings.Repeat("Title ", 10),
+			})
+		}
+		msgs := renderDigest(items, GroupByFeed)
+		assert.Len(t, msgs, 5)
+		for _, msg := range msgs {
+			assert.LessOrEqual(t, len(msg), maxMessageLength)
+		}
+		assert.True(t, strings.HasPrefix(msgs[0], "Digest: 100 new items\n\nhttps://example.com/rss.xml\n"))
+	})
+}
+
+// testSchedule activates with constant delay.
+type testSchedule time.Duration
+
+func (s testSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+type testQueueStorage struct {
+	items map[string][]Item
+	mx    sync.Mutex
+}
+
+func (s *testQueueStorage) PushQueueItem(queue string, item Item) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.items == nil {
+		s.items = map[string][]Item{}
+	}
+	s.items[queue] = append(s.items[queue], item)
+	return nil
+}
+
+func (s *testQueueStorage) GetQueueItems(queue string) []Item {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.items[queue]
+}
+
+func (s *testQueueStorage) RemoveQueueItems(queue string, n int) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.items[queue] = s.items[queue][n:]
+	return nil
+}
+
+type testFailingNotifier struct{}
+
+func (n *testFailingNotifier) Notify(_ context.Context, _ Item) error {
+	return errors.New("fail")
+}
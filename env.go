@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is a prefix for environment variables that override config.
+const envPrefix = "FEEDBOT_"
+
+// redacted replaces secret values when the config is printed.
+const redacted = "***"
+
+// applyEnv overrides config fields from environment variables. A variable
+// name is the upper-cased yaml key of the field with FEEDBOT_ prefix, e.g.
+// FEEDBOT_UPDATE_INTERVAL. Non-string values are parsed as YAML, so lists
+// can be set like FEEDBOT_FEEDS='["https://example.com/rss.xml"]'.
+//
+// String fields also have a variant with _FILE suffix, which reads the value
+// from a file, e.g. FEEDBOT_TELEGRAM_TOKEN_FILE=/run/secrets/token.
+func applyEnv(conf *Config) error {
+	v := reflect.ValueOf(conf).Elem()
+	for i := range v.NumField() {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		field := v.Field(i)
+		key := envPrefix + strings.ToUpper(name)
+
+		if val, ok := os.LookupEnv(key); ok {
+			if err := setField(field, val); err != nil {
+				return fmt.Errorf("parse %s: %w", key, err)
+			}
+		}
+		if field.Kind() != reflect.String {
+			continue
+		}
+		if file, ok := os.LookupEnv(key + "_FILE"); ok {
+			val, err := readSecretFile(file)
+			if err != nil {
+				return fmt.Errorf("read %s_FILE: %w", key, err)
+			}
+			field.SetString(val)
+		}
+	}
+	return nil
+}
+
+// setField sets the field from a string value.
+func setField(field reflect.Value, val string) error {
+	if field.Kind() == reflect.String {
+		field.SetString(val)
+		return nil
+	}
+	ptr := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(val), ptr.Interface()); err != nil {
+		return err //nolint:wrapcheck
+	}
+	field.Set(ptr.Elem())
+	return nil
+}
+
+// readSecretFile reads a value from a file, e.g. a mounted secret.
+// Surrounding whitespace is trimmed.
+func readSecretFile(file string) (string, error) {
+	b, err := os.ReadFile(file) //nolint:gosec
+	if err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// String returns config as YAML with secret values redacted, so it's safe
+// to be printed to logs.
+func (c Config) String() string {
+	b, err := yaml.Marshal(redact(reflect.ValueOf(c)).Interface())
+	if err != nil {
+		return fmt.Sprintf("invalid config: %v", err)
+	}
+	return string(b)
+}
+
+// redact returns a copy of the value with all non-empty string fields
+// marked with `secret:"true"` tag replaced.
+func redact(v reflect.Value) reflect.Value {
+	switch v.Kind() { //nolint:exhaustive
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := range v.NumField() {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			field := out.Field(i)
+			if v.Type().Field(i).Tag.Get("secret") == "true" &&
+				field.Kind() == reflect.String && field.String() != "" {
+				field.SetString(redacted)
+				continue
+			}
+			field.Set(redact(v.Field(i)))
+		}
+		return out
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(redact(v.Elem()))
+		return out
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := range v.Len() {
+			out.Index(i).Set(redact(v.Index(i)))
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyEnv(t *testing.T) {
+	t.Run("override fields", func(t *testing.T) {
+		token := testConfigFile(t, "123456789:BBBBBBBBBBBBBBBBBBBBBBBBBBBBB-BBBBB\n")
+		t.Setenv("FEEDBOT_TELEGRAM_TOKEN_FILE", token)
+		t.Setenv("FEEDBOT_TELEGRAM_CHAT", "env_chat")
+		t.Setenv("FEEDBOT_UPDATE_INTERVAL", "15m")
+		t.Setenv("FEEDBOT_DEBUG", "true")
+		t.Setenv("FEEDBOT_FEEDS", `["https://example.com/env.xml"]`)
+
+		conf := Config{
+			TelegramToken:  "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAA-AAAAA",
+			TelegramChat:   "chat_name",
+			UpdateInterval: time.Hour,
+			DataFile:       "./data.yaml",
+			Feeds:          []Feed{{URL: "https://example.com/rss.xml"}},
+		}
+		assert.NoError(t, applyEnv(&conf))
+
+		expected := Config{
+			TelegramToken:     "123456789:BBBBBBBBBBBBBBBBBBBBBBBBBBBBB-BBBBB",
+			TelegramTokenFile: token,
+			TelegramChat:      "env_chat",
+			UpdateInterval:    15 * time.Minute,
+			DataFile:          "./data.yaml",
+			Feeds:             []Feed{{URL: "https://example.com/env.xml"}},
+			Debug:             true,
+		}
+		assert.Equal(t, expected, conf)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		t.Setenv("FEEDBOT_UPDATE_INTERVAL", "often")
+
+		var conf Config
+		assert.ErrorContains(t, applyEnv(&conf), "parse FEEDBOT_UPDATE_INTERVAL")
+	})
+
+	t.Run("missing secret file", func(t *testing.T) {
+		t.Setenv("FEEDBOT_TELEGRAM_CHAT_FILE", "abc.txt")
+
+		var conf Config
+		assert.ErrorContains(t, applyEnv(&conf), "read FEEDBOT_TELEGRAM_CHAT_FILE")
+	})
+}
+
+func TestReadConfig_TokenFile(t *testing.T) {
+	token := testConfigFile(t, "123456789:BBBBBBBBBBBBBBBBBBBBBBBBBBBBB-BBBBB\n")
+	f := testConfigFile(t, "telegram_token_file: "+token+"\n"+
+		"telegram_chat: chat_name\n"+
+		"feeds: [\"https://example.com/rss.xml\"]\n")
+
+	conf, err := ReadConfig(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456789:BBBBBBBBBBBBBBBBBBBBBBBBBBBBB-BBBBB", conf.TelegramToken)
+
+	// Environment overrides the config file
+	t.Setenv("FEEDBOT_TELEGRAM_TOKEN", "123456789:CCCCCCCCCCCCCCCCCCCCCCCCCCCCC-CCCCC")
+	conf, err = ReadConfig(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456789:CCCCCCCCCCCCCCCCCCCCCCCCCCCCC-CCCCC", conf.TelegramToken)
+}
+
+func TestConfig_String(t *testing.T) {
+	conf := Config{
+		TelegramToken:  "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAA-AAAAA",
+		TelegramChat:   "chat_name",
+		UpdateInterval: time.Hour,
+		Feeds:          []Feed{{URL: "https://example.com/rss.xml"}},
+		Translation:    &TranslationConfig{URL: "http://localhost:5000", APIKey: "secret_key"},
+	}
+
+	out := conf.String()
+	assert.Contains(t, out, "telegram_token: '***'\n")
+	assert.Contains(t, out, "  api_key: '***'\n")
+	assert.NotContains(t, out, "secret_key")
+	assert.Contains(t, out, "telegram_chat: chat_name\n")
+	assert.Contains(t, out, "feeds:\n- https://example.com/rss.xml\n")
+	assert.NotContains(t, out, "AAAAA")
+
+	// Original value is untouched
+	assert.Equal(t, "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAA-AAAAA", conf.TelegramToken)
+	assert.Equal(t, "secret_key", conf.Translation.APIKey)
+}
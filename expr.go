@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// exprEnv is an environment of expressions.
+type exprEnv struct {
+	Item Item      `expr:"item"`
+	Now  time.Time `expr:"now"`
+}
+
+// Expression is a processor, that filters and changes items using
+// expressions, e.g. `item.Title contains "Go"`. Expressions can use
+// the item and the current time as `now`.
+type Expression struct {
+	filter *vm.Program
+	set    []fieldProgram
+}
+
+// fieldProgram is a compiled expression of the item field's new value.
+type fieldProgram struct {
+	field   string
+	program *vm.Program
+}
+
+// NewExpression compiles the filter and field expressions. Items are
+// dropped when the filter is false, and fields of the rest are set to
+// values of their expressions. All expressions see the source item.
+func NewExpression(filter string, set map[string]string) (*Expression, error) {
+	if filter == "" && len(set) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	e := &Expression{}
+	if filter != "" {
+		p, err := expr.Compile(filter, expr.Env(exprEnv{}), expr.AsBool())
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+		e.filter = p
+	}
+
+	// Fields are set in a stable order
+	fields := make([]string, 0, len(set))
+	for f := range set {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		if _, ok := itemField(&Item{}, f); !ok {
+			return nil, fmt.Errorf("unknown field: %q", f)
+		}
+		p, err := expr.Compile(set[f], expr.Env(exprEnv{}), expr.AsKind(reflect.String))
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression of %s: %w", f, err)
+		}
+		e.set = append(e.set, fieldProgram{field: f, program: p})
+	}
+	return e, nil
+}
+
+// Process filters and changes the item.
+func (e *Expression) Process(_ context.Context, item Item) ([]Item, error) {
+	env := exprEnv{Item: item, Now: time.Now()}
+	if e.filter != nil {
+		ok, err := expr.Run(e.filter, env)
+		if err != nil {
+			return nil, fmt.Errorf("run filter: %w", err)
+		}
+		if !ok.(bool) {
+			return nil, nil
+		}
+	}
+	for _, s := range e.set {
+		v, err := expr.Run(s.program, env)
+		if err != nil {
+			return nil, fmt.Errorf("run expression of %s: %w", s.field, err)
+		}
+		field, _ := itemField(&item, s.field)
+		*field = v.(string)
+	}
+	return []Item{item}, nil
+}
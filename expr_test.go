@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpression_Process(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("filter", func(t *testing.T) {
+		e, err := NewExpression(`item.Title contains "Go" && item.Published > now - duration("24h")`, nil)
+		assert.NoError(t, err)
+
+		fresh := Item{Title: "Go 2.0", Published: time.Now().Add(-time.Hour)}
+		items, err := e.Process(ctx, fresh)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{fresh}, items)
+
+		for _, item := range []Item{
+			{Title: "Rust 2.0", Published: time.Now().Add(-time.Hour)},
+			{Title: "Go 1.0", Published: time.Now().Add(-48 * time.Hour)},
+		} {
+			items, err := e.Process(ctx, item)
+			assert.NoError(t, err)
+			assert.Empty(t, items)
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		e, err := NewExpression(`item.Category != "ads"`, map[string]string{
+			"title":    `upper(item.Category) + ": " + item.Title`,
+			"category": `trim(item.Category)`,
+		})
+		assert.NoError(t, err)
+
+		items, err := e.Process(ctx, Item{Title: "Title", Category: " tech "})
+		assert.NoError(t, err)
+		// Expressions see the source item
+		assert.Equal(t, []Item{{Title: " TECH : Title", Category: "tech"}}, items)
+
+		items, err = e.Process(ctx, Item{Title: "Title", Category: "ads"})
+		assert.NoError(t, err)
+		assert.Empty(t, items)
+	})
+
+	t.Run("runtime error", func(t *testing.T) {
+		e, err := NewExpression(`item.Article.Lead != ""`, nil)
+		assert.NoError(t, err)
+
+		_, err = e.Process(ctx, Item{})
+		assert.ErrorContains(t, err, "run filter: ")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for msg, args := range map[string]struct {
+			filter string
+			set    map[string]string
+		}{
+			"empty expression": {},
+			"invalid filter: ": {filter: "item.Title"},
+			"invalid filter: type main.Item has no field Name": {filter: "item.Name == \"\""},
+			"unknown field: \"body\"":                          {set: map[string]string{"body": "item.Title"}},
+			"invalid expression of title: ":                    {set: map[string]string{"title": "item.Published"}},
+		} {
+			_, err := NewExpression(args.filter, args.set)
+			assert.ErrorContains(t, err, msg)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Article extraction settings.
+const (
+	// maxArticleSize limits the size of downloaded pages.
+	maxArticleSize = 2 << 20
+	// maxArticleCache is a number of cached articles. The cache is cleared
+	// when it's full.
+	maxArticleCache = 1000
+	// minLeadLength is a min length of a paragraph, that can be the lead.
+	minLeadLength = 80
+	// wordsPerMinute is an average reading speed.
+	wordsPerMinute = 200
+)
+
+// Article is the main content of the item's page.
+type Article struct {
+	// Lead is the first paragraph of the article.
+	Lead string `json:"lead,omitempty" yaml:"lead,omitempty"`
+	// Image is the main image of the article.
+	Image string `json:"image,omitempty" yaml:"image,omitempty"`
+	// ReadingTime is an estimated reading time in minutes.
+	ReadingTime int `json:"reading_time,omitempty" yaml:"reading_time,omitempty"`
+}
+
+// Extractor is a processor, that downloads item pages and extracts their
+// main content. Articles are cached by link, so repeated fetches of
+// the same items don't download pages again. Failures are cached too,
+// so broken pages are not requested on every fetch.
+//
+// Pages of items of restricted feeds are downloaded only from public
+// addresses, and they have their own cache entries.
+type Extractor struct {
+	feedClients
+	cache map[string]extraction
+	mx    *sync.Mutex
+}
+
+// extraction is a cached result of article extraction.
+type extraction struct {
+	article Article
+	err     error
+}
+
+// NewExtractor creates a new extractor.
+func NewExtractor() *Extractor {
+	return &Extractor{
+		feedClients: newFeedClients(),
+		cache:       map[string]extraction{},
+		mx:          &sync.Mutex{},
+	}
+}
+
+// Process adds the article to the item. The article image is added to
+// the item's images, if it has none.
+func (e *Extractor) Process(ctx context.Context, item Item) ([]Item, error) {
+	if item.Link == "" {
+		return []Item{item}, nil
+	}
+	article, err := e.Extract(ctx, item.Feed, item.Link)
+	if err != nil {
+		return nil, err
+	}
+	item.Article = &article
+	if len(item.Images) == 0 && article.Image != "" {
+		item.Images = []string{article.Image}
+	}
+	return []Item{item}, nil
+}
+
+// Extract gets the article from the page of the feed's item, or from
+// the cache.
+func (e *Extractor) Extract(ctx context.Context, feed, link string) (Article, error) {
+	// Pages, that were downloaded for other feeds, may be private
+	key := link
+	if e.isRestricted(feed) {
+		key = "public " + link
+	}
+	e.mx.Lock()
+	res, ok := e.cache[key]
+	e.mx.Unlock()
+	if ok {
+		return res.article, res.err
+	}
+
+	article, err := e.download(ctx, e.clientOf(feed), link)
+	// Cancelled requests are not failures of the page
+	if err != nil && ctx.Err() != nil {
+		return Article{}, err
+	}
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	if len(e.cache) >= maxArticleCache {
+		clear(e.cache)
+	}
+	e.cache[key] = extraction{article: article, err: err}
+	return article, err
+}
+
+func (e *Extractor) download(ctx context.Context, client *http.Client, link string) (Article, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return Article{}, fmt.Errorf("init request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Article{}, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Article{}, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	ct := resp.Header.Get("Content-Type")
+	if ct != "" && !strings.Contains(ct, "html") {
+		return Article{}, fmt.Errorf("unexpected content type: %s", ct)
+	}
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(resp.Body, maxArticleSize))
+	if err != nil {
+		return Article{}, fmt.Errorf("parse page: %w", err)
+	}
+	return extractArticle(doc, resp.Request.URL), nil
+}
+
+// extractArticle finds the main content of the page. The content is
+// the element with the most text in paragraphs, like in readability
+// algorithms. Page metadata is used when there is no content.
+func extractArticle(doc *goquery.Document, base *url.URL) Article {
+	doc.Find("script, style, noscript, nav, header, footer, aside, form").Remove()
+
+	var article Article
+	content := mainContent(doc)
+	if content != nil {
+		var words int
+		content.Find("p").Each(func(_ int, p *goquery.Selection) {
+			text := strings.Join(strings.Fields(p.Text()), " ")
+			words += len(strings.Fields(text))
+			if article.Lead == "" && len(text) >= minLeadLength {
+				article.Lead = text
+			}
+		})
+		if words > 0 {
+			article.ReadingTime = int(math.Ceil(float64(words) / wordsPerMinute))
+		}
+	}
+	if article.Lead == "" {
+		article.Lead = meta(doc, "og:description", "description")
+	}
+
+	image := meta(doc, "og:image", "twitter:image")
+	if image == "" && content != nil {
+		image, _ = content.Find("img[src]").First().Attr("src")
+	}
+	if image != "" {
+		if u, err := base.Parse(image); err == nil {
+			article.Image = u.String()
+		}
+	}
+	return article
+}
+
+// mainContent returns the element, whose paragraphs have the most text.
+func mainContent(doc *goquery.Document) *goquery.Selection {
+	scores := map[*html.Node]int{}
+	var best *html.Node
+	doc.Find("p").Each(func(_ int, p *goquery.Selection) {
+		parent := p.Parent()
+		if parent.Length() == 0 {
+			return
+		}
+		node := parent.Get(0)
+		scores[node] += len(strings.TrimSpace(p.Text()))
+		if scores[node] > scores[best] {
+			best = node
+		}
+	})
+	if best == nil {
+		return nil
+	}
+	return doc.FindNodes(best)
+}
+
+// meta returns content of the first found meta tag with the given
+// property or name.
+func meta(doc *goquery.Document, names ...string) string {
+	for _, name := range names {
+		sel := doc.Find(fmt.Sprintf(`meta[property=%q], meta[name=%q]`, name, name))
+		if content, ok := sel.First().Attr("content"); ok && content != "" {
+			return strings.TrimSpace(content)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractor_Process(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("article", func(t *testing.T) {
+		page := &testArticleServer{body: testArticle}
+		server := httptest.NewServer(page)
+		defer server.Close()
+
+		e := NewExtractor()
+		items, err := e.Process(ctx, Item{Link: server.URL + "/article"})
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{{
+			Link:   server.URL + "/article",
+			Images: []string{server.URL + "/hero.jpg"},
+			Article: &Article{
+				Lead: "The first real paragraph of the article, that is long enough " +
+					"to be the lead of the article.",
+				Image:       server.URL + "/hero.jpg",
+				ReadingTime: 2,
+			},
+		}}, items)
+
+		// Articles are cached
+		_, err = e.Process(ctx, Item{Link: server.URL + "/article"})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), page.requests.Load())
+	})
+
+	t.Run("keep images", func(t *testing.T) {
+		server := httptest.NewServer(&testArticleServer{body: testArticle})
+		defer server.Close()
+
+		items, err := NewExtractor().Process(ctx, Item{
+			Link:   server.URL,
+			Images: []string{"https://example.com/image.jpg"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"https://example.com/image.jpg"}, items[0].Images)
+		assert.Equal(t, server.URL+"/hero.jpg", items[0].Article.Image)
+	})
+
+	t.Run("metadata only", func(t *testing.T) {
+		server := httptest.NewServer(&testArticleServer{body: `<html><head>` +
+			`<meta name="description" content="Short summary">` +
+			`</head><body><img src="https://example.com/image.jpg"></body></html>`})
+		defer server.Close()
+
+		items, err := NewExtractor().Process(ctx, Item{Link: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, &Article{Lead: "Short summary"}, items[0].Article)
+	})
+
+	t.Run("no link", func(t *testing.T) {
+		items, err := NewExtractor().Process(ctx, Item{Title: "Title"})
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{{Title: "Title"}}, items)
+	})
+
+	t.Run("not html", func(t *testing.T) {
+		server := httptest.NewServer(&testArticleServer{body: `{}`, contentType: "application/json"})
+		defer server.Close()
+
+		_, err := NewExtractor().Process(ctx, Item{Link: server.URL})
+		assert.EqualError(t, err, "unexpected content type: application/json")
+	})
+
+	t.Run("restricted feed", func(t *testing.T) {
+		page := &testArticleServer{body: testArticle}
+		server := httptest.NewServer(page)
+		defer server.Close()
+
+		e := NewExtractor()
+		e.Restrict(func(feed string) bool { return feed == "users" })
+
+		// Cached pages of other feeds are not shared either
+		_, err := e.Process(ctx, Item{Feed: "f1", Link: server.URL})
+		assert.NoError(t, err)
+		_, err = e.Process(ctx, Item{Feed: "users", Link: server.URL})
+		assert.ErrorIs(t, err, ErrPrivateAddress)
+		assert.Equal(t, int32(1), page.requests.Load())
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			http.NotFound(w, r)
+		}))
+		defer server.Close()
+
+		e := NewExtractor()
+		_, err := e.Process(ctx, Item{Link: server.URL})
+		assert.EqualError(t, err, "unexpected status: 404 Not Found")
+
+		// Failures are cached
+		_, err = e.Process(ctx, Item{Link: server.URL})
+		assert.EqualError(t, err, "unexpected status: 404 Not Found")
+		assert.Equal(t, int32(1), requests.Load())
+	})
+}
+
+var testArticle = `<html><head>` +
+	`<meta property="og:image" content="/hero.jpg">` +
+	`<script>var p = "<p>script</p>";</script>` +
+	`</head><body>` +
+	`<nav><p>Home | News | About | Contacts | Subscribe to our newsletter, ` +
+	`it is very long and has many words</p></nav>` +
+	`<div class="article">` +
+	`<p>Short intro.</p>` +
+	`<p>The first real paragraph of the article, that is long enough to be the lead ` +
+	`of the article.</p>` +
+	`<p>` + strings.Repeat("word ", 250) + `</p>` +
+	`</div>` +
+	`<div class="comments"><p>Nice!</p></div>` +
+	`</body></html>`
+
+type testArticleServer struct {
+	body        string
+	contentType string
+	requests    atomic.Int32
+}
+
+func (s *testArticleServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.requests.Add(1)
+	ct := s.contentType
+	if ct == "" {
+		ct = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ct)
+	_, _ = w.Write([]byte(s.body))
+}
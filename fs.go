@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -18,15 +19,57 @@ type FileStorage struct {
 
 // state is a representation of application state.
 type state struct {
-	Feeds map[string]time.Time `yaml:"feeds"`
+	Feeds  map[string]time.Time `yaml:"feeds"`
+	Seen   map[string]time.Time `yaml:"seen,omitempty"`
+	Queues map[string][]Item    `yaml:"queues,omitempty"`
+
+	Messages []Message `yaml:"messages,omitempty"`
+
+	// Subscriptions are feeds of users in multi-tenant mode.
+	Subscriptions map[string][]string `yaml:"subscriptions,omitempty"`
+
+	// Audit is a log of state changes made by users, oldest first.
+	Audit []AuditEntry `yaml:"audit,omitempty"`
+
+	// FeedChanges are changes of the feed list made at runtime.
+	FeedChanges FeedChanges `yaml:"feed_changes,omitempty"`
+
+	// Deliveries are recently delivered items, oldest first.
+	Deliveries []Delivery `yaml:"deliveries,omitempty"`
+
+	// Destinations are destinations added at runtime.
+	Destinations []Destination `yaml:"destinations,omitempty"`
+}
+
+const (
+	// maxAuditEntries is a number of the latest audit log entries, that
+	// are kept in the state.
+	maxAuditEntries = 1000
+	// maxDeliveries is a number of the latest delivered items, that are
+	// kept in the state.
+	maxDeliveries = 200
+)
+
+// Delivery is an item delivered to the notifier.
+type Delivery struct {
+	// ID is a sequential number of the delivery.
+	ID   int       `json:"id" yaml:"id"`
+	Sent time.Time `json:"sent" yaml:"sent"`
+	Item Item      `json:"item" yaml:"item"`
 }
 
 // NewFileStorage creates new file storage.
 func NewFileStorage(file string) (*FileStorage, error) {
 	s := &FileStorage{
-		file:  file,
-		state: state{Feeds: map[string]time.Time{}},
-		mx:    &sync.Mutex{},
+		file: file,
+		state: state{
+			Feeds:  map[string]time.Time{},
+			Seen:   map[string]time.Time{},
+			Queues: map[string][]Item{},
+
+			Subscriptions: map[string][]string{},
+		},
+		mx: &sync.Mutex{},
 	}
 
 	// Read or init
@@ -48,6 +91,15 @@ func NewFileStorage(file string) (*FileStorage, error) {
 	if s.state.Feeds == nil {
 		s.state.Feeds = map[string]time.Time{}
 	}
+	if s.state.Seen == nil {
+		s.state.Seen = map[string]time.Time{}
+	}
+	if s.state.Queues == nil {
+		s.state.Queues = map[string][]Item{}
+	}
+	if s.state.Subscriptions == nil {
+		s.state.Subscriptions = map[string][]string{}
+	}
 	return s, nil
 }
 
@@ -68,6 +120,307 @@ func (s *FileStorage) SaveLastUpdate(feed string, t time.Time) error {
 	return s.save()
 }
 
+// LastUpdates returns last update times of all known feeds.
+func (s *FileStorage) LastUpdates() map[string]time.Time {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	feeds := make(map[string]time.Time, len(s.state.Feeds))
+	for f, t := range s.state.Feeds {
+		feeds[f] = t
+	}
+	return feeds
+}
+
+// ResetLastUpdate removes the feed from the state, so it's treated as new
+// on the next fetch.
+func (s *FileStorage) ResetLastUpdate(feed string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	delete(s.state.Feeds, feed)
+	return s.save()
+}
+
+// GetSeen gets the time when the item key was seen last time.
+func (s *FileStorage) GetSeen(key string) time.Time {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.state.Seen[key]
+}
+
+// SaveSeen saves item keys as seen at the given time, and removes keys that
+// were seen before the expiration time.
+func (s *FileStorage) SaveSeen(keys []string, t, expire time.Time) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for k, seen := range s.state.Seen {
+		if seen.Before(expire) {
+			delete(s.state.Seen, k)
+		}
+	}
+	for _, k := range keys {
+		s.state.Seen[k] = t
+	}
+	return s.save()
+}
+
+// PushQueueItem adds the item to the end of the queue.
+func (s *FileStorage) PushQueueItem(queue string, item Item) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.state.Queues[queue] = append(s.state.Queues[queue], item)
+	return s.save()
+}
+
+// GetQueueItems gets all items of the queue.
+func (s *FileStorage) GetQueueItems(queue string) []Item {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	items := make([]Item, len(s.state.Queues[queue]))
+	copy(items, s.state.Queues[queue])
+	return items
+}
+
+// RemoveQueueItems removes first n items of the queue.
+func (s *FileStorage) RemoveQueueItems(queue string, n int) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	items := s.state.Queues[queue]
+	n = min(n, len(items))
+	if n == len(items) {
+		delete(s.state.Queues, queue)
+	} else {
+		s.state.Queues[queue] = items[n:]
+	}
+	return s.save()
+}
+
+// AddMessage saves the sent message.
+func (s *FileStorage) AddMessage(m Message) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.state.Messages = append(s.state.Messages, m)
+	return s.save()
+}
+
+// GetMessages gets all sent messages of the feed.
+func (s *FileStorage) GetMessages(feed string) []Message {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	var msgs []Message
+	for _, m := range s.state.Messages {
+		if m.Feed == feed {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// UpdateMessage replaces the sent message, that has the same chat and ID.
+func (s *FileStorage) UpdateMessage(m Message) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	i := s.findMessage(m)
+	if i < 0 {
+		return nil
+	}
+	s.state.Messages[i] = m
+	return s.save()
+}
+
+// RemoveMessage removes the sent message, that has the same chat and ID.
+func (s *FileStorage) RemoveMessage(m Message) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	i := s.findMessage(m)
+	if i < 0 {
+		return nil
+	}
+	s.state.Messages = slices.Delete(s.state.Messages, i, i+1)
+	return s.save()
+}
+
+// findMessage returns index of the message with the same chat and ID, or -1.
+func (s *FileStorage) findMessage(m Message) int {
+	return slices.IndexFunc(s.state.Messages, func(x Message) bool {
+		return x.Chat == m.Chat && slices.Equal(x.IDs, m.IDs)
+	})
+}
+
+// AddSubscription subscribes the user to the feed.
+func (s *FileStorage) AddSubscription(user, feed string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if slices.Contains(s.state.Subscriptions[user], feed) {
+		return nil
+	}
+	s.state.Subscriptions[user] = append(s.state.Subscriptions[user], feed)
+	return s.save()
+}
+
+// RemoveSubscription unsubscribes the user from the feed.
+func (s *FileStorage) RemoveSubscription(user, feed string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	feeds := slices.DeleteFunc(s.state.Subscriptions[user], func(f string) bool {
+		return f == feed
+	})
+	if len(feeds) == 0 {
+		delete(s.state.Subscriptions, user)
+	} else {
+		s.state.Subscriptions[user] = feeds
+	}
+	return s.save()
+}
+
+// GetSubscriptions gets feeds of the user in order of subscription.
+func (s *FileStorage) GetSubscriptions(user string) []string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return slices.Clone(s.state.Subscriptions[user])
+}
+
+// GetSubscribers gets sorted list of users subscribed to the feed.
+func (s *FileStorage) GetSubscribers(feed string) []string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	var users []string
+	for u, feeds := range s.state.Subscriptions {
+		if slices.Contains(feeds, feed) {
+			users = append(users, u)
+		}
+	}
+	slices.Sort(users)
+	return users
+}
+
+// SubscribedFeeds gets sorted list of feeds, that have subscribers.
+func (s *FileStorage) SubscribedFeeds() []string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	var feeds []string
+	for _, ff := range s.state.Subscriptions {
+		feeds = append(feeds, ff...)
+	}
+	slices.Sort(feeds)
+	return slices.Compact(feeds)
+}
+
+// AddAuditEntry appends the entry to the audit log. The oldest entries
+// are removed, when the log is full.
+func (s *FileStorage) AddAuditEntry(e AuditEntry) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.state.Audit = append(s.state.Audit, e)
+	if n := len(s.state.Audit) - maxAuditEntries; n > 0 {
+		s.state.Audit = slices.Delete(s.state.Audit, 0, n)
+	}
+	return s.save()
+}
+
+// GetAuditLog gets all entries of the audit log, oldest first.
+func (s *FileStorage) GetAuditLog() []AuditEntry {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return slices.Clone(s.state.Audit)
+}
+
+// GetFeedChanges gets changes of the feed list.
+func (s *FileStorage) GetFeedChanges() FeedChanges {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return FeedChanges{
+		Added:   slices.Clone(s.state.FeedChanges.Added),
+		Removed: slices.Clone(s.state.FeedChanges.Removed),
+		Paused:  slices.Clone(s.state.FeedChanges.Paused),
+	}
+}
+
+// SaveFeedChanges replaces changes of the feed list.
+func (s *FileStorage) SaveFeedChanges(c FeedChanges) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.state.FeedChanges = c
+	return s.save()
+}
+
+// AddDelivery saves the item as delivered at the given time. The oldest
+// deliveries are removed, when the history is full.
+func (s *FileStorage) AddDelivery(item Item, t time.Time) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	id := 1
+	if n := len(s.state.Deliveries); n > 0 {
+		id = s.state.Deliveries[n-1].ID + 1
+	}
+	s.state.Deliveries = append(s.state.Deliveries, Delivery{ID: id, Sent: t, Item: item})
+	if n := len(s.state.Deliveries) - maxDeliveries; n > 0 {
+		s.state.Deliveries = slices.Delete(s.state.Deliveries, 0, n)
+	}
+	return s.save()
+}
+
+// GetDeliveries gets recently delivered items, newest first.
+func (s *FileStorage) GetDeliveries() []Delivery {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	d := slices.Clone(s.state.Deliveries)
+	slices.Reverse(d)
+	return d
+}
+
+// GetDelivery gets the delivery by its ID.
+func (s *FileStorage) GetDelivery(id int) (Delivery, bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	i := slices.IndexFunc(s.state.Deliveries, func(d Delivery) bool {
+		return d.ID == id
+	})
+	if i < 0 {
+		return Delivery{}, false
+	}
+	return s.state.Deliveries[i], true
+}
+
+// GetDestinations gets destinations added at runtime.
+func (s *FileStorage) GetDestinations() []Destination {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return slices.Clone(s.state.Destinations)
+}
+
+// SaveDestinations replaces destinations added at runtime.
+func (s *FileStorage) SaveDestinations(d []Destination) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.state.Destinations = d
+	return s.save()
+}
+
 // save rewrites whole current state in file.
 func (s *FileStorage) save() error {
 	b, err := yaml.Marshal(s.state)
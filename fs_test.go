@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -124,8 +125,293 @@ func TestFileStorage_SaveLastUpdate(t *testing.T) {
 			"  feed: 2000-01-01T00:00:01Z\n")
 }
 
+func TestFileStorage_LastUpdates(t *testing.T) {
+	ts := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs := &FileStorage{
+		state: state{Feeds: map[string]time.Time{"feed": ts}},
+		mx:    &sync.Mutex{},
+	}
+
+	updates := fs.LastUpdates()
+	assert.Equal(t, map[string]time.Time{"feed": ts}, updates)
+
+	// Returned map is a copy
+	delete(updates, "feed")
+	assert.Equal(t, ts, fs.GetLastUpdate("feed"))
+}
+
+func TestFileStorage_ResetLastUpdate(t *testing.T) {
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().Nanosecond()),
+	)
+	defer os.Remove(f)
+
+	fs, err := NewFileStorage(f)
+	assert.NoError(t, err)
+
+	ts := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, fs.SaveLastUpdate("feed1", ts))
+	assert.NoError(t, fs.SaveLastUpdate("feed2", ts))
+
+	assert.NoError(t, fs.ResetLastUpdate("feed1"))
+	assert.True(t, fs.GetLastUpdate("feed1").IsZero())
+	assertFile(t, fs.file,
+		"feeds:\n"+
+			"  feed2: 2000-01-01T00:00:00Z\n")
+}
+
+func TestFileStorage_SaveSeen(t *testing.T) {
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().Nanosecond()),
+	)
+	defer os.Remove(f)
+
+	fs, err := NewFileStorage(f)
+	assert.NoError(t, err)
+
+	ts1 := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts2 := ts1.Add(time.Hour)
+
+	assert.NoError(t, fs.SaveSeen([]string{"url:a", "hash:a"}, ts1, ts1.Add(-time.Hour)))
+	assert.Equal(t, ts1, fs.GetSeen("url:a"))
+	assert.True(t, fs.GetSeen("url:b").IsZero())
+
+	// Old keys are removed
+	assert.NoError(t, fs.SaveSeen([]string{"url:b"}, ts2, ts2.Add(-time.Minute)))
+	assert.True(t, fs.GetSeen("url:a").IsZero())
+	assertFile(t, fs.file,
+		"feeds: {}\n"+
+			"seen:\n"+
+			"  url:b: 2000-01-01T01:00:00Z\n")
+}
+
+func TestFileStorage_QueueItems(t *testing.T) {
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().Nanosecond()),
+	)
+	defer os.Remove(f)
+
+	fs, err := NewFileStorage(f)
+	assert.NoError(t, err)
+
+	ts := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, fs.PushQueueItem("chat", Item{Published: ts, Link: "one"}))
+	assert.NoError(t, fs.PushQueueItem("chat", Item{Published: ts, Link: "two"}))
+	assertFile(t, fs.file,
+		"feeds: {}\n"+
+			"queues:\n"+
+			"  chat:\n"+
+			"  - published: 2000-01-01T00:00:00Z\n"+
+			"    link: one\n"+
+			"  - published: 2000-01-01T00:00:00Z\n"+
+			"    link: two\n")
+
+	items := fs.GetQueueItems("chat")
+	assert.Equal(t, []Item{{Published: ts, Link: "one"}, {Published: ts, Link: "two"}}, items)
+
+	assert.NoError(t, fs.RemoveQueueItems("chat", 1))
+	assert.Equal(t, []Item{{Published: ts, Link: "two"}}, fs.GetQueueItems("chat"))
+
+	assert.NoError(t, fs.RemoveQueueItems("chat", 5))
+	assert.Empty(t, fs.GetQueueItems("chat"))
+	assertFile(t, fs.file, "feeds: {}\n")
+}
+
+func TestFileStorage_Messages(t *testing.T) {
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().Nanosecond()),
+	)
+	defer os.Remove(f)
+
+	fs, err := NewFileStorage(f)
+	assert.NoError(t, err)
+
+	ts := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	one := Message{Feed: "f1", GUID: "one", Chat: "@chat", IDs: []int{1}, Hash: "a", Published: ts, Sent: ts}
+	two := Message{Feed: "f1", GUID: "two", Chat: "@chat", IDs: []int{2, 3}, Hash: "b", Published: ts, Sent: ts}
+	other := Message{Feed: "f2", GUID: "one", Chat: "@chat", IDs: []int{4}, Hash: "c", Published: ts, Sent: ts}
+	assert.NoError(t, fs.AddMessage(one))
+	assert.NoError(t, fs.AddMessage(two))
+	assert.NoError(t, fs.AddMessage(other))
+	assert.Equal(t, []Message{one, two}, fs.GetMessages("f1"))
+
+	one.Hash = "d"
+	assert.NoError(t, fs.UpdateMessage(one))
+	assert.NoError(t, fs.RemoveMessage(two))
+	assert.Equal(t, []Message{one}, fs.GetMessages("f1"))
+
+	assert.NoError(t, fs.RemoveMessage(one))
+	assertFile(t, fs.file,
+		"feeds: {}\n"+
+			"messages:\n"+
+			"- feed: f2\n"+
+			"  guid: one\n"+
+			"  chat: '@chat'\n"+
+			"  ids:\n"+
+			"  - 4\n"+
+			"  hash: c\n"+
+			"  published: 2000-01-01T00:00:00Z\n"+
+			"  sent: 2000-01-01T00:00:00Z\n")
+
+	// Unknown messages are ignored
+	assert.NoError(t, fs.UpdateMessage(two))
+	assert.NoError(t, fs.RemoveMessage(two))
+	assert.Empty(t, fs.GetMessages("f1"))
+}
+
 func assertFile(t *testing.T, file, content string) {
 	b, err := os.ReadFile(file)
 	assert.NoError(t, err)
 	assert.Equal(t, content, string(b))
 }
+
+func TestFileStorage_Subscriptions(t *testing.T) {
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().Nanosecond()),
+	)
+	defer os.Remove(f)
+
+	fs, err := NewFileStorage(f)
+	assert.NoError(t, err)
+
+	assert.NoError(t, fs.AddSubscription("2", "f2"))
+	assert.NoError(t, fs.AddSubscription("2", "f1"))
+	assert.NoError(t, fs.AddSubscription("1", "f1"))
+	// Duplicates are ignored
+	assert.NoError(t, fs.AddSubscription("1", "f1"))
+
+	assert.Equal(t, []string{"f2", "f1"}, fs.GetSubscriptions("2"))
+	assert.Equal(t, []string{"f1"}, fs.GetSubscriptions("1"))
+	assert.Empty(t, fs.GetSubscriptions("3"))
+	assert.Equal(t, []string{"1", "2"}, fs.GetSubscribers("f1"))
+	assert.Equal(t, []string{"2"}, fs.GetSubscribers("f2"))
+	assert.Equal(t, []string{"f1", "f2"}, fs.SubscribedFeeds())
+
+	assert.NoError(t, fs.RemoveSubscription("2", "f1"))
+	assert.NoError(t, fs.RemoveSubscription("1", "f1"))
+	assert.NoError(t, fs.RemoveSubscription("3", "f1"))
+	assert.Equal(t, []string{"f2"}, fs.SubscribedFeeds())
+	assertFile(t, fs.file,
+		"feeds: {}\n"+
+			"subscriptions:\n"+
+			"  \"2\":\n"+
+			"  - f2\n")
+}
+
+func TestFileStorage_Audit(t *testing.T) {
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().Nanosecond()),
+	)
+	defer os.Remove(f)
+
+	fs, err := NewFileStorage(f)
+	assert.NoError(t, err)
+
+	ts := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range maxAuditEntries {
+		e := AuditEntry{Time: ts, User: strconv.Itoa(i), Role: "user", Chat: int64(i), Action: "add"}
+		fs.state.Audit = append(fs.state.Audit, e)
+	}
+	for i := range 2 {
+		e := AuditEntry{Time: ts, User: strconv.Itoa(maxAuditEntries + i), Role: "user", Action: "add"}
+		assert.NoError(t, fs.AddAuditEntry(e))
+	}
+
+	// The oldest entries are removed
+	log := fs.GetAuditLog()
+	assert.Len(t, log, maxAuditEntries)
+	assert.Equal(t, "2", log[0].User)
+	assert.Equal(t, strconv.Itoa(maxAuditEntries+1), log[len(log)-1].User)
+}
+
+func TestFileStorage_FeedChanges(t *testing.T) {
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().Nanosecond()),
+	)
+	defer os.Remove(f)
+
+	fs, err := NewFileStorage(f)
+	assert.NoError(t, err)
+	assert.Equal(t, FeedChanges{}, fs.GetFeedChanges())
+
+	c := FeedChanges{
+		Added:   []Feed{{URL: "f3"}},
+		Removed: []string{"f1"},
+		Paused:  []string{"f2"},
+	}
+	assert.NoError(t, fs.SaveFeedChanges(c))
+	assert.Equal(t, c, fs.GetFeedChanges())
+
+	// Changes of the returned value don't affect the state
+	fs.GetFeedChanges().Paused[0] = "f3"
+	assert.Equal(t, c, fs.GetFeedChanges())
+
+	assertFile(t, fs.file,
+		"feeds: {}\n"+
+			"feed_changes:\n"+
+			"  added:\n"+
+			"  - f3\n"+
+			"  removed:\n"+
+			"  - f1\n"+
+			"  paused:\n"+
+			"  - f2\n")
+}
+
+func TestFileStorage_Deliveries(t *testing.T) {
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().Nanosecond()),
+	)
+	defer os.Remove(f)
+
+	fs, err := NewFileStorage(f)
+	assert.NoError(t, err)
+
+	ts := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range maxDeliveries {
+		d := Delivery{ID: i + 1, Sent: ts, Item: Item{Link: strconv.Itoa(i + 1)}}
+		fs.state.Deliveries = append(fs.state.Deliveries, d)
+	}
+	assert.NoError(t, fs.AddDelivery(Item{Link: "new"}, ts))
+
+	// The oldest delivery is removed, the newest goes first
+	list := fs.GetDeliveries()
+	assert.Len(t, list, maxDeliveries)
+	assert.Equal(t, Delivery{ID: maxDeliveries + 1, Sent: ts, Item: Item{Link: "new"}}, list[0])
+	assert.Equal(t, 2, list[len(list)-1].ID)
+
+	d, ok := fs.GetDelivery(maxDeliveries + 1)
+	assert.True(t, ok)
+	assert.Equal(t, "new", d.Item.Link)
+	_, ok = fs.GetDelivery(1)
+	assert.False(t, ok)
+}
+
+func TestFileStorage_Destinations(t *testing.T) {
+	f := filepath.Join(
+		os.TempDir(),
+		fmt.Sprintf("feed-bot-testing-%d", time.Now().Nanosecond()),
+	)
+	defer os.Remove(f)
+
+	fs, err := NewFileStorage(f)
+	assert.NoError(t, err)
+	assert.Empty(t, fs.GetDestinations())
+
+	d := []Destination{{Chat: "chat", Feeds: []string{"f1"}}}
+	assert.NoError(t, fs.SaveDestinations(d))
+	assert.Equal(t, d, fs.GetDestinations())
+	assertFile(t, fs.file,
+		"feeds: {}\n"+
+			"destinations:\n"+
+			"- chat: chat\n"+
+			"  feeds:\n"+
+			"  - f1\n")
+}
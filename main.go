@@ -3,20 +3,48 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	_ "time/tzdata" // time zones for quiet hours and schedules
 
 	"github.com/sirupsen/logrus"
 )
 
+const usage = `Usage: feed-bot [-config file] [command] [args]
+
+Commands:
+  run                      start the bot (default)
+  check                    validate config and probe every feed
+  fetch <url>              print parsed items of the feed
+  state show               print last update time of every feed
+  state reset [feed]       reset state of one or all feeds
+  state set <feed> <time>  set last update time (RFC 3339) of the feed
+  state audit              print changes made by users with bot commands
+  feed pause <url>         stop fetching of the feed by the running bot
+  feed resume <url>        start fetching of the paused feed
+  feed fetch <url>         make the running bot fetch the feed now
+  send-test [link]         send a test message through the notifier
+  opml import <file>       merge feeds from OPML file into the config
+  opml export [file]       write feeds of the bot as OPML
+
+Flags:
+`
+
 func main() {
 	os.Exit(run())
 }
 
 func run() int {
 	configFile := flag.String("config", "./config.yaml", "path to config file")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	ctx, cancel := signal.NotifyContext(
@@ -28,38 +56,369 @@ func run() int {
 
 	log := logrus.New()
 
-	conf, err := ReadConfig(*configFile)
+	cmd, args := "run", []string(nil)
+	if flag.NArg() > 0 {
+		cmd, args = flag.Arg(0), flag.Args()[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "run":
+		err = runBot(ctx, *configFile, log)
+	case "check":
+		err = runCheck(*configFile, os.Stdout)
+	case "fetch":
+		err = runFetch(args, os.Stdout)
+	case "state":
+		err = runState(*configFile, args, os.Stdout)
+	case "feed":
+		err = runFeed(*configFile, args)
+	case "send-test":
+		err = runSendTest(ctx, *configFile, args, log)
+	case "opml":
+		err = runOPML(*configFile, args, os.Stdout)
+	default:
+		flag.Usage()
+		return 2
+	}
 	if err != nil {
-		log.Errorf("Read config: %v", err)
+		log.Errorf("Command %s: %v", cmd, err)
 		return 1
 	}
+	return 0
+}
+
+// runBot starts the bot and blocks until the context is cancelled.
+func runBot(ctx context.Context, configFile string, log *logrus.Logger) error {
+	conf, err := ReadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
 
 	level := logrus.InfoLevel
 	if conf.Debug {
 		level = logrus.DebugLevel
 	}
 	log.SetLevel(level)
+	log.Infof("Config:\n%s", conf)
 
 	fs, err := NewFileStorage(conf.DataFile)
 	if err != nil {
-		log.Errorf("Init state storage: %v", err)
-		return 1
+		return fmt.Errorf("init state storage: %w", err)
 	}
 
 	fetcher := NewRSSFetcher(fs)
+	if conf.StartupCheck != "" {
+		if err := checkFeeds(fetcher, conf, log); err != nil {
+			return err
+		}
+	}
 
-	tg, err := NewTelegramNotifier(conf.TelegramToken, conf.TelegramChat, log)
+	registry := NewFeedRegistry(conf, fs)
+	notifier, err := newNotifier(conf, fs, registry.Has, log)
 	if err != nil {
-		log.Errorf("Init telegram notifier: %v", err)
-		return 1
+		return err
+	}
+
+	opts := []BotOption{
+		WithDeliveryWindow(conf.DeliveryWindow),
+		WithFeedSource(registry),
+		WithStatus(registry),
+		WithHistory(fs),
+	}
+	if conf.DedupWindow > 0 {
+		// Users have different feeds, so items of their feeds are not
+		// hidden by items of others
+		dedup := NewDeduplicator(fs, conf.DedupWindow, conf.Users != nil)
+		opts = append(opts, WithDeduplicator(dedup))
+	}
+	// Feeds of users and their items can't point to the local network
+	var restricted func(feed string) bool
+	if conf.Users != nil && !conf.Debug {
+		restricted = func(feed string) bool { return !registry.Has(feed) }
+		fetcher.Restrict(restricted)
+	}
+	pipelines, err := newPipelines(conf, restricted, log)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, WithPipelines(pipelines))
+
+	// Destinations, that are added at runtime, have plain notifiers
+	var api API
+	if !conf.Debug {
+		tgAPI, err := NewTelegramAPI(conf)
+		if err != nil {
+			return fmt.Errorf("init telegram api: %w", err)
+		}
+		api = tgAPI
+	}
+	destinations := NewDestinationRegistry(conf.Destinations, fs, registry.Has,
+		func(d Destination) (Notifier, error) {
+			if conf.Debug {
+				return NewPrintNotifier(log), nil
+			}
+			d.Message = conf.messageDefaults(d.Message, d.Feeds)
+			return NewTelegramNotifier(api, d, nil, log)
+		})
+	if conf.Users != nil {
+		notifier.AddFilter(destinations, registry.Has)
+	} else {
+		notifier.Add(destinations, nil)
+	}
+
+	if conf.Users != nil {
+		if conf.Debug {
+			log.Warn("Multi-tenant mode is disabled in debug mode")
+		} else {
+			users := NewUsers(api, fetcher, fs, registry.Has, conf, log)
+			notifier.Add(users, nil)
+			opts = append(opts, WithFeedSource(users))
+		}
+	}
+	for _, d := range conf.Destinations {
+		if !conf.Debug && (d.Message.Edit || d.Message.Delete) {
+			opts = append(opts, WithUpdates(fs))
+			break
+		}
+	}
+
+	// Feeds are provided by the registry
+	bot := NewBot(notifier, fetcher, nil, conf.UpdateInterval, log, opts...)
+
+	// Paused feeds are kept by the registry
+	control := NewControl(registry, bot, fs, log)
+	controlDone := make(chan struct{})
+	go func() {
+		defer close(controlDone)
+		control.Run(ctx)
+	}()
+	defer func() { <-controlDone }()
+
+	if conf.ControlSocket != "" {
+		ln, err := listenControl(conf.ControlSocket)
+		if err != nil {
+			return fmt.Errorf("init control socket: %w", err)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			log.Infof("Control socket is listening on %s", conf.ControlSocket)
+			if err := control.Serve(ctx, ln); err != nil {
+				log.Errorf("Control socket failed: %v", err)
+			}
+		}()
+		defer func() { <-done }()
 	}
 
-	feeds := make([]string, len(conf.Feeds))
-	copy(feeds, conf.Feeds)
+	if conf.Admin != nil {
+		ln, err := net.Listen("tcp", conf.Admin.Listen)
+		if err != nil {
+			return fmt.Errorf("init admin: %w", err)
+		}
+		rest := NewRESTAPI(registry, destinations, fs, control, notifier, log)
+		admin := NewAdmin(*conf.Admin, registry, control, fs, notifier, log, WithAPI(rest.Handler()))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			log.Infof("Admin UI is listening on %s", ln.Addr())
+			if err := admin.Serve(ctx, ln); err != nil {
+				log.Errorf("Admin UI failed: %v", err)
+			}
+		}()
+		defer func() { <-done }()
+	}
 
 	log.Info("Starting...")
-	NewBot(tg, fetcher, feeds, conf.UpdateInterval, log).Run(ctx)
+	bot.Run(ctx)
 
 	log.Info("Shutdown")
-	return 0
+	return nil
+}
+
+// listenControl listens on the control socket. The socket is left after
+// unclean shutdowns, so it's removed first. Only the owner can connect
+// to it.
+func listenControl(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove old socket: %w", err)
+	}
+	// The socket is created with restricted permissions, so there is no
+	// moment, when others can connect to it. Umask is set for the whole
+	// process, but nothing else creates files at startup
+	mask := syscall.Umask(0o177)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	return ln, nil
+}
+
+// NotifierStorage keeps queued items and sent messages of notifiers.
+type NotifierStorage interface {
+	QueueStorage
+	MessageStorage
+}
+
+// newNotifier creates a notifier, that sends items to all destinations.
+// In debug mode items are printed instead of sending them to Telegram.
+// Digests, quiet hours and message updates are enabled only if the storage
+// is set. In multi-tenant mode destinations without a list of feeds only
+// receive items of managed feeds, and not of feeds of users.
+func newNotifier(
+	conf Config,
+	s NotifierStorage,
+	managed func(feed string) bool,
+	log *logrus.Logger,
+) (*Router, error) {
+	router := NewRouter()
+	if conf.Debug && len(conf.Destinations) == 0 {
+		router.Add(NewPrintNotifier(log), nil)
+		return router, nil
+	}
+
+	var api API
+	if !conf.Debug {
+		tgAPI, err := NewTelegramAPI(conf)
+		if err != nil {
+			return nil, fmt.Errorf("init telegram notifier: %w", err)
+		}
+		api = tgAPI
+	}
+
+	var urgent []string
+	for _, f := range conf.Feeds {
+		if f.Urgent {
+			urgent = append(urgent, f.URL)
+		}
+	}
+
+	for _, d := range conf.Destinations {
+		var n Notifier = NewPrintNotifier(log)
+		if !conf.Debug {
+			tn, err := NewTelegramNotifier(api, d, s, log)
+			if err != nil {
+				return nil, fmt.Errorf("init telegram notifier of %s: %w", d.Chat, err)
+			}
+			n = tn
+		}
+		if d.QuietHours != nil && s != nil {
+			quiet, err := NewQuietHours(d.Chat, n, s, *d.QuietHours, urgent, log)
+			if err != nil {
+				return nil, fmt.Errorf("init quiet hours of %s: %w", d.Chat, err)
+			}
+			n = quiet
+		}
+		if d.Digest != nil && s != nil {
+			digest, err := NewDigest(d.Chat, n, s, *d.Digest, log)
+			if err != nil {
+				return nil, fmt.Errorf("init digest of %s: %w", d.Chat, err)
+			}
+			n = digest
+		}
+		if len(d.Feeds) == 0 && conf.Users != nil {
+			router.AddFilter(n, managed)
+			continue
+		}
+		router.Add(n, d.Feeds)
+	}
+	return router, nil
+}
+
+// newPipelines creates processing pipelines of all feeds. Processors of
+// the feed go first, then global processors. Feeds without processors
+// don't have pipelines. Feeds, that are added at runtime, e.g. by users,
+// share the default pipeline with global processors. Requests of feeds,
+// that are restricted, go only to public addresses.
+func newPipelines(
+	conf Config,
+	restricted func(feed string) bool,
+	log *logrus.Logger,
+) (map[string]*Pipeline, error) {
+	var translator *ItemTranslator
+	if conf.Translation != nil {
+		lt := NewLibreTranslate(conf.Translation.URL, conf.Translation.APIKey)
+		translator = NewItemTranslator(lt, conf.Translation.Target)
+	}
+	// Extractor is shared to share the cache
+	extractor := NewExtractor()
+	extractor.Restrict(restricted)
+	newProcessor := func(p ProcessorConfig) (Processor, error) {
+		switch p.Type {
+		case ProcessorTranslate:
+			return translator, nil
+		case ProcessorFullText:
+			return extractor, nil
+		case ProcessorRewrite:
+			r, err := NewRewriter(p)
+			if err != nil {
+				return nil, err
+			}
+			r.Restrict(restricted)
+			return r, nil
+		case ProcessorExec:
+			return NewPlugin(p.Command, p.Timeout)
+		case ProcessorExpr:
+			return NewExpression(p.Filter, p.Set)
+		default:
+			return nil, fmt.Errorf("unknown processor type: %q", p.Type)
+		}
+	}
+
+	newPipeline := func(name string, procs []ProcessorConfig) (*Pipeline, error) {
+		p := NewPipeline(name, log)
+		for _, pc := range procs {
+			proc, err := newProcessor(pc)
+			if err != nil {
+				return nil, fmt.Errorf("init processor of %s: %w", name, err)
+			}
+			p.Add(pc.Type, proc)
+		}
+		return p, nil
+	}
+
+	pipelines := map[string]*Pipeline{}
+	for _, f := range conf.Feeds {
+		procs := f.Processors
+		if f.Translate {
+			procs = append([]ProcessorConfig{{Type: ProcessorTranslate}}, procs...)
+		}
+		procs = append(procs, conf.Processors...)
+		if len(procs) == 0 {
+			continue
+		}
+		p, err := newPipeline(f.URL, procs)
+		if err != nil {
+			return nil, err
+		}
+		pipelines[f.URL] = p
+	}
+	// Feeds, that are added at runtime, only have global processors
+	if len(conf.Processors) > 0 {
+		p, err := newPipeline("default", conf.Processors)
+		if err != nil {
+			return nil, err
+		}
+		pipelines[""] = p
+	}
+	return pipelines, nil
+}
+
+// checkFeeds probes all feeds and logs the results. Failed feeds are only
+// reported as warnings, unless the check is configured to fail.
+func checkFeeds(fetcher *RSSFetcher, conf Config, log *logrus.Logger) error {
+	var failed int
+	for _, r := range fetcher.ProbeFeeds(conf.Feeds) {
+		if r.Err == nil {
+			log.Infof("Feed check: %s", r)
+			continue
+		}
+		failed++
+		log.Warnf("Feed check: %s", r)
+	}
+	if failed > 0 && conf.StartupCheck == StartupCheckFail {
+		return fmt.Errorf("feed check: %d of %d feeds failed", failed, len(conf.Feeds))
+	}
+	return nil
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// opml is a minimal representation of an OPML document, that is enough
+// for exchanging subscription lists with feed readers.
+type opml struct {
+	XMLName xml.Name  `xml:"opml"`
+	Version string    `xml:"version,attr"`
+	Title   string    `xml:"head>title"`
+	Body    []outline `xml:"body>outline"`
+}
+
+// outline is either a feed (when XMLURL is set), or a folder.
+type outline struct {
+	Text     string    `xml:"text,attr"`
+	Title    string    `xml:"title,attr,omitempty"`
+	Type     string    `xml:"type,attr,omitempty"`
+	XMLURL   string    `xml:"xmlUrl,attr,omitempty"`
+	Category string    `xml:"category,attr,omitempty"`
+	Outlines []outline `xml:"outline"`
+}
+
+// ReadOPML reads feeds from an OPML document. Folders are converted
+// to feed categories.
+func ReadOPML(r io.Reader) ([]Feed, error) {
+	var doc opml
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode xml: %w", err)
+	}
+
+	var feeds []Feed
+	var walk func(outlines []outline, category string)
+	walk = func(outlines []outline, category string) {
+		for _, o := range outlines {
+			if o.XMLURL == "" {
+				walk(o.Outlines, o.Text)
+				continue
+			}
+			feed := Feed{
+				URL:      o.XMLURL,
+				Title:    o.Title,
+				Category: category,
+			}
+			if feed.Title == "" {
+				feed.Title = o.Text
+			}
+			if c := opmlCategory(o.Category); c != "" {
+				feed.Category = c
+			}
+			feeds = append(feeds, feed)
+		}
+	}
+	walk(doc.Body, "")
+
+	if len(feeds) == 0 {
+		return nil, errors.New("no feeds found")
+	}
+	return feeds, nil
+}
+
+// WriteOPML writes feeds as an OPML document. Feeds with a category are
+// grouped into folders.
+func WriteOPML(w io.Writer, feeds []Feed) error {
+	doc := opml{Version: "2.0", Title: "feed-bot subscriptions"}
+
+	folders := map[string]int{}
+	for _, f := range feeds {
+		o := outline{
+			Text:   f.Title,
+			Title:  f.Title,
+			Type:   "rss",
+			XMLURL: f.URL,
+		}
+		if o.Text == "" {
+			o.Text = f.URL
+		}
+		if f.Category == "" {
+			doc.Body = append(doc.Body, o)
+			continue
+		}
+		i, ok := folders[f.Category]
+		if !ok {
+			doc.Body = append(doc.Body, outline{Text: f.Category})
+			i = len(doc.Body) - 1
+			folders[f.Category] = i
+		}
+		doc.Body[i].Outlines = append(doc.Body[i].Outlines, o)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("encode xml: %w", err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("write footer: %w", err)
+	}
+	return nil
+}
+
+// opmlCategory converts the category attribute, which is a comma-separated
+// list of slash-delimited paths, to a single category name.
+func opmlCategory(s string) string {
+	s, _, _ = strings.Cut(s, ",")
+	s = strings.Trim(s, "/ ")
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOPML(t *testing.T) {
+	t.Run("valid document", func(t *testing.T) {
+		doc := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="Plain" xmlUrl="https://example.com/plain.xml"/>
+    <outline text="Tech">
+      <outline text="Go Blog" title="The Go Blog" xmlUrl="https://go.dev/blog/feed.atom"/>
+      <outline text="Other" category="/News/World" xmlUrl="https://example.com/news.xml"/>
+    </outline>
+  </body>
+</opml>`
+
+		feeds, err := ReadOPML(strings.NewReader(doc))
+		assert.NoError(t, err)
+
+		expected := []Feed{
+			{URL: "https://example.com/plain.xml", Title: "Plain"},
+			{URL: "https://go.dev/blog/feed.atom", Title: "The Go Blog", Category: "Tech"},
+			{URL: "https://example.com/news.xml", Title: "Other", Category: "World"},
+		}
+		assert.Equal(t, expected, feeds)
+	})
+
+	t.Run("no feeds", func(t *testing.T) {
+		doc := `<opml version="2.0"><body><outline text="Empty"/></body></opml>`
+		_, err := ReadOPML(strings.NewReader(doc))
+		assert.EqualError(t, err, "no feeds found")
+	})
+
+	t.Run("invalid document", func(t *testing.T) {
+		_, err := ReadOPML(strings.NewReader("<opml"))
+		assert.ErrorContains(t, err, "decode xml")
+	})
+}
+
+func TestWriteOPML(t *testing.T) {
+	feeds := []Feed{
+		{URL: "https://example.com/plain.xml"},
+		{URL: "https://go.dev/blog/feed.atom", Title: "The Go Blog", Category: "Tech"},
+		{URL: "https://example.com/tech.xml", Title: "Tech News", Category: "Tech"},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteOPML(&buf, feeds))
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head>
+    <title>feed-bot subscriptions</title>
+  </head>
+  <body>
+    <outline text="https://example.com/plain.xml" type="rss" xmlUrl="https://example.com/plain.xml"></outline>
+    <outline text="Tech">
+      <outline text="The Go Blog" title="The Go Blog" type="rss" xmlUrl="https://go.dev/blog/feed.atom"></outline>
+      <outline text="Tech News" title="Tech News" type="rss" xmlUrl="https://example.com/tech.xml"></outline>
+    </outline>
+  </body>
+</opml>
+`
+	assert.Equal(t, expected, buf.String())
+
+	// Round trip
+	read, err := ReadOPML(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/plain.xml", read[0].Title)
+	assert.Equal(t, feeds[1:], read[1:])
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pipelineCacheTTL is a time, during which results of items are kept after
+// they were last used.
+const pipelineCacheTTL = 24 * time.Hour
+
+// Processor is a stage of item processing between fetching and sending.
+// It returns the item, possibly changed, several items to split it, or
+// no items to drop it.
+type Processor interface {
+	Process(ctx context.Context, item Item) ([]Item, error)
+}
+
+// Pipeline is a chain of processors of a single feed. Each item goes
+// through all stages in order.
+//
+// Results of items are cached by the item's feed, GUID and content, so
+// items, that are fetched again for updating sent messages, are processed
+// only when they change. Results of failed items are not cached, so they
+// are processed again.
+type Pipeline struct {
+	feed   string
+	stages []*stage
+	log    *logrus.Logger
+
+	cache   map[string]cachedResult
+	cacheMx sync.Mutex
+}
+
+// cachedResult is a result of processing of a single item.
+type cachedResult struct {
+	hash  string
+	items []Item
+	used  time.Time
+}
+
+// tracked is an item in processing, with index of its source item.
+type tracked struct {
+	source int
+	item   Item
+}
+
+// stage is a named processor.
+type stage struct {
+	name      string
+	processor Processor
+}
+
+// NewPipeline creates an empty pipeline of the feed.
+func NewPipeline(feed string, log *logrus.Logger) *Pipeline {
+	return &Pipeline{feed: feed, log: log, cache: map[string]cachedResult{}}
+}
+
+// Add adds a processing stage to the end of the pipeline.
+func (p *Pipeline) Add(name string, proc Processor) {
+	p.stages = append(p.stages, &stage{name: name, processor: proc})
+}
+
+// Process passes items through all stages. If a stage fails to process
+// an item, the error is logged with the feed and the stage, the item goes
+// to the next stage unchanged, and the error is returned along with
+// the processed items. Service messages are not processed.
+func (p *Pipeline) Process(ctx context.Context, items []Item) ([]Item, error) {
+	results := make([][]Item, len(items))
+	cached := make([]bool, len(items))
+	var pending []tracked
+	for i, item := range items {
+		if item.Text != "" {
+			results[i] = []Item{item}
+			continue
+		}
+		if results[i], cached[i] = p.cached(item); !cached[i] {
+			pending = append(pending, tracked{source: i, item: item})
+		}
+	}
+
+	pending, failed, err := p.run(ctx, pending)
+	for _, t := range pending {
+		results[t.source] = append(results[t.source], t.item)
+	}
+
+	out := make([]Item, 0, len(items))
+	for i, item := range items {
+		if item.Text == "" && !cached[i] && !failed[i] {
+			p.store(item, results[i])
+		}
+		out = append(out, results[i]...)
+	}
+	p.prune()
+	return out, err
+}
+
+// run passes items through all stages. Sources of items, that failed at
+// any stage, are returned as failed.
+func (p *Pipeline) run(ctx context.Context, items []tracked) ([]tracked, map[int]bool, error) {
+	if len(items) == 0 {
+		return nil, nil, nil
+	}
+	failures := map[int]bool{}
+	var errs []error
+	for _, s := range p.stages {
+		out := make([]tracked, 0, len(items))
+		start := time.Now()
+		var failed int
+		for _, t := range items {
+			res, err := s.processor.Process(ctx, t.item)
+			if err != nil {
+				failed++
+				err = fmt.Errorf("%s [%s]: %w", s.name, t.item.Link, err)
+				p.log.Warnf("Failed to process item [%s]: %v", t.item.Feed, err)
+				errs = append(errs, err)
+				failures[t.source] = true
+				res = []Item{t.item}
+			}
+			for _, item := range res {
+				out = append(out, tracked{source: t.source, item: item})
+			}
+		}
+		p.log.Debugf("Stage %s [%s]: %d in, %d out, %d errors, %s",
+			s.name, p.feed, len(items), len(out), failed, time.Since(start))
+		items = out
+	}
+	return items, failures, errors.Join(errs...)
+}
+
+// cached returns the stored result of the item, if the item hasn't
+// changed since it was processed.
+func (p *Pipeline) cached(item Item) ([]Item, bool) {
+	if item.GUID == "" {
+		return nil, false
+	}
+	p.cacheMx.Lock()
+	defer p.cacheMx.Unlock()
+
+	key := item.Feed + " " + item.GUID
+	res, ok := p.cache[key]
+	if !ok || res.hash != contentHash(item) {
+		return nil, false
+	}
+	res.used = time.Now()
+	p.cache[key] = res
+	return res.items, true
+}
+
+// store saves the result of the item.
+func (p *Pipeline) store(item Item, items []Item) {
+	if item.GUID == "" {
+		return
+	}
+	p.cacheMx.Lock()
+	defer p.cacheMx.Unlock()
+
+	p.cache[item.Feed+" "+item.GUID] = cachedResult{
+		hash:  contentHash(item),
+		items: items,
+		used:  time.Now(),
+	}
+}
+
+// prune removes results of items, that haven't been used for a while,
+// e.g. items, that were removed from the feed.
+func (p *Pipeline) prune() {
+	p.cacheMx.Lock()
+	defer p.cacheMx.Unlock()
+
+	for key, res := range p.cache {
+		if time.Since(res.used) > pipelineCacheTTL {
+			delete(p.cache, key)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline_Process(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+
+	split := &testCounter{Processor: &testSplitProcessor{}}
+	test := &testCounter{Processor: &testProcessor{}}
+	p := NewPipeline("f1", log)
+	p.Add("split", split)
+	p.Add("test", test)
+
+	items, err := p.Process(context.Background(), []Item{
+		{Feed: "f1", Link: "one,drop"},
+		{Feed: "f1", Link: "fail"},
+		{Text: "...and 3 more"},
+	})
+	assert.EqualError(t, err, "test [fail]: fail")
+	assert.Equal(t, []Item{
+		{Feed: "f1", Link: "one", Title: "processed"},
+		{Feed: "f1", Link: "fail"},
+		{Text: "...and 3 more"},
+	}, items)
+	assert.Equal(t, 2, split.calls)
+	assert.Equal(t, 3, test.calls)
+	assert.Contains(t, buf.String(), `level=warning msg="Failed to process item [f1]: test [fail]: fail"`)
+}
+
+func TestPipeline_Process_Cache(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	test := &testCounter{Processor: &testProcessor{}}
+	p := NewPipeline("f1", log)
+	p.Add("test", test)
+
+	ctx := context.Background()
+	items := []Item{
+		{Feed: "f1", GUID: "one", Link: "one"},
+		{Feed: "f1", GUID: "two", Link: "drop"},
+		{Feed: "f1", GUID: "three", Link: "fail"},
+	}
+	first, err := p.Process(ctx, items)
+	assert.Error(t, err)
+
+	// Results are reused, failed items are retried
+	items = append(items, Item{Feed: "f1", GUID: "four", Link: "four"})
+	second, err := p.Process(ctx, items)
+	assert.Error(t, err)
+	assert.Equal(t, first, second[:2])
+	assert.Equal(t, "processed", second[2].Title)
+	assert.Equal(t, 5, test.calls)
+
+	// Changed items are processed again
+	items[0].Title = "Changed"
+	_, err = p.Process(ctx, items[:2])
+	assert.NoError(t, err)
+	assert.Equal(t, 6, test.calls)
+
+	// Unused results are removed
+	p.cache["f1 one"] = cachedResult{used: time.Now().Add(-pipelineCacheTTL - time.Minute)}
+	p.prune()
+	assert.Len(t, p.cache, 2)
+}
+
+func TestPipeline_Process_Empty(t *testing.T) {
+	p := NewPipeline("f1", logrus.New())
+
+	items, err := p.Process(context.Background(), []Item{{Link: "one"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Item{{Link: "one"}}, items)
+}
+
+// testCounter counts items, that are passed to the processor.
+type testCounter struct {
+	Processor
+	calls int
+}
+
+func (c *testCounter) Process(ctx context.Context, item Item) ([]Item, error) {
+	c.calls++
+	return c.Processor.Process(ctx, item)
+}
+
+// testSplitProcessor splits items with comma-separated links.
+type testSplitProcessor struct{}
+
+func (p *testSplitProcessor) Process(_ context.Context, item Item) ([]Item, error) {
+	links := strings.Split(item.Link, ",")
+	items := make([]Item, len(links))
+	for i, link := range links {
+		items[i] = item
+		items[i].Link = link
+	}
+	return items, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultPluginTimeout is a time limit of a single plugin run.
+const defaultPluginTimeout = 10 * time.Second
+
+// Plugin is a processor, that runs an external command for each item.
+// The item is written to the command's stdin as JSON. The command writes
+// to stdout either the item's fields to change, e.g. {"meta": {"k": "v"}},
+// or null to drop the item. Empty output leaves the item unchanged.
+//
+// Plugins are run by pipelines, that keep results of items, so the command
+// runs once for each item, and not on every fetch for updates.
+type Plugin struct {
+	command []string
+	timeout time.Duration
+}
+
+// NewPlugin creates a new plugin, that runs the command with its arguments.
+func NewPlugin(command []string, timeout time.Duration) (*Plugin, error) {
+	if len(command) == 0 || command[0] == "" {
+		return nil, errors.New("empty command")
+	}
+	if timeout == 0 {
+		timeout = defaultPluginTimeout
+	}
+	return &Plugin{command: command, timeout: timeout}, nil
+}
+
+// Process runs the command for the item.
+func (p *Plugin) Process(ctx context.Context, item Item) ([]Item, error) {
+	in, err := json.Marshal(item)
+	if err != nil {
+		return nil, fmt.Errorf("encode item: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...) //nolint:gosec
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Don't wait for children of the killed command, that keep its output
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("run %s: %w: %s", p.command[0], err, msg)
+		}
+		return nil, fmt.Errorf("run %s: %w", p.command[0], err)
+	}
+
+	out := bytes.TrimSpace(stdout.Bytes())
+	if len(out) == 0 {
+		return []Item{item}, nil
+	}
+	if bytes.Equal(out, []byte("null")) {
+		return nil, nil
+	}
+	// Output is decoded over a copy of the input, so omitted fields keep
+	// their values, and shared values, like cached articles, are not changed
+	var result Item
+	if err := json.Unmarshal(in, &result); err != nil {
+		return nil, fmt.Errorf("decode item: %w", err)
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("decode output of %s: %w", p.command[0], err)
+	}
+	return []Item{result}, nil
+}
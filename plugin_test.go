@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlugin_Process(t *testing.T) {
+	ctx := context.Background()
+	item := Item{
+		Published: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		Link:      "https://example.com/1",
+		Title:     "Title",
+		Article:   &Article{Lead: "Lead"},
+	}
+	sh := func(script string) []string {
+		return []string{"sh", "-c", script}
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		for _, script := range []string{"cat", "cat > /dev/null"} {
+			p, err := NewPlugin(sh(script), 0)
+			assert.NoError(t, err)
+
+			items, err := p.Process(ctx, item)
+			assert.NoError(t, err)
+			assert.Equal(t, []Item{item}, items, script)
+		}
+	})
+
+	t.Run("modify", func(t *testing.T) {
+		p, err := NewPlugin(sh(`cat > /dev/null; echo '{"title": "New", "article": {"lead": "New"}, "meta": {"a": "1"}}'`), 0)
+		assert.NoError(t, err)
+
+		items, err := p.Process(ctx, item)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{{
+			Published: item.Published,
+			Link:      "https://example.com/1",
+			Title:     "New",
+			Article:   &Article{Lead: "New"},
+			Meta:      map[string]string{"a": "1"},
+		}}, items)
+		// Input item is not changed
+		assert.Equal(t, "Lead", item.Article.Lead)
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		p, err := NewPlugin(sh("cat > /dev/null; echo null"), 0)
+		assert.NoError(t, err)
+
+		items, err := p.Process(ctx, item)
+		assert.NoError(t, err)
+		assert.Empty(t, items)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for script, msg := range map[string]string{
+			"echo oops >&2; exit 1": "run sh: exit status 1: oops",
+			"exit 2":                "run sh: exit status 2",
+			"echo '{'":              "decode output of sh: unexpected end of JSON input",
+		} {
+			p, err := NewPlugin(sh(script), 0)
+			assert.NoError(t, err)
+
+			_, err = p.Process(ctx, item)
+			assert.EqualError(t, err, msg, script)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		p, err := NewPlugin(sh("exec sleep 5"), 10*time.Millisecond)
+		assert.NoError(t, err)
+
+		_, err = p.Process(ctx, item)
+		assert.EqualError(t, err, "run sh: context deadline exceeded")
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		runs := filepath.Join(os.TempDir(), fmt.Sprintf("feed-bot-testing-%d", time.Now().UnixNano()))
+		defer os.Remove(runs)
+
+		p, err := NewPlugin(sh("echo run >> "+runs+"; cat"), 0)
+		assert.NoError(t, err)
+		log := logrus.New()
+		log.Out = io.Discard
+		pl := NewPipeline("f1", log)
+		pl.Add("exec", p)
+
+		items := []Item{{Feed: "f1", GUID: "1", Link: "https://example.com/1"}}
+		for range 3 {
+			_, err := pl.Process(ctx, items)
+			assert.NoError(t, err)
+		}
+		data, err := os.ReadFile(runs)
+		assert.NoError(t, err)
+		assert.Equal(t, "run\n", string(data))
+	})
+
+	t.Run("empty command", func(t *testing.T) {
+		_, err := NewPlugin(nil, 0)
+		assert.EqualError(t, err, "empty command")
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// quietCheckInterval is how often queued items are checked for release.
+const quietCheckInterval = time.Minute
+
+// QuietHours is a notifier, that holds items in the storage during quiet
+// hours, and releases them in order when the quiet period is over. Items
+// from urgent feeds are sent immediately.
+type QuietHours struct {
+	queue    string
+	notifier Notifier
+	storage  QueueStorage
+	period   quietPeriod
+	urgent   map[string]bool
+	interval time.Duration
+	log      *logrus.Logger
+
+	// mx prevents sending new items while queued items are released.
+	mx *sync.Mutex
+}
+
+// quietPeriod is a daily period of time in the given location. Start and
+// end are offsets from midnight. The period can span across midnight.
+type quietPeriod struct {
+	start time.Duration
+	end   time.Duration
+	loc   *time.Location
+}
+
+// ParseQuietHours parses quiet hours config.
+func ParseQuietHours(conf QuietHoursConfig) (quietPeriod, error) {
+	start, err := time.Parse("15:04", conf.From)
+	if err != nil {
+		return quietPeriod{}, fmt.Errorf("invalid start time: %s", conf.From)
+	}
+	end, err := time.Parse("15:04", conf.To)
+	if err != nil {
+		return quietPeriod{}, fmt.Errorf("invalid end time: %s", conf.To)
+	}
+	loc, err := time.LoadLocation(conf.TimeZone)
+	if err != nil {
+		return quietPeriod{}, fmt.Errorf("invalid time zone: %s", conf.TimeZone)
+	}
+	midnight := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)
+	return quietPeriod{
+		start: start.Sub(midnight),
+		end:   end.Sub(midnight),
+		loc:   loc,
+	}, nil
+}
+
+// contains checks if the time is inside the period.
+func (p quietPeriod) contains(t time.Time) bool {
+	t = t.In(p.loc)
+	y, m, d := t.Date()
+	offset := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, p.loc))
+	if p.start <= p.end {
+		return offset >= p.start && offset < p.end
+	}
+	return offset >= p.start || offset < p.end
+}
+
+// NewQuietHours creates new quiet hours notifier. Name identifies the queue
+// in the storage.
+func NewQuietHours(
+	name string,
+	n Notifier,
+	s QueueStorage,
+	conf QuietHoursConfig,
+	urgent []string,
+	log *logrus.Logger,
+) (*QuietHours, error) {
+	period, err := ParseQuietHours(conf)
+	if err != nil {
+		return nil, err
+	}
+	q := &QuietHours{
+		queue:    "quiet:" + name,
+		notifier: n,
+		storage:  s,
+		period:   period,
+		urgent:   make(map[string]bool, len(urgent)),
+		interval: quietCheckInterval,
+		log:      log,
+		mx:       &sync.Mutex{},
+	}
+	for _, f := range urgent {
+		q.urgent[f] = true
+	}
+	return q, nil
+}
+
+// Notify sends the item, or queues it during quiet hours. After quiet
+// hours the item is sent after the queued ones, so the order is kept.
+func (q *QuietHours) Notify(ctx context.Context, item Item) error {
+	if q.urgent[item.Feed] {
+		return q.notifier.Notify(ctx, item) //nolint:wrapcheck
+	}
+
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	quiet := q.period.contains(time.Now())
+	if !quiet && len(q.storage.GetQueueItems(q.queue)) == 0 {
+		return q.notifier.Notify(ctx, item) //nolint:wrapcheck
+	}
+	if err := q.storage.PushQueueItem(q.queue, item); err != nil {
+		return fmt.Errorf("queue item: %w", err)
+	}
+	if quiet {
+		return nil
+	}
+	// The item is kept in the queue on errors, and sent on the next check
+	if err := q.release(ctx); err != nil {
+		q.log.Errorf("Failed to release queued items [%s]: %v", q.queue, err)
+	}
+	return nil
+}
+
+// Update passes items of the feed to the underlying notifier. Sent messages
+// are updated during quiet hours too, because edits and deletions don't
+// notify users.
+func (q *QuietHours) Update(ctx context.Context, feed string, current, processed []Item) error {
+	return update(ctx, q.notifier, feed, current, processed)
+}
+
+// Run periodically releases queued items when quiet hours are over.
+func (q *QuietHours) Run(ctx context.Context) {
+	defer startRunner(ctx, q.notifier)()
+
+	t := time.NewTicker(q.interval)
+	defer t.Stop()
+	for {
+		if !q.period.contains(time.Now()) {
+			if err := q.Release(ctx); err != nil {
+				q.log.Errorf("Failed to release queued items [%s]: %v", q.queue, err)
+			}
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Release sends all queued items in order. Items that failed to send are
+// kept in the queue.
+func (q *QuietHours) Release(ctx context.Context) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	return q.release(ctx)
+}
+
+func (q *QuietHours) release(ctx context.Context) error {
+	items := q.storage.GetQueueItems(q.queue)
+	var sent int
+	var sendErr error
+	for _, item := range items {
+		if sendErr = q.notifier.Notify(ctx, item); sendErr != nil {
+			break
+		}
+		sent++
+	}
+	if sent > 0 {
+		if err := q.storage.RemoveQueueItems(q.queue, sent); err != nil {
+			return fmt.Errorf("remove queued items: %w", err)
+		}
+	}
+	if sendErr != nil {
+		return fmt.Errorf("send item: %w", sendErr)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	p, err := ParseQuietHours(QuietHoursConfig{From: "22:30", To: "07:00", TimeZone: "Europe/Berlin"})
+	assert.NoError(t, err)
+	assert.Equal(t, 22*time.Hour+30*time.Minute, p.start)
+	assert.Equal(t, 7*time.Hour, p.end)
+	assert.Equal(t, "Europe/Berlin", p.loc.String())
+
+	p, err = ParseQuietHours(QuietHoursConfig{From: "01:00", To: "02:00"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.UTC, p.loc)
+
+	_, err = ParseQuietHours(QuietHoursConfig{From: "10pm", To: "07:00"})
+	assert.EqualError(t, err, "invalid start time: 10pm")
+	_, err = ParseQuietHours(QuietHoursConfig{From: "22:00", To: "25:00"})
+	assert.EqualError(t, err, "invalid end time: 25:00")
+	_, err = ParseQuietHours(QuietHoursConfig{From: "22:00", To: "07:00", TimeZone: "Mars/Base"})
+	assert.EqualError(t, err, "invalid time zone: Mars/Base")
+}
+
+func TestQuietPeriod_Contains(t *testing.T) {
+	t.Run("same day", func(t *testing.T) {
+		p, err := ParseQuietHours(QuietHoursConfig{From: "12:00", To: "14:00"})
+		assert.NoError(t, err)
+
+		assert.False(t, p.contains(time.Date(2020, 1, 1, 11, 59, 0, 0, time.UTC)))
+		assert.True(t, p.contains(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)))
+		assert.True(t, p.contains(time.Date(2020, 1, 1, 13, 59, 0, 0, time.UTC)))
+		assert.False(t, p.contains(time.Date(2020, 1, 1, 14, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("over midnight in time zone", func(t *testing.T) {
+		p, err := ParseQuietHours(QuietHoursConfig{From: "22:00", To: "07:00", TimeZone: "Asia/Tokyo"})
+		assert.NoError(t, err)
+
+		// Tokyo is UTC+9
+		assert.False(t, p.contains(time.Date(2020, 1, 1, 12, 59, 0, 0, time.UTC)))
+		assert.True(t, p.contains(time.Date(2020, 1, 1, 13, 0, 0, 0, time.UTC)))
+		assert.True(t, p.contains(time.Date(2020, 1, 1, 21, 59, 0, 0, time.UTC)))
+		assert.False(t, p.contains(time.Date(2020, 1, 1, 22, 0, 0, 0, time.UTC)))
+	})
+}
+
+func TestQuietHours_Notify(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+	ctx := context.Background()
+
+	// Quiet all day long
+	conf := QuietHoursConfig{From: "00:00", To: "23:59"}
+
+	n := &testNotifier{}
+	s := &testQueueStorage{}
+	q, err := NewQuietHours("chat", n, s, conf, []string{"urgent"}, log)
+	assert.NoError(t, err)
+	q.period.end = 24 * time.Hour
+
+	assert.NoError(t, q.Notify(ctx, Item{Feed: "regular", Link: "one"}))
+	assert.NoError(t, q.Notify(ctx, Item{Feed: "urgent", Link: "two"}))
+	assert.Equal(t, []Item{{Feed: "urgent", Link: "two"}}, n.items)
+	assert.Equal(t, []Item{{Feed: "regular", Link: "one"}}, s.GetQueueItems("quiet:chat"))
+
+	// Not quiet anymore, queued items go first
+	q.period.end = 0
+	assert.NoError(t, q.Notify(ctx, Item{Feed: "regular", Link: "three"}))
+	assert.Equal(t, []Item{
+		{Feed: "urgent", Link: "two"},
+		{Feed: "regular", Link: "one"},
+		{Feed: "regular", Link: "three"},
+	}, n.items)
+	assert.Empty(t, s.GetQueueItems("quiet:chat"))
+
+	assert.NoError(t, q.Notify(ctx, Item{Feed: "regular", Link: "four"}))
+	assert.Len(t, n.items, 4)
+}
+
+func TestQuietHours_Release(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+	ctx := context.Background()
+
+	t.Run("release in order", func(t *testing.T) {
+		n := &testNotifier{}
+		s := &testQueueStorage{}
+		q, err := NewQuietHours("chat", n, s, QuietHoursConfig{From: "00:00", To: "00:00"}, nil, log)
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.PushQueueItem("quiet:chat", Item{Link: "one"}))
+		assert.NoError(t, s.PushQueueItem("quiet:chat", Item{Link: "two"}))
+
+		assert.NoError(t, q.Release(ctx))
+		assert.Equal(t, []Item{{Link: "one"}, {Link: "two"}}, n.items)
+		assert.Empty(t, s.GetQueueItems("quiet:chat"))
+	})
+
+	t.Run("send error", func(t *testing.T) {
+		s := &testQueueStorage{}
+		q, err := NewQuietHours("chat", &testFailingNotifier{}, s,
+			QuietHoursConfig{From: "00:00", To: "00:00"}, nil, log)
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.PushQueueItem("quiet:chat", Item{Link: "one"}))
+		assert.EqualError(t, q.Release(ctx), "send item: fail")
+		assert.Len(t, s.GetQueueItems("quiet:chat"), 1)
+
+		// New items wait for the queued ones
+		assert.NoError(t, q.Notify(ctx, Item{Link: "two"}))
+		assert.Equal(t, []Item{{Link: "one"}, {Link: "two"}}, s.GetQueueItems("quiet:chat"))
+	})
+}
+
+func TestQuietHours_Update(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	n := &testUpdater{}
+	conf := QuietHoursConfig{From: "00:00", To: "23:59"}
+	q, err := NewQuietHours("chat", n, &testQueueStorage{}, conf, nil, log)
+	assert.NoError(t, err)
+
+	// Updates are not delayed by quiet hours
+	assert.NoError(t, q.Update(context.Background(), "f1", nil, []Item{{Link: "one"}}))
+	assert.Equal(t, map[string][]Item{"f1": {{Link: "one"}}}, n.updates)
+}
+
+func TestQuietHours_Run(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	n := &testNotifier{}
+	s := &testQueueStorage{}
+	q, err := NewQuietHours("chat", n, s, QuietHoursConfig{From: "00:00", To: "00:00"}, nil, log)
+	assert.NoError(t, err)
+	q.interval = 5 * time.Millisecond
+
+	assert.NoError(t, s.PushQueueItem("quiet:chat", Item{Link: "one"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	q.Run(ctx)
+
+	assert.Equal(t, []Item{{Link: "one"}}, n.items)
+}
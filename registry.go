@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Errors of feed changes.
+var (
+	// ErrUnknownFeed is returned for feeds, that are not in the registry.
+	ErrUnknownFeed = errors.New("unknown feed")
+	// ErrDuplicateFeed is returned for feeds, that are already added.
+	ErrDuplicateFeed = errors.New("duplicate feed url")
+	// ErrInvalidFeed is returned for feeds with invalid settings.
+	ErrInvalidFeed = errors.New("invalid feed")
+)
+
+// FeedChanges are changes of the feed list made at runtime. They are
+// applied on top of the feeds from the config.
+type FeedChanges struct {
+	Added   []Feed   `yaml:"added,omitempty"`
+	Removed []string `yaml:"removed,omitempty"`
+	Paused  []string `yaml:"paused,omitempty"`
+}
+
+// RegistryStorage keeps changes of the feed list.
+type RegistryStorage interface {
+	GetFeedChanges() FeedChanges
+	SaveFeedChanges(c FeedChanges) error
+}
+
+// FeedStatus is a result of the feed's last fetch.
+type FeedStatus struct {
+	LastFetch time.Time `json:"last_fetch"`
+	// Items is a number of new items.
+	Items int `json:"items"`
+	// Error is an error of fetching or processing, empty on success.
+	Error string `json:"error,omitempty"`
+}
+
+// FeedInfo is a feed of the registry with its state.
+type FeedInfo struct {
+	Feed   Feed       `json:"feed"`
+	Paused bool       `json:"paused"`
+	Status FeedStatus `json:"status"`
+}
+
+// FeedRegistry is a list of feeds, that can be changed at runtime. Feeds
+// from the config can be removed or paused, and new feeds can be added.
+// Changes are kept in the storage, so they survive restarts. The registry
+// also keeps results of the last fetch of each feed.
+type FeedRegistry struct {
+	config  []Feed
+	storage RegistryStorage
+
+	// catchUp and maxItems are defaults for added feeds.
+	catchUp  string
+	maxItems int
+
+	status  map[string]FeedStatus
+	changed chan struct{}
+	mx      *sync.Mutex
+}
+
+// NewFeedRegistry creates a registry of feeds from the config.
+func NewFeedRegistry(conf Config, s RegistryStorage) *FeedRegistry {
+	return &FeedRegistry{
+		config:   conf.Feeds,
+		storage:  s,
+		catchUp:  conf.CatchUp,
+		maxItems: conf.MaxItems,
+		status:   map[string]FeedStatus{},
+		changed:  make(chan struct{}, 1),
+		mx:       &sync.Mutex{},
+	}
+}
+
+// Feeds returns feeds, that are not paused.
+func (r *FeedRegistry) Feeds() []Feed {
+	var feeds []Feed
+	for _, f := range r.List() {
+		if !f.Paused {
+			feeds = append(feeds, f.Feed)
+		}
+	}
+	return feeds
+}
+
+// Changed returns a channel, that receives a value when the list of
+// feeds changes.
+func (r *FeedRegistry) Changed() <-chan struct{} {
+	return r.changed
+}
+
+// Has reports whether the feed is in the registry, including paused ones.
+func (r *FeedRegistry) Has(url string) bool {
+	return r.has(r.storage.GetFeedChanges(), url)
+}
+
+// has reports whether the feed is in the list with the changes.
+func (r *FeedRegistry) has(changes FeedChanges, url string) bool {
+	return slices.ContainsFunc(r.all(changes), func(f Feed) bool {
+		return f.URL == url
+	})
+}
+
+// List returns all feeds with their state, feeds from the config first.
+func (r *FeedRegistry) List() []FeedInfo {
+	changes := r.storage.GetFeedChanges()
+	feeds := r.all(changes)
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	list := make([]FeedInfo, len(feeds))
+	for i, f := range feeds {
+		list[i] = FeedInfo{
+			Feed:   f,
+			Paused: slices.Contains(changes.Paused, f.URL),
+			Status: r.status[f.URL],
+		}
+	}
+	return list
+}
+
+// all returns feeds from the config, that are not removed, and added
+// feeds.
+func (r *FeedRegistry) all(changes FeedChanges) []Feed {
+	feeds := make([]Feed, 0, len(r.config)+len(changes.Added))
+	for _, f := range r.config {
+		if !slices.Contains(changes.Removed, f.URL) {
+			feeds = append(feeds, f)
+		}
+	}
+	for _, f := range changes.Added {
+		if f.CatchUp == "" {
+			f.CatchUp = r.catchUp
+		}
+		if f.MaxItems == 0 {
+			f.MaxItems = r.maxItems
+		}
+		feeds = append(feeds, f)
+	}
+	return feeds
+}
+
+// Get returns the feed with its state.
+func (r *FeedRegistry) Get(url string) (FeedInfo, bool) {
+	for _, f := range r.List() {
+		if f.Feed.URL == url {
+			return f, true
+		}
+	}
+	return FeedInfo{}, false
+}
+
+// Add adds the feed. Removed feeds from the config are restored with
+// their settings from the config.
+func (r *FeedRegistry) Add(f Feed) error {
+	if err := validateRuntimeFeed(f); err != nil {
+		return err
+	}
+	return r.change(func(c *FeedChanges) error {
+		if r.has(*c, f.URL) {
+			return fmt.Errorf("%w: %s", ErrDuplicateFeed, f.URL)
+		}
+		if slices.Contains(c.Removed, f.URL) {
+			c.Removed = remove(c.Removed, f.URL)
+			return nil
+		}
+		c.Added = append(c.Added, f)
+		return nil
+	})
+}
+
+// Update changes settings of the feed. Processors, translation and
+// urgency are applied at startup, so they are kept as is. Feeds from
+// the config are replaced with added ones.
+func (r *FeedRegistry) Update(f Feed) error {
+	return r.change(func(c *FeedChanges) error {
+		feeds := r.all(*c)
+		i := slices.IndexFunc(feeds, func(old Feed) bool { return old.URL == f.URL })
+		if i < 0 {
+			return fmt.Errorf("%w: %s", ErrUnknownFeed, f.URL)
+		}
+		old := feeds[i]
+		f.Processors, f.Translate, f.Urgent = old.Processors, old.Translate, old.Urgent
+		if err := validateRuntimeFeed(Feed{URL: f.URL, CatchUp: f.CatchUp, MaxItems: f.MaxItems}); err != nil {
+			return err
+		}
+
+		j := slices.IndexFunc(c.Added, func(old Feed) bool { return old.URL == f.URL })
+		if j >= 0 {
+			c.Added[j] = f
+			return nil
+		}
+		c.Removed = append(c.Removed, f.URL)
+		c.Added = append(c.Added, f)
+		return nil
+	})
+}
+
+// Remove removes the feed.
+func (r *FeedRegistry) Remove(url string) error {
+	return r.change(func(c *FeedChanges) error {
+		if !r.has(*c, url) {
+			return fmt.Errorf("%w: %s", ErrUnknownFeed, url)
+		}
+		n := len(c.Added)
+		c.Added = slices.DeleteFunc(c.Added, func(f Feed) bool { return f.URL == url })
+		if len(c.Added) == n {
+			c.Removed = append(c.Removed, url)
+		}
+		c.Paused = remove(c.Paused, url)
+		return nil
+	})
+}
+
+// Pause stops fetching of the feed.
+func (r *FeedRegistry) Pause(url string) error {
+	return r.change(func(c *FeedChanges) error {
+		if !r.has(*c, url) {
+			return fmt.Errorf("%w: %s", ErrUnknownFeed, url)
+		}
+		if !slices.Contains(c.Paused, url) {
+			c.Paused = append(c.Paused, url)
+		}
+		return nil
+	})
+}
+
+// Resume starts fetching of the paused feed.
+func (r *FeedRegistry) Resume(url string) error {
+	return r.change(func(c *FeedChanges) error {
+		if !r.has(*c, url) {
+			return fmt.Errorf("%w: %s", ErrUnknownFeed, url)
+		}
+		c.Paused = remove(c.Paused, url)
+		return nil
+	})
+}
+
+// change applies the change to the stored feed list, and signals that
+// the list has changed.
+func (r *FeedRegistry) change(apply func(c *FeedChanges) error) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	c := r.storage.GetFeedChanges()
+	if err := apply(&c); err != nil {
+		return err
+	}
+	if err := r.storage.SaveFeedChanges(c); err != nil {
+		return fmt.Errorf("save feed changes: %w", err)
+	}
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// SetStatus saves the result of the feed's fetch and processing.
+func (r *FeedRegistry) SetStatus(feed string, items int, err error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	s := FeedStatus{LastFetch: time.Now(), Items: items}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	r.status[feed] = s
+}
+
+// validateRuntimeFeed checks the feed, that is added at runtime.
+// Processors, translation and urgency can't be set, because they are
+// applied at startup.
+func validateRuntimeFeed(f Feed) error {
+	if err := validateFeedURL(f.URL); err != nil {
+		return fmt.Errorf("%w: url %q: %w", ErrInvalidFeed, f.URL, err)
+	}
+	if _, err := ParseCatchUp(f.CatchUp); err != nil {
+		return fmt.Errorf("%w: catch-up policy: %w", ErrInvalidFeed, err)
+	}
+	if f.MaxItems < 0 {
+		return fmt.Errorf("%w: negative max items", ErrInvalidFeed)
+	}
+	if f.Urgent || f.Translate || len(f.Processors) > 0 {
+		return fmt.Errorf("%w: processors, translation and urgency can only be set in the config", ErrInvalidFeed)
+	}
+	return nil
+}
+
+// remove returns the list without the value.
+func remove(list []string, v string) []string {
+	return slices.DeleteFunc(list, func(s string) bool { return s == v })
+}
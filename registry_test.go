@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedRegistry(t *testing.T) {
+	conf := Config{
+		Feeds:    []Feed{{URL: "https://example.com/1"}, {URL: "https://example.com/2"}},
+		CatchUp:  "skip",
+		MaxItems: 5,
+	}
+
+	t.Run("config feeds", func(t *testing.T) {
+		r := NewFeedRegistry(conf, &testRegistryStorage{})
+		assert.Equal(t, conf.Feeds, r.Feeds())
+		assert.True(t, r.Has("https://example.com/1"))
+		assert.False(t, r.Has("https://example.com/3"))
+	})
+
+	t.Run("add and remove", func(t *testing.T) {
+		s := &testRegistryStorage{}
+		r := NewFeedRegistry(conf, s)
+
+		assert.NoError(t, r.Add(Feed{URL: "https://example.com/3"}))
+		assert.NoError(t, r.Remove("https://example.com/1"))
+		assert.Len(t, r.Changed(), 1)
+
+		// Defaults are set for added feeds
+		assert.Equal(t, []Feed{
+			{URL: "https://example.com/2"},
+			{URL: "https://example.com/3", CatchUp: "skip", MaxItems: 5},
+		}, r.Feeds())
+		assert.Equal(t, FeedChanges{
+			Added:   []Feed{{URL: "https://example.com/3"}},
+			Removed: []string{"https://example.com/1"},
+		}, s.changes)
+
+		// Removed feeds from the config are restored
+		assert.NoError(t, r.Add(Feed{URL: "https://example.com/1"}))
+		assert.NoError(t, r.Remove("https://example.com/3"))
+		assert.Equal(t, conf.Feeds, r.Feeds())
+		assert.Equal(t, FeedChanges{Added: []Feed{}, Removed: []string{}}, s.changes)
+	})
+
+	t.Run("invalid changes", func(t *testing.T) {
+		r := NewFeedRegistry(conf, &testRegistryStorage{})
+
+		err := r.Add(Feed{URL: "ftp://example.com"})
+		assert.EqualError(t, err, `invalid feed: url "ftp://example.com": scheme must be http or https`)
+		err = r.Add(Feed{URL: "https://example.com/3", CatchUp: "all"})
+		assert.EqualError(t, err, "invalid feed: catch-up policy: unknown policy: all")
+		err = r.Add(Feed{URL: "https://example.com/3", MaxItems: -1})
+		assert.EqualError(t, err, "invalid feed: negative max items")
+		err = r.Add(Feed{URL: "https://example.com/3", Urgent: true})
+		assert.True(t, errors.Is(err, ErrInvalidFeed))
+		err = r.Add(Feed{URL: "https://example.com/1"})
+		assert.EqualError(t, err, "duplicate feed url: https://example.com/1")
+		err = r.Update(Feed{URL: "https://example.com/3"})
+		assert.True(t, errors.Is(err, ErrUnknownFeed))
+		err = r.Update(Feed{URL: "https://example.com/1", MaxItems: -1})
+		assert.EqualError(t, err, "invalid feed: negative max items")
+		err = r.Remove("https://example.com/3")
+		assert.True(t, errors.Is(err, ErrUnknownFeed))
+		err = r.Pause("https://example.com/3")
+		assert.EqualError(t, err, "unknown feed: https://example.com/3")
+		err = r.Resume("https://example.com/3")
+		assert.EqualError(t, err, "unknown feed: https://example.com/3")
+		assert.Empty(t, r.Changed())
+	})
+
+	t.Run("update", func(t *testing.T) {
+		conf := Config{Feeds: []Feed{
+			{URL: "https://example.com/1", Urgent: true},
+			{URL: "https://example.com/2"},
+		}}
+		r := NewFeedRegistry(conf, &testRegistryStorage{})
+		assert.NoError(t, r.Add(Feed{URL: "https://example.com/3"}))
+		assert.NoError(t, r.Pause("https://example.com/1"))
+
+		// Settings, that are applied at startup, are kept
+		assert.NoError(t, r.Update(Feed{URL: "https://example.com/1", Title: "One"}))
+		assert.NoError(t, r.Update(Feed{URL: "https://example.com/3", Title: "Three", Urgent: true}))
+		assert.Equal(t, []FeedInfo{
+			{Feed: Feed{URL: "https://example.com/2"}},
+			{Feed: Feed{URL: "https://example.com/3", Title: "Three"}},
+			{Feed: Feed{URL: "https://example.com/1", Title: "One", Urgent: true}, Paused: true},
+		}, r.List())
+
+		// Removed feeds from the config are restored with their settings
+		assert.NoError(t, r.Remove("https://example.com/1"))
+		assert.NoError(t, r.Add(Feed{URL: "https://example.com/1"}))
+		f, ok := r.Get("https://example.com/1")
+		assert.True(t, ok)
+		assert.Equal(t, FeedInfo{Feed: conf.Feeds[0]}, f)
+		_, ok = r.Get("https://example.com/4")
+		assert.False(t, ok)
+	})
+
+	t.Run("pause and resume", func(t *testing.T) {
+		r := NewFeedRegistry(conf, &testRegistryStorage{})
+
+		assert.NoError(t, r.Pause("https://example.com/1"))
+		assert.NoError(t, r.Pause("https://example.com/1"))
+		assert.Equal(t, []Feed{{URL: "https://example.com/2"}}, r.Feeds())
+		// Paused feeds are still in the registry
+		assert.True(t, r.Has("https://example.com/1"))
+		assert.Equal(t, []FeedInfo{
+			{Feed: Feed{URL: "https://example.com/1"}, Paused: true},
+			{Feed: Feed{URL: "https://example.com/2"}},
+		}, r.List())
+
+		assert.NoError(t, r.Resume("https://example.com/1"))
+		assert.Equal(t, conf.Feeds, r.Feeds())
+	})
+
+	t.Run("status", func(t *testing.T) {
+		r := NewFeedRegistry(conf, &testRegistryStorage{})
+
+		r.SetStatus("https://example.com/1", 3, nil)
+		r.SetStatus("https://example.com/2", 0, errors.New("fail"))
+
+		list := r.List()
+		assert.Equal(t, 3, list[0].Status.Items)
+		assert.Empty(t, list[0].Status.Error)
+		assert.WithinDuration(t, time.Now(), list[0].Status.LastFetch, time.Second)
+		assert.Equal(t, "fail", list[1].Status.Error)
+	})
+
+	t.Run("storage error", func(t *testing.T) {
+		r := NewFeedRegistry(conf, &testRegistryStorage{err: errors.New("fail")})
+
+		err := r.Pause("https://example.com/1")
+		assert.EqualError(t, err, "save feed changes: fail")
+		assert.Equal(t, conf.Feeds, r.Feeds())
+	})
+}
+
+type testRegistryStorage struct {
+	changes FeedChanges
+	err     error
+	mx      sync.Mutex
+}
+
+func (s *testRegistryStorage) GetFeedChanges() FeedChanges {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.changes
+}
+
+func (s *testRegistryStorage) SaveFeedChanges(c FeedChanges) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.changes = c
+	return nil
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxRequestBody limits the size of request bodies of the API.
+const maxRequestBody = 1 << 20
+
+// APIStorage keeps the state of feeds, delivered items and the audit log.
+type APIStorage interface {
+	AdminStorage
+	SaveLastUpdate(feed string, t time.Time) error
+}
+
+// RESTAPI is a JSON API for automation. It covers the same operations as
+// the web admin UI, and also manages destinations, that are added at
+// runtime. The API is described in web/openapi.yaml.
+type RESTAPI struct {
+	feeds    *FeedRegistry
+	dests    *DestinationRegistry
+	storage  APIStorage
+	control  FeedControl
+	notifier Notifier
+	log      *logrus.Logger
+}
+
+// NewRESTAPI creates a JSON API. Feeds are paused, resumed and fetched
+// through the control, and delivered items are re-sent through the notifier.
+func NewRESTAPI(
+	feeds *FeedRegistry,
+	dests *DestinationRegistry,
+	s APIStorage,
+	c FeedControl,
+	n Notifier,
+	log *logrus.Logger,
+) *RESTAPI {
+	return &RESTAPI{
+		feeds:    feeds,
+		dests:    dests,
+		storage:  s,
+		control:  c,
+		notifier: n,
+		log:      log,
+	}
+}
+
+// feedState is the state of a feed in the storage.
+type feedState struct {
+	// LastUpdate is publication time of the last sent item, empty for
+	// new feeds.
+	LastUpdate *time.Time `json:"last_update"`
+}
+
+// deliveryPage is a page of delivered items, newest first.
+type deliveryPage struct {
+	Deliveries []Delivery `json:"deliveries"`
+	Page       int        `json:"page"`
+	PerPage    int        `json:"per_page"`
+	Total      int        `json:"total"`
+}
+
+// Handler returns HTTP handler of the API. Requests must be authenticated
+// by the caller.
+func (a *RESTAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/openapi.yaml", a.openAPI)
+
+	mux.HandleFunc("GET /api/feeds", a.listFeeds)
+	mux.HandleFunc("POST /api/feeds", a.addFeed)
+	mux.HandleFunc("GET /api/feeds/{url}", a.getFeed)
+	mux.HandleFunc("PUT /api/feeds/{url}", a.updateFeed)
+	mux.HandleFunc("DELETE /api/feeds/{url}", a.removeFeed)
+	mux.HandleFunc("POST /api/feeds/{url}/pause", a.feedAction("pause", a.control.Pause))
+	mux.HandleFunc("POST /api/feeds/{url}/resume", a.feedAction("resume", a.control.Resume))
+	mux.HandleFunc("POST /api/feeds/{url}/fetch", a.fetchFeed)
+	mux.HandleFunc("GET /api/feeds/{url}/state", a.getState)
+	mux.HandleFunc("PUT /api/feeds/{url}/state", a.setState)
+	mux.HandleFunc("DELETE /api/feeds/{url}/state", a.resetState)
+
+	mux.HandleFunc("GET /api/destinations", a.listDestinations)
+	mux.HandleFunc("POST /api/destinations", a.addDestination)
+	mux.HandleFunc("GET /api/destinations/{chat}", a.getDestination)
+	mux.HandleFunc("PUT /api/destinations/{chat}", a.updateDestination)
+	mux.HandleFunc("DELETE /api/destinations/{chat}", a.removeDestination)
+
+	mux.HandleFunc("GET /api/deliveries", a.listDeliveries)
+	mux.HandleFunc("POST /api/deliveries/{id}/resend", a.resend)
+
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	})
+	return mux
+}
+
+// openAPI writes the description of the API.
+func (a *RESTAPI) openAPI(w http.ResponseWriter, _ *http.Request) {
+	data, err := web.ReadFile("web/openapi.yaml")
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(data) //nolint:errcheck
+}
+
+func (a *RESTAPI) listFeeds(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, a.feeds.List())
+}
+
+func (a *RESTAPI) getFeed(w http.ResponseWriter, r *http.Request) {
+	url := r.PathValue("url")
+	f, ok := a.feeds.Get(url)
+	if !ok {
+		a.fail(w, fmt.Errorf("%w: %s", ErrUnknownFeed, url))
+		return
+	}
+	writeJSON(w, http.StatusOK, f)
+}
+
+func (a *RESTAPI) addFeed(w http.ResponseWriter, r *http.Request) {
+	var f Feed
+	if err := decodeJSON(r, &f); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := a.feeds.Add(f); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(r, "add", f.URL)
+	info, _ := a.feeds.Get(f.URL)
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func (a *RESTAPI) updateFeed(w http.ResponseWriter, r *http.Request) {
+	url := r.PathValue("url")
+	f := Feed{URL: url}
+	if err := decodeJSON(r, &f); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if f.URL != url {
+		writeError(w, http.StatusBadRequest, errors.New("feed url can't be changed"))
+		return
+	}
+	if err := a.feeds.Update(f); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(r, "update", url)
+	info, _ := a.feeds.Get(url)
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (a *RESTAPI) removeFeed(w http.ResponseWriter, r *http.Request) {
+	url := r.PathValue("url")
+	if err := a.feeds.Remove(url); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(r, "remove", url)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// feedAction returns a handler, that applies the action to the feed, and
+// writes the feed.
+func (a *RESTAPI) feedAction(action string, apply func(feed string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		url := r.PathValue("url")
+		if err := apply(url); err != nil {
+			a.fail(w, err)
+			return
+		}
+		a.audit(r, action, url)
+		info, _ := a.feeds.Get(url)
+		writeJSON(w, http.StatusOK, info)
+	}
+}
+
+// fetchFeed starts fetching of the feed. Items are sent in background.
+func (a *RESTAPI) fetchFeed(w http.ResponseWriter, r *http.Request) {
+	if err := a.control.FetchNow(r.PathValue("url")); err != nil {
+		a.fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *RESTAPI) getState(w http.ResponseWriter, r *http.Request) {
+	url := r.PathValue("url")
+	if !a.feeds.Has(url) {
+		a.fail(w, fmt.Errorf("%w: %s", ErrUnknownFeed, url))
+		return
+	}
+	writeJSON(w, http.StatusOK, a.state(url))
+}
+
+func (a *RESTAPI) setState(w http.ResponseWriter, r *http.Request) {
+	url := r.PathValue("url")
+	if !a.feeds.Has(url) {
+		a.fail(w, fmt.Errorf("%w: %s", ErrUnknownFeed, url))
+		return
+	}
+	var s feedState
+	if err := decodeJSON(r, &s); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.LastUpdate == nil {
+		writeError(w, http.StatusBadRequest, errors.New("empty last update"))
+		return
+	}
+	if err := a.storage.SaveLastUpdate(url, *s.LastUpdate); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(r, "set", url+" "+s.LastUpdate.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, a.state(url))
+}
+
+func (a *RESTAPI) resetState(w http.ResponseWriter, r *http.Request) {
+	url := r.PathValue("url")
+	if !a.feeds.Has(url) {
+		a.fail(w, fmt.Errorf("%w: %s", ErrUnknownFeed, url))
+		return
+	}
+	if err := a.storage.ResetLastUpdate(url); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(r, "reset", url)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// state returns the state of the feed.
+func (a *RESTAPI) state(feed string) feedState {
+	t, ok := a.storage.LastUpdates()[feed]
+	if !ok || t.IsZero() {
+		return feedState{}
+	}
+	return feedState{LastUpdate: &t}
+}
+
+func (a *RESTAPI) listDestinations(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, a.dests.List())
+}
+
+func (a *RESTAPI) getDestination(w http.ResponseWriter, r *http.Request) {
+	chat := r.PathValue("chat")
+	d, ok := a.dests.Get(chat)
+	if !ok {
+		a.fail(w, fmt.Errorf("%w: %s", ErrUnknownDestination, chat))
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (a *RESTAPI) addDestination(w http.ResponseWriter, r *http.Request) {
+	var d Destination
+	if err := decodeJSON(r, &d); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := a.dests.Add(d); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(r, "add-destination", d.Chat)
+	writeJSON(w, http.StatusCreated, DestinationInfo{Destination: d})
+}
+
+func (a *RESTAPI) updateDestination(w http.ResponseWriter, r *http.Request) {
+	chat := r.PathValue("chat")
+	d := Destination{Chat: chat}
+	if err := decodeJSON(r, &d); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if d.Chat != chat {
+		writeError(w, http.StatusBadRequest, errors.New("destination chat can't be changed"))
+		return
+	}
+	if err := a.dests.Update(d); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(r, "update-destination", chat)
+	writeJSON(w, http.StatusOK, DestinationInfo{Destination: d})
+}
+
+func (a *RESTAPI) removeDestination(w http.ResponseWriter, r *http.Request) {
+	chat := r.PathValue("chat")
+	if err := a.dests.Remove(chat); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.audit(r, "remove-destination", chat)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listDeliveries writes a page of delivered items, newest first.
+func (a *RESTAPI) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	page, err := queryInt(r, "page", 1)
+	if err != nil || page < 1 {
+		writeError(w, http.StatusBadRequest, errors.New("invalid page"))
+		return
+	}
+	perPage, err := queryInt(r, "per_page", adminPageSize)
+	if err != nil || perPage < 1 || perPage > maxDeliveries {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("per page must be from 1 to %d", maxDeliveries))
+		return
+	}
+
+	all := a.storage.GetDeliveries()
+	from := min((page-1)*perPage, len(all))
+	to := min(from+perPage, len(all))
+	resp := deliveryPage{
+		Deliveries: append([]Delivery{}, all[from:to]...),
+		Page:       page,
+		PerPage:    perPage,
+		Total:      len(all),
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// resend sends the delivered item once again. Deduplication is bypassed.
+func (a *RESTAPI) resend(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid delivery id"))
+		return
+	}
+	d, ok := a.storage.GetDelivery(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown delivery: %d", id))
+		return
+	}
+	if err := a.notifier.Notify(r.Context(), d.Item); err != nil {
+		a.log.Errorf("Failed to re-send item %s: %v", d.Item.Link, err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	a.audit(r, "resend", d.Item.Link)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// audit writes the successful change to the audit log.
+func (a *RESTAPI) audit(r *http.Request, action, target string) {
+	auditEditor(a.storage, a.log, r, action, target)
+}
+
+// fail writes the error with the status of its kind. Unexpected errors
+// are logged.
+func (a *RESTAPI) fail(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownFeed), errors.Is(err, ErrUnknownDestination):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrDuplicateFeed), errors.Is(err, ErrDuplicateDestination),
+		errors.Is(err, ErrConfigDestination):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, ErrInvalidFeed), errors.Is(err, ErrInvalidDestination):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, ErrControlStopped):
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		a.log.Errorf("API request failed: %v", err)
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// decodeJSON reads the request body into the value. Unknown fields are
+// not allowed.
+func decodeJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+// queryInt returns the integer query parameter, or the default value if
+// it's not set.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", name, err)
+	}
+	return n, nil
+}
+
+// writeJSON writes the value as JSON response.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+// writeError writes the error as JSON response.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
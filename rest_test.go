@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRESTAPI_Feeds(t *testing.T) {
+	feed := "/api/feeds/" + url.PathEscape("https://example.com/1")
+
+	t.Run("list", func(t *testing.T) {
+		api := newTestAPI(&testNotifier{})
+		api.feeds.SetStatus("https://example.com/1", 2, errors.New("fail"))
+
+		w := api.request(http.MethodGet, "/api/feeds", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var list []FeedInfo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+		assert.Len(t, list, 2)
+		assert.Equal(t, "https://example.com/1", list[0].Feed.URL)
+		assert.Equal(t, "fail", list[0].Status.Error)
+
+		w = api.request(http.MethodGet, feed, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"feed":{"url":"https://example.com/1"},"paused":false`)
+	})
+
+	t.Run("add, update and remove", func(t *testing.T) {
+		api := newTestAPI(&testNotifier{})
+
+		w := api.request(http.MethodPost, "/api/feeds", `{"url": "https://example.com/3", "title": "Three"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"title":"Three"`)
+
+		path := "/api/feeds/" + url.PathEscape("https://example.com/3")
+		w = api.request(http.MethodPut, path, `{"title": "New", "max_items": 3}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		f, _ := api.feeds.Get("https://example.com/3")
+		assert.Equal(t, Feed{URL: "https://example.com/3", Title: "New", MaxItems: 3}, f.Feed)
+
+		w = api.request(http.MethodDelete, path, "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.False(t, api.feeds.Has("https://example.com/3"))
+
+		var actions []string
+		for _, e := range api.storage.audit {
+			actions = append(actions, e.User+" "+e.Action+" "+e.Target)
+		}
+		assert.Equal(t, []string{
+			"root add https://example.com/3",
+			"root update https://example.com/3",
+			"root remove https://example.com/3",
+		}, actions)
+	})
+
+	t.Run("pause, resume and fetch", func(t *testing.T) {
+		api := newTestAPI(&testNotifier{})
+
+		w := api.request(http.MethodPost, feed+"/pause", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"paused":true`)
+		w = api.request(http.MethodPost, feed+"/resume", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"paused":false`)
+
+		w = api.request(http.MethodPost, feed+"/fetch", "")
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, []string{"https://example.com/1"}, api.control.feeds)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		api := newTestAPI(&testNotifier{})
+		unknown := "/api/feeds/" + url.PathEscape("https://example.com/3")
+
+		for _, tc := range []struct {
+			method, path, body string
+			code               int
+			msg                string
+		}{
+			{http.MethodGet, unknown, "", http.StatusNotFound, "unknown feed: https://example.com/3"},
+			{http.MethodDelete, unknown, "", http.StatusNotFound, "unknown feed: https://example.com/3"},
+			{http.MethodPost, unknown + "/fetch", "", http.StatusNotFound, "unknown feed: https://example.com/3"},
+			{
+				http.MethodPost, "/api/feeds", `{"url": "https://example.com/1"}`,
+				http.StatusConflict, "duplicate feed url: https://example.com/1",
+			},
+			{
+				http.MethodPost, "/api/feeds", `{"url": "https://example.com/3", "max_items": -1}`,
+				http.StatusBadRequest, "invalid feed: negative max items",
+			},
+			{
+				http.MethodPost, "/api/feeds", `{"link": "https://example.com/3"}`,
+				http.StatusBadRequest, `invalid request body: json: unknown field "link"`,
+			},
+			{
+				http.MethodPut, feed, `{"url": "https://example.com/3"}`,
+				http.StatusBadRequest, "feed url can't be changed",
+			},
+			{http.MethodGet, "/api/unknown", "", http.StatusNotFound, "not found"},
+		} {
+			w := api.request(tc.method, tc.path, tc.body)
+			assert.Equal(t, tc.code, w.Code, tc.path)
+			assert.JSONEq(t, `{"error": "`+strings.ReplaceAll(tc.msg, `"`, `\"`)+`"}`, w.Body.String())
+		}
+		assert.Empty(t, api.storage.audit)
+	})
+}
+
+func TestRESTAPI_State(t *testing.T) {
+	feed := "/api/feeds/" + url.PathEscape("https://example.com/1")
+	api := newTestAPI(&testNotifier{})
+
+	w := api.request(http.MethodGet, feed+"/state", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"last_update": null}`, w.Body.String())
+
+	w = api.request(http.MethodPut, feed+"/state", `{"last_update": "2000-01-02T03:04:05Z"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"last_update": "2000-01-02T03:04:05Z"}`, w.Body.String())
+	assert.Equal(t, time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC), api.storage.updates["https://example.com/1"])
+
+	w = api.request(http.MethodPut, feed+"/state", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = api.request(http.MethodDelete, feed+"/state", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, api.storage.updates)
+
+	w = api.request(http.MethodGet, "/api/feeds/"+url.PathEscape("https://example.com/3")+"/state", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Len(t, api.storage.audit, 2)
+}
+
+func TestRESTAPI_Destinations(t *testing.T) {
+	api := newTestAPI(&testNotifier{})
+
+	w := api.request(http.MethodPost, "/api/destinations", `{"chat": "one", "feeds": ["https://example.com/1"]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = api.request(http.MethodPut, "/api/destinations/one", `{"message": {"silent": true}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"destination": {"chat": "one", "message": {"silent": true}}, "config": false}`, w.Body.String())
+
+	w = api.request(http.MethodGet, "/api/destinations", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[
+		{"destination": {"chat": "config", "message": {}}, "config": true},
+		{"destination": {"chat": "one", "message": {"silent": true}}, "config": false}
+	]`, w.Body.String())
+
+	w = api.request(http.MethodGet, "/api/destinations/one", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = api.request(http.MethodDelete, "/api/destinations/one", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = api.request(http.MethodGet, "/api/destinations/one", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = api.request(http.MethodDelete, "/api/destinations/config", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = api.request(http.MethodPost, "/api/destinations", `{"chat": "two", "feeds": ["https://example.com/3"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, api.storage.audit, 3)
+}
+
+func TestRESTAPI_Deliveries(t *testing.T) {
+	t.Run("pages", func(t *testing.T) {
+		api := newTestAPI(&testNotifier{})
+		for i := range 5 {
+			api.storage.deliveries = append(api.storage.deliveries, Delivery{ID: 5 - i})
+		}
+
+		w := api.request(http.MethodGet, "/api/deliveries?page=2&per_page=2", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page deliveryPage
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, deliveryPage{
+			Deliveries: []Delivery{{ID: 3}, {ID: 2}},
+			Page:       2,
+			PerPage:    2,
+			Total:      5,
+		}, page)
+
+		// Pages after the last one are empty
+		w = api.request(http.MethodGet, "/api/deliveries?page=4&per_page=2", "")
+		assert.Contains(t, w.Body.String(), `"deliveries":[]`)
+
+		for _, q := range []string{"page=0", "page=x", "per_page=0", "per_page=201"} {
+			w = api.request(http.MethodGet, "/api/deliveries?"+q, "")
+			assert.Equal(t, http.StatusBadRequest, w.Code, q)
+		}
+	})
+
+	t.Run("resend", func(t *testing.T) {
+		n := &testNotifier{}
+		api := newTestAPI(n)
+		api.storage.deliveries = []Delivery{{ID: 1, Item: Item{Link: "One"}}}
+
+		w := api.request(http.MethodPost, "/api/deliveries/1/resend", "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, []Item{{Link: "One"}}, n.items)
+
+		w = api.request(http.MethodPost, "/api/deliveries/2/resend", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("resend error", func(t *testing.T) {
+		api := newTestAPI(&testFailingNotifier{})
+		api.storage.deliveries = []Delivery{{ID: 1, Item: Item{Link: "One"}}}
+
+		w := api.request(http.MethodPost, "/api/deliveries/1/resend", "")
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Empty(t, api.storage.audit)
+	})
+}
+
+func TestRESTAPI_Auth(t *testing.T) {
+	api := newTestAPI(&testNotifier{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/feeds", nil)
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Changes from other sites are forbidden
+	req = httptest.NewRequest(http.MethodDelete, "http://example.com/api/destinations/one", nil)
+	req.SetBasicAuth("root", "secret")
+	req.Header.Set("Origin", "https://example.org")
+	w = httptest.NewRecorder()
+	api.handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRESTAPI_OpenAPI(t *testing.T) {
+	api := newTestAPI(&testNotifier{})
+
+	w := api.request(http.MethodGet, "/api/openapi.yaml", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var spec struct {
+		Paths map[string]map[string]any `yaml:"paths"`
+	}
+	assert.NoError(t, yaml.Unmarshal(w.Body.Bytes(), &spec))
+	// All routes are described
+	for path, methods := range map[string][]string{
+		"/api/feeds":                  {"get", "post"},
+		"/api/feeds/{url}":            {"get", "put", "delete"},
+		"/api/feeds/{url}/pause":      {"post"},
+		"/api/feeds/{url}/resume":     {"post"},
+		"/api/feeds/{url}/fetch":      {"post"},
+		"/api/feeds/{url}/state":      {"get", "put", "delete"},
+		"/api/destinations":           {"get", "post"},
+		"/api/destinations/{chat}":    {"get", "put", "delete"},
+		"/api/deliveries":             {"get"},
+		"/api/deliveries/{id}/resend": {"post"},
+	} {
+		for _, m := range methods {
+			assert.Contains(t, spec.Paths[path], m, path)
+		}
+	}
+}
+
+type testAPI struct {
+	handler http.Handler
+	feeds   *FeedRegistry
+	storage *testAdminStorage
+	control *testControl
+}
+
+func newTestAPI(n Notifier) *testAPI {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	conf := Config{Feeds: []Feed{{URL: "https://example.com/1"}, {URL: "https://example.com/2"}}}
+	feeds := NewFeedRegistry(conf, &testRegistryStorage{})
+	dests := NewDestinationRegistry([]Destination{{Chat: "config"}}, &testDestinationStorage{},
+		feeds.Has, testBuild(map[string]*testNotifier{}))
+	s := &testAdminStorage{}
+	control := newTestControl(feeds)
+
+	rest := NewRESTAPI(feeds, dests, s, control, n, log)
+	admin := AdminConfig{Editors: []Editor{{Username: "root", Password: "secret"}}}
+	a := NewAdmin(admin, feeds, control, s, n, log, WithAPI(rest.Handler()))
+	return &testAPI{handler: a.Handler(), feeds: feeds, storage: s, control: control}
+}
+
+func (a *testAPI) request(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("root", "secret")
+	w := httptest.NewRecorder()
+	a.handler.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxRedirectCache is a number of cached redirect targets. The cache is
+// cleared when it's full.
+const maxRedirectCache = 1000
+
+// httpRedirectHosts are hosts of redirects, that can be unwrapped only by
+// following them.
+var httpRedirectHosts = map[string]bool{
+	"feedproxy.google.com": true,
+	"feeds.feedburner.com": true,
+}
+
+// ReplaceRule is a regular expression substitution in the item field.
+// The replacement can refer to groups as $1 or ${name}.
+type ReplaceRule struct {
+	Field   string `json:"field" yaml:"field"`
+	Pattern string `json:"pattern" yaml:"pattern"`
+	With    string `json:"with" yaml:"with"`
+}
+
+// replaceRule is a compiled replace rule.
+type replaceRule struct {
+	field string
+	re    *regexp.Regexp
+	with  string
+}
+
+// Rewriter is a processor, that rewrites item links and other fields by
+// rules. Links are unwrapped from redirects first, then moved to mirror
+// hosts, then switched to https, and then all substitutions are applied.
+type Rewriter struct {
+	hosts   map[string]string
+	https   bool
+	unwrap  bool
+	replace []replaceRule
+	feedClients
+
+	// redirects caches targets of HTTP redirects.
+	redirects map[string]string
+	mx        *sync.Mutex
+}
+
+// NewRewriter creates a new rewriter from the processor settings.
+func NewRewriter(conf ProcessorConfig) (*Rewriter, error) {
+	rules, err := parseReplaceRules(conf.Replace)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]string, len(conf.Hosts))
+	for from, to := range conf.Hosts {
+		hosts[strings.ToLower(from)] = to
+	}
+	clients := newFeedClients()
+	checkRedirect := func(_ *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("too many redirects")
+		}
+		return nil
+	}
+	clients.client.CheckRedirect = checkRedirect
+	clients.public.CheckRedirect = checkRedirect
+	return &Rewriter{
+		hosts:       hosts,
+		https:       conf.HTTPS,
+		unwrap:      conf.Unwrap,
+		replace:     rules,
+		feedClients: clients,
+		redirects:   map[string]string{},
+		mx:          &sync.Mutex{},
+	}, nil
+}
+
+// parseReplaceRules compiles patterns of the rules.
+func parseReplaceRules(rules []ReplaceRule) ([]replaceRule, error) {
+	compiled := make([]replaceRule, len(rules))
+	for i, r := range rules {
+		if _, ok := itemField(&Item{}, r.Field); !ok {
+			return nil, fmt.Errorf("unknown field: %q", r.Field)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of %s: %w", r.Field, err)
+		}
+		compiled[i] = replaceRule{field: r.Field, re: re, with: r.With}
+	}
+	return compiled, nil
+}
+
+// Process rewrites the item.
+func (r *Rewriter) Process(ctx context.Context, item Item) ([]Item, error) {
+	if r.unwrap {
+		item.Link = r.unwrapLink(ctx, item.Feed, item.Link)
+	}
+	item.Link = r.rewriteHost(item.Link)
+	for _, rule := range r.replace {
+		field, _ := itemField(&item, rule.field)
+		*field = rule.re.ReplaceAllString(*field, rule.with)
+	}
+	return []Item{item}, nil
+}
+
+// rewriteHost replaces the link's host with its mirror, and forces https.
+func (r *Rewriter) rewriteHost(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	if mirror, ok := r.hosts[strings.ToLower(u.Host)]; ok {
+		u.Host = mirror
+	}
+	if r.https && u.Scheme == "http" {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+// unwrapLink returns the target of redirect links: tracking redirects with
+// the target in query parameters, Google News articles, and redirects,
+// that are resolved by following them. Links are returned as is if they
+// can't be unwrapped.
+func (r *Rewriter) unwrapLink(ctx context.Context, feed, link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	for range 3 {
+		target := redirectTarget(u)
+		if target == nil {
+			target = googleNewsTarget(u)
+		}
+		if target == nil {
+			break
+		}
+		u = target
+	}
+	if httpRedirectHosts[strings.ToLower(u.Host)] {
+		return r.follow(ctx, feed, u.String())
+	}
+	return u.String()
+}
+
+// follow returns the final URL of the redirect chain.
+func (r *Rewriter) follow(ctx context.Context, feed, link string) string {
+	// Redirects, that were followed for other feeds, may be private
+	key := link
+	if r.isRestricted(feed) {
+		key = "public " + link
+	}
+	r.mx.Lock()
+	target, ok := r.redirects[key]
+	r.mx.Unlock()
+	if ok {
+		return target
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, link, nil)
+	if err != nil {
+		return link
+	}
+	resp, err := r.clientOf(feed).Do(req)
+	if err != nil {
+		return link
+	}
+	resp.Body.Close()
+	target = resp.Request.URL.String()
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if len(r.redirects) >= maxRedirectCache {
+		clear(r.redirects)
+	}
+	r.redirects[key] = target
+	return target
+}
+
+// googleNewsTarget decodes the article URL from Google News link. Only
+// links, that have the URL encoded in the article ID, are supported.
+func googleNewsTarget(u *url.URL) *url.URL {
+	if strings.ToLower(u.Host) != "news.google.com" {
+		return nil
+	}
+	_, id, ok := strings.Cut(u.Path, "/articles/")
+	if !ok {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(id, "="))
+	if err != nil {
+		return nil
+	}
+	start := bytes.Index(data, []byte("http"))
+	if start < 0 {
+		return nil
+	}
+	end := start
+	for end < len(data) && data[end] > ' ' && data[end] < 0x7f {
+		end++
+	}
+	target, err := url.Parse(string(data[start:end]))
+	if err != nil || target.Host == "" {
+		return nil
+	}
+	return target
+}
+
+// itemField returns a pointer to the item's text field by its name.
+func itemField(item *Item, name string) (*string, bool) {
+	switch name {
+	case "link":
+		return &item.Link, true
+	case "title":
+		return &item.Title, true
+	case "description":
+		return &item.Description, true
+	case "comments":
+		return &item.Comments, true
+	case "category":
+		return &item.Category, true
+	case "feed_title":
+		return &item.FeedTitle, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriter_Process(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("hosts", func(t *testing.T) {
+		r, err := NewRewriter(ProcessorConfig{
+			Hosts: map[string]string{"Twitter.com": "nitter.net"},
+			HTTPS: true,
+		})
+		assert.NoError(t, err)
+
+		for in, out := range map[string]string{
+			"https://twitter.com/user/status/1": "https://nitter.net/user/status/1",
+			"http://TWITTER.com/user?x=1#top":   "https://nitter.net/user?x=1#top",
+			"http://example.com/page":           "https://example.com/page",
+			"ftp://example.com/file":            "ftp://example.com/file",
+			"not a link":                        "not a link",
+		} {
+			items, err := r.Process(ctx, Item{Link: in})
+			assert.NoError(t, err)
+			assert.Equal(t, []Item{{Link: out}}, items, in)
+		}
+	})
+
+	t.Run("unwrap", func(t *testing.T) {
+		r, err := NewRewriter(ProcessorConfig{Unwrap: true})
+		assert.NoError(t, err)
+
+		id := base64.RawURLEncoding.EncodeToString(
+			[]byte("\x08\x13\x22\x1bhttps://example.com/article\xd2\x01\x00"),
+		)
+		for in, out := range map[string]string{
+			"https://www.google.com/url?q=https%3A%2F%2Fexample.com%2Fpage": "https://example.com/page",
+			"https://news.google.com/rss/articles/" + id + "?oc=5":          "https://example.com/article",
+			"https://news.google.com/rss/articles/AU_yqLmagic":              "https://news.google.com/rss/articles/AU_yqLmagic",
+			"https://example.com/page":                                      "https://example.com/page",
+		} {
+			items, err := r.Process(ctx, Item{Link: in})
+			assert.NoError(t, err)
+			assert.Equal(t, []Item{{Link: out}}, items, in)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		r, err := NewRewriter(ProcessorConfig{Replace: []ReplaceRule{
+			{Field: "title", Pattern: `^\[AD\] `},
+			{Field: "link", Pattern: `\?utm_source=\w+$`},
+			{Field: "description", Pattern: `(\d+) points`, With: "$1 ★"},
+		}})
+		assert.NoError(t, err)
+
+		items, err := r.Process(ctx, Item{
+			Title:       "[AD] Title",
+			Link:        "https://example.com/page?utm_source=rss",
+			Description: "Score: 10 points",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{{
+			Title:       "Title",
+			Link:        "https://example.com/page",
+			Description: "Score: 10 ★",
+		}}, items)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		_, err := NewRewriter(ProcessorConfig{Replace: []ReplaceRule{{Field: "body"}}})
+		assert.EqualError(t, err, `unknown field: "body"`)
+
+		_, err = NewRewriter(ProcessorConfig{Replace: []ReplaceRule{{Field: "title", Pattern: "("}}})
+		assert.EqualError(t, err, "invalid pattern of title: error parsing regexp: missing closing ): `(`")
+	})
+}
+
+func TestRewriter_Follow(t *testing.T) {
+	ctx := context.Background()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		if req.URL.Path == "/redirect" {
+			http.Redirect(w, req, "/article", http.StatusMovedPermanently)
+		}
+	}))
+	defer server.Close()
+
+	r, err := NewRewriter(ProcessorConfig{Unwrap: true})
+	assert.NoError(t, err)
+
+	assert.Equal(t, server.URL+"/article", r.follow(ctx, "f1", server.URL+"/redirect"))
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Targets are cached
+	assert.Equal(t, server.URL+"/article", r.follow(ctx, "f1", server.URL+"/redirect"))
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Failed links are returned as is
+	link := (&url.URL{Scheme: "http", Host: "localhost:1", Path: "/redirect"}).String()
+	assert.Equal(t, link, r.follow(ctx, "f1", link))
+
+	// Restricted feeds can't reach local addresses, and don't get
+	// cached targets of other feeds
+	r.Restrict(func(feed string) bool { return feed == "users" })
+	assert.Equal(t, server.URL+"/redirect", r.follow(ctx, "users", server.URL+"/redirect"))
+	assert.Equal(t, int32(2), requests.Load())
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Router is a notifier, that sends items to destinations subscribed to
+// the item's feed.
+type Router struct {
+	routes []route
+}
+
+// route is a destination notifier with a set of its feeds. Empty set
+// means all feeds, unless the route has a match function.
+type route struct {
+	notifier Notifier
+	feeds    map[string]bool
+	match    func(feed string) bool
+}
+
+// accepts reports whether the route receives items of the feed.
+func (rt route) accepts(feed string) bool {
+	if rt.match != nil {
+		return rt.match(feed)
+	}
+	return len(rt.feeds) == 0 || rt.feeds[feed]
+}
+
+// NewRouter creates new router without destinations.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Add adds a destination for the feeds. Empty list means all feeds.
+func (r *Router) Add(n Notifier, feeds []string) {
+	rt := route{notifier: n, feeds: make(map[string]bool, len(feeds))}
+	for _, f := range feeds {
+		rt.feeds[f] = true
+	}
+	r.routes = append(r.routes, rt)
+}
+
+// AddFilter adds a destination for feeds, that match the function.
+func (r *Router) AddFilter(n Notifier, match func(feed string) bool) {
+	r.routes = append(r.routes, route{notifier: n, match: match})
+}
+
+// Notify sends the item to all destinations of its feed. Items without
+// a feed are sent to all destinations.
+func (r *Router) Notify(ctx context.Context, item Item) error {
+	var errs []error
+	for _, rt := range r.routes {
+		if item.Feed != "" && !rt.accepts(item.Feed) {
+			continue
+		}
+		if err := rt.notifier.Notify(ctx, item); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Update passes items of the feed to all its destinations.
+func (r *Router) Update(ctx context.Context, feed string, current, processed []Item) error {
+	var errs []error
+	for _, rt := range r.routes {
+		if !rt.accepts(feed) {
+			continue
+		}
+		if err := update(ctx, rt.notifier, feed, current, processed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run starts background loops of all destinations that have them, and
+// waits for them to stop.
+func (r *Router) Run(ctx context.Context) {
+	for _, rt := range r.routes {
+		defer startRunner(ctx, rt.notifier)()
+	}
+	<-ctx.Done()
+}
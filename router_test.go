@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_Notify(t *testing.T) {
+	all := &testNotifier{}
+	selected := &testNotifier{}
+	filtered := &testNotifier{}
+	r := NewRouter()
+	r.Add(all, nil)
+	r.Add(selected, []string{"f1"})
+	r.AddFilter(filtered, func(feed string) bool { return feed == "f2" })
+
+	ctx := context.Background()
+	assert.NoError(t, r.Notify(ctx, Item{Feed: "f1", Link: "one"}))
+	assert.NoError(t, r.Notify(ctx, Item{Feed: "f2", Link: "two"}))
+	assert.NoError(t, r.Notify(ctx, Item{Link: "three"}))
+
+	assert.Equal(t, []Item{
+		{Feed: "f1", Link: "one"},
+		{Feed: "f2", Link: "two"},
+		{Link: "three"},
+	}, all.items)
+	assert.Equal(t, []Item{
+		{Feed: "f1", Link: "one"},
+		{Link: "three"},
+	}, selected.items)
+	assert.Equal(t, []Item{
+		{Feed: "f2", Link: "two"},
+		{Link: "three"},
+	}, filtered.items)
+
+	r.Add(&testFailingNotifier{}, nil)
+	assert.EqualError(t, r.Notify(ctx, Item{Link: "four"}), "fail")
+}
+
+func TestRouter_Update(t *testing.T) {
+	all := &testUpdater{}
+	selected := &testUpdater{}
+	r := NewRouter()
+	r.Add(all, nil)
+	r.Add(selected, []string{"f1"})
+	r.Add(&testNotifier{}, nil)
+
+	ctx := context.Background()
+	assert.NoError(t, r.Update(ctx, "f1", nil, []Item{{Link: "one"}}))
+	assert.NoError(t, r.Update(ctx, "f2", nil, []Item{{Link: "two"}}))
+
+	assert.Equal(t, map[string][]Item{
+		"f1": {{Link: "one"}},
+		"f2": {{Link: "two"}},
+	}, all.updates)
+	assert.Equal(t, map[string][]Item{
+		"f1": {{Link: "one"}},
+	}, selected.updates)
+}
+
+func TestRouter_Run(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	n := &testNotifier{}
+	d, err := NewDigest("chat", n, &testQueueStorage{}, DigestConfig{Schedule: "@daily"}, log)
+	assert.NoError(t, err)
+	d.schedule = testSchedule(10 * time.Millisecond)
+
+	r := NewRouter()
+	r.Add(d, nil)
+	assert.NoError(t, r.Notify(context.Background(), Item{Link: "one"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+	defer cancel()
+	r.Run(ctx)
+
+	assert.Len(t, n.items, 1)
+}
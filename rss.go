@@ -1,16 +1,29 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
 )
 
 // Default HTTP client timeout.
 const timeout = 3 * time.Second
 
+// ErrPrivateAddress is returned for requests of restricted feeds to
+// addresses, that are not public.
+var ErrPrivateAddress = errors.New("private address")
+
 // Storage describes persistent datastorage.
 type Storage interface {
 	GetLastUpdate(feed string) time.Time
@@ -19,80 +32,450 @@ type Storage interface {
 
 // Item is a single fetched item.
 type Item struct {
-	Published time.Time
-	Link      string
+	// GUID is a unique ID of the item within its feed. If the feed doesn't
+	// provide IDs, the link is used.
+	GUID string `json:"guid,omitempty" yaml:"guid,omitempty"`
+
+	Published   time.Time `json:"published" yaml:"published"`
+	Link        string    `json:"link,omitempty" yaml:"link,omitempty"`
+	Title       string    `json:"title,omitempty" yaml:"title,omitempty"`
+	Description string    `json:"description,omitempty" yaml:"description,omitempty"`
+	Comments    string    `json:"comments,omitempty" yaml:"comments,omitempty"`
+
+	// Feed is the URL of the source feed, FeedTitle is its human-readable
+	// name, and FeedLink is the URL of its website.
+	Feed      string `json:"feed,omitempty" yaml:"feed,omitempty"`
+	FeedTitle string `json:"feed_title,omitempty" yaml:"feed_title,omitempty"`
+	FeedLink  string `json:"feed_link,omitempty" yaml:"feed_link,omitempty"`
+	Category  string `json:"category,omitempty" yaml:"category,omitempty"`
+
+	// Images are URLs of the item's images, and Enclosures are other
+	// attached media files, like podcast episodes.
+	Images     []string    `json:"images,omitempty" yaml:"images,omitempty"`
+	Enclosures []Enclosure `json:"enclosures,omitempty" yaml:"enclosures,omitempty"`
+
+	// Text is sent instead of the link when set. It is used for service
+	// messages, that don't represent a single feed item.
+	Text string `json:"text,omitempty" yaml:"text,omitempty"`
+
+	// Original is the item's text before translation.
+	Original *Original `json:"original,omitempty" yaml:"original,omitempty"`
+
+	// Article is the main content of the item's page.
+	Article *Article `json:"article,omitempty" yaml:"article,omitempty"`
+
+	// Meta is arbitrary metadata added by plugins.
+	Meta map[string]string `json:"meta,omitempty" yaml:"meta,omitempty"`
+}
+
+// Original is the item's title and description in the source language.
+type Original struct {
+	Language    string `json:"language,omitempty" yaml:"language,omitempty"`
+	Title       string `json:"title,omitempty" yaml:"title,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// Enclosure is a media file attached to an item.
+type Enclosure struct {
+	URL  string `json:"url" yaml:"url"`
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
 }
 
 func (i Item) String() string {
+	body := i.Link
+	if i.Text != "" {
+		body = i.Text
+	}
 	return fmt.Sprintf("[%s] %s",
 		i.Published.Format("2006-01-02 15:04"),
-		i.Link)
+		body)
+}
+
+// CatchUp is a policy of posting items from a feed, that is fetched for
+// the first time. Zero value means skipping all existing items.
+type CatchUp struct {
+	// Last is a number of latest items to post.
+	Last int
+	// Since is a max age of items to post.
+	Since time.Duration
+}
+
+// ParseCatchUp parses catch-up policy from one of the forms: "skip",
+// "last <N>", "since <duration>". Empty string is the same as "skip".
+func ParseCatchUp(s string) (CatchUp, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(s), " ")
+	arg = strings.TrimSpace(arg)
+	switch kind {
+	case "", "skip":
+		if arg != "" {
+			return CatchUp{}, errors.New("skip policy has no arguments")
+		}
+		return CatchUp{}, nil
+	case "last":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return CatchUp{}, errors.New("last policy requires a positive number")
+		}
+		return CatchUp{Last: n}, nil
+	case "since":
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return CatchUp{}, errors.New("since policy requires a positive duration")
+		}
+		return CatchUp{Since: d}, nil
+	default:
+		return CatchUp{}, fmt.Errorf("unknown policy: %s", kind)
+	}
+}
+
+// accepts checks if the i-th newest item should be posted.
+func (c CatchUp) accepts(i int, item Item, now time.Time) bool {
+	switch {
+	case c.Last > 0:
+		return i < c.Last
+	case c.Since > 0:
+		return item.Published.After(now.Add(-c.Since))
+	default:
+		return false
+	}
 }
 
 // RSSFetcher reads data from RSS feed.
 type RSSFetcher struct {
+	feedClients
 	storage Storage
-	parser  *gofeed.Parser
 }
 
 // NewRSSFetcher returns new RSS feed.
 func NewRSSFetcher(s Storage) *RSSFetcher {
-	p := gofeed.NewParser()
-	p.Client = &http.Client{Timeout: timeout}
 	return &RSSFetcher{
-		parser:  p,
-		storage: s,
+		feedClients: newFeedClients(),
+		storage:     s,
 	}
 }
 
-// Fetch fetches last item from RSS feed.
-func (f *RSSFetcher) Fetch(url string) ([]Item, error) {
-	last := f.storage.GetLastUpdate(url)
-	if last.IsZero() {
-		// First access
-		if err := f.storage.SaveLastUpdate(url, time.Now()); err != nil {
-			return nil, fmt.Errorf("save last update time: %w", err)
+// feedClients are HTTP clients for requests of feeds and their items.
+// Restricted feeds are requested with the public client, that can't
+// connect to private addresses.
+type feedClients struct {
+	client     *http.Client
+	public     *http.Client
+	restricted func(feed string) bool
+}
+
+func newFeedClients() feedClients {
+	return feedClients{
+		client: &http.Client{Timeout: timeout},
+		public: newPublicClient(),
+	}
+}
+
+// Restrict makes requests of feeds, that match the function, and of their
+// items go only to public addresses. It's used for feeds of users, so they
+// can't make the bot send requests to its local network. Proxy settings
+// from the environment are not used for these requests.
+func (c *feedClients) Restrict(match func(feed string) bool) {
+	c.restricted = match
+}
+
+// isRestricted reports whether requests of the feed are restricted.
+func (c *feedClients) isRestricted(feed string) bool {
+	return c.restricted != nil && c.restricted(feed)
+}
+
+// clientOf returns the client for requests of the feed.
+func (c *feedClients) clientOf(feed string) *http.Client {
+	if c.isRestricted(feed) {
+		return c.public
+	}
+	return c.client
+}
+
+// newPublicClient creates an HTTP client, that connects only to public
+// addresses. Addresses are checked after resolving, so it covers DNS names
+// and redirects as well.
+func newPublicClient() *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: checkPublicAddress}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
+
+// checkPublicAddress rejects connections to loopback, private, link-local
+// and other non-global addresses.
+func checkPublicAddress(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, ip)
+	}
+	return nil
+}
+
+// Fetch fetches new items from RSS feed, and all current items of the feed
+// for updating sent messages. Items are returned newest first.
+//
+// When the feed is fetched for the first time, its catch-up policy defines
+// which of the existing items are returned. When there are more new items
+// than the feed's limit, only the newest ones are returned, followed by
+// a summary of the rest.
+func (f *RSSFetcher) Fetch(feed Feed) (items, all []Item, err error) {
+	now := time.Now()
+	last := f.storage.GetLastUpdate(feed.URL)
+	first := last.IsZero()
+
+	catchUp, err := ParseCatchUp(feed.CatchUp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse catch-up policy: %w", err)
+	}
+	if first && catchUp == (CatchUp{}) {
+		// First access, skip everything
+		if err := f.storage.SaveLastUpdate(feed.URL, now); err != nil {
+			return nil, nil, fmt.Errorf("save last update time: %w", err)
 		}
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	feed, err := f.parser.ParseURL(url)
+	all, err = f.Parse(feed.URL)
 	if err != nil {
-		return nil, fmt.Errorf("parse url: %w", err)
+		return nil, nil, err
 	}
-	if len(feed.Items) == 0 {
-		return nil, nil
+	for i := range all {
+		all[i] = feed.annotate(all[i])
 	}
 
-	var items []Item //nolint: prealloc
-	for _, fitem := range feed.Items {
-		item := parse(fitem)
-		if !item.Published.After(last) {
+	for i, item := range all {
+		if first && !catchUp.accepts(i, item, now) {
+			break
+		}
+		if !first && !item.Published.After(last) {
 			break
 		}
 		items = append(items, item)
 	}
 	if len(items) == 0 {
-		return nil, nil
+		if first {
+			if err := f.storage.SaveLastUpdate(feed.URL, now); err != nil {
+				return nil, nil, fmt.Errorf("save last update time: %w", err)
+			}
+		}
+		return nil, all, nil
+	}
+
+	if err := f.storage.SaveLastUpdate(feed.URL, items[0].Published); err != nil {
+		return nil, nil, fmt.Errorf("save last update time: %w", err)
+	}
+
+	if feed.MaxItems > 0 && len(items) > feed.MaxItems {
+		rest := items[feed.MaxItems:]
+		items = append(items[:feed.MaxItems:feed.MaxItems], summary(rest))
+	}
+	return items, all, nil
+}
+
+// annotate sets the item fields, that are defined in the feed config.
+func (f Feed) annotate(item Item) Item {
+	if f.Title != "" {
+		item.FeedTitle = f.Title
+	}
+	item.Category = f.Category
+	return item
+}
+
+// summary creates a service item, that replaces skipped items.
+func summary(skipped []Item) Item {
+	src := skipped[0].FeedTitle
+	if src == "" {
+		src = skipped[0].Feed
+	}
+	return Item{
+		Published: skipped[0].Published,
+		Feed:      skipped[0].Feed,
+		FeedTitle: skipped[0].FeedTitle,
+		Category:  skipped[0].Category,
+		Text:      fmt.Sprintf("...and %d more from %s", len(skipped), src),
 	}
+}
 
-	if err := f.storage.SaveLastUpdate(url, items[0].Published); err != nil {
-		return nil, fmt.Errorf("save last update time: %w", err)
+// Parse fetches and parses all items from RSS feed without looking at
+// the stored state. Items are sorted newest first.
+func (f *RSSFetcher) Parse(url string) ([]Item, error) {
+	// Parser is not safe for concurrent use, so each fetch gets its own
+	p := gofeed.NewParser()
+	p.Client = f.clientOf(url)
+	p.RSSTranslator = &rssTranslator{}
+	feed, err := p.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	items := make([]Item, len(feed.Items))
+	for i, fitem := range feed.Items {
+		items[i] = parse(fitem)
+		items[i].Feed = url
+		items[i].FeedTitle = feed.Title
+		items[i].FeedLink = feed.Link
 	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Published.After(items[j].Published)
+	})
 	return items, nil
 }
 
+// FeedReport is a result of probing a feed.
+type FeedReport struct {
+	URL    string
+	Items  int
+	Oldest time.Time
+	Newest time.Time
+	Err    error
+}
+
+func (r FeedReport) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("FAIL %s: %v", r.URL, r.Err)
+	}
+	if r.Items == 0 {
+		return fmt.Sprintf("OK   %s: no items", r.URL)
+	}
+	return fmt.Sprintf("OK   %s: %d items from %s to %s",
+		r.URL, r.Items,
+		r.Oldest.Format("2006-01-02 15:04"),
+		r.Newest.Format("2006-01-02 15:04"))
+}
+
+// Probe fetches the feed once without looking at the stored state, and
+// reports its parse status, number of items and their dates.
+func (f *RSSFetcher) Probe(url string) FeedReport {
+	report := FeedReport{URL: url}
+	items, err := f.Parse(url)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	report.Items = len(items)
+	for _, item := range items {
+		if report.Oldest.IsZero() || item.Published.Before(report.Oldest) {
+			report.Oldest = item.Published
+		}
+		if item.Published.After(report.Newest) {
+			report.Newest = item.Published
+		}
+	}
+	return report
+}
+
+// ProbeFeeds probes all feeds concurrently. Reports are returned in the
+// same order as feeds.
+func (f *RSSFetcher) ProbeFeeds(feeds []Feed) []FeedReport {
+	reports := make([]FeedReport, len(feeds))
+	var wg sync.WaitGroup
+	wg.Add(len(feeds))
+	for i, feed := range feeds {
+		go func() {
+			reports[i] = f.Probe(feed.URL)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	return reports
+}
+
 func parse(in *gofeed.Item) Item {
 	item := Item{
-		Link:      in.Link,
-		Published: time.Now(),
+		GUID:        in.GUID,
+		Link:        in.Link,
+		Title:       in.Title,
+		Description: in.Description,
+		Comments:    in.Custom["comments"],
+		Published:   time.Now(),
 	}
 
+	if item.GUID == "" {
+		item.GUID = in.Link
+	}
 	if in.PublishedParsed != nil {
 		item.Published = *in.PublishedParsed
 	} else if in.UpdatedParsed != nil {
 		item.Published = *in.UpdatedParsed
 	}
+	item.Images, item.Enclosures = parseMedia(in)
 
 	return item
 }
+
+// parseMedia collects images and other media files from the item's image,
+// enclosures and Media RSS extension.
+func parseMedia(in *gofeed.Item) ([]string, []Enclosure) {
+	var images []string
+	var enclosures []Enclosure
+	seen := map[string]bool{}
+	add := func(url, typ, medium string) {
+		if url == "" || seen[url] {
+			return
+		}
+		seen[url] = true
+		if medium == "image" || strings.HasPrefix(typ, "image/") {
+			images = append(images, url)
+			return
+		}
+		if typ == "" && medium != "" {
+			typ = medium
+		}
+		enclosures = append(enclosures, Enclosure{URL: url, Type: typ})
+	}
+
+	if in.Image != nil {
+		add(in.Image.URL, "", "image")
+	}
+	for _, e := range in.Enclosures {
+		add(e.URL, e.Type, "")
+	}
+	media := in.Extensions["media"]
+	contents := media["content"]
+	for _, g := range media["group"] {
+		contents = append(contents, g.Children["content"]...)
+	}
+	for _, c := range contents {
+		add(c.Attrs["url"], c.Attrs["type"], c.Attrs["medium"])
+	}
+
+	return images, enclosures
+}
+
+// rssTranslator extends the default translator with RSS fields, that are
+// missing in the universal feed format. They are put to custom fields.
+type rssTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+func (t *rssTranslator) Translate(in any) (*gofeed.Feed, error) {
+	feed, err := t.DefaultRSSTranslator.Translate(in)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	src, ok := in.(*rss.Feed)
+	if !ok || len(src.Items) != len(feed.Items) {
+		return feed, nil
+	}
+	for i, item := range src.Items {
+		if item.Comments == "" {
+			continue
+		}
+		if feed.Items[i].Custom == nil {
+			feed.Items[i].Custom = map[string]string{}
+		}
+		feed.Items[i].Custom["comments"] = item.Comments
+	}
+	return feed, nil
+}
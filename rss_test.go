@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -19,13 +20,17 @@ func TestFetcher(t *testing.T) {
 		defer server.Close()
 
 		f := NewRSSFetcher(storage)
-		items, err := f.Fetch(server.URL)
+		items, _, err := f.Fetch(Feed{URL: server.URL})
 		assert.NoError(t, err)
 		assert.Len(t, items, 1)
 
 		expected := Item{
+			GUID:      "item_id",
 			Link:      "https://example.com/content/",
+			Title:     "Content",
 			Published: time.Date(2020, 1, 1, 15, 0, 0, 0, time.UTC),
+			Feed:      server.URL,
+			FeedTitle: "Feed",
 		}
 		assert.Equal(t, expected, items[0])
 	})
@@ -39,9 +44,11 @@ func TestFetcher(t *testing.T) {
 		}
 
 		f := NewRSSFetcher(storage)
-		items, err := f.Fetch(server.URL)
+		items, all, err := f.Fetch(Feed{URL: server.URL, Title: "Custom"})
 		assert.NoError(t, err)
 		assert.Len(t, items, 0)
+		assert.Len(t, all, 1)
+		assert.Equal(t, "Custom", all[0].FeedTitle)
 	})
 
 	t.Run("no items", func(t *testing.T) {
@@ -49,7 +56,7 @@ func TestFetcher(t *testing.T) {
 		defer server.Close()
 
 		f := NewRSSFetcher(storage)
-		items, err := f.Fetch(server.URL)
+		items, _, err := f.Fetch(Feed{URL: server.URL})
 		assert.NoError(t, err)
 		assert.Len(t, items, 0)
 	})
@@ -61,7 +68,7 @@ func TestFetcher(t *testing.T) {
 		storage := &testStorage{}
 
 		f := NewRSSFetcher(storage)
-		items, err := f.Fetch(server.URL)
+		items, _, err := f.Fetch(Feed{URL: server.URL})
 		assert.NoError(t, err)
 		assert.Len(t, items, 0)
 	})
@@ -71,19 +78,269 @@ func TestFetcher(t *testing.T) {
 		defer server.Close()
 
 		f := NewRSSFetcher(storage)
-		_, err := f.Fetch(server.URL)
+		_, _, err := f.Fetch(Feed{URL: server.URL})
 		assert.EqualError(t, err,
 			"parse url: http error: 500 Internal Server Error")
 	})
 
 	t.Run("invalid url", func(t *testing.T) {
 		f := NewRSSFetcher(storage)
-		_, err := f.Fetch("xxx://example.com")
+		_, _, err := f.Fetch(Feed{URL: "xxx://example.com"})
 		assert.EqualError(t, err,
 			`parse url: Get "xxx://example.com": unsupported protocol scheme "xxx"`)
 	})
 }
 
+func TestRSSFetcher_Parse(t *testing.T) {
+	server := httptest.NewServer(&testRSSServer{data: true})
+	defer server.Close()
+
+	// Parse doesn't touch the storage
+	f := NewRSSFetcher(nil)
+	items, err := f.Parse(server.URL)
+	assert.NoError(t, err)
+
+	expected := []Item{{
+		GUID:      "item_id",
+		Link:      "https://example.com/content/",
+		Title:     "Content",
+		Published: time.Date(2020, 1, 1, 15, 0, 0, 0, time.UTC),
+		Feed:      server.URL,
+		FeedTitle: "Feed",
+	}}
+	assert.Equal(t, expected, items)
+}
+
+func TestRSSFetcher_Restrict(t *testing.T) {
+	server := httptest.NewServer(&testRSSServer{data: true})
+	defer server.Close()
+
+	f := NewRSSFetcher(nil)
+	f.Restrict(func(url string) bool { return url == server.URL })
+
+	_, err := f.Parse(server.URL)
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+	// Other feeds are not restricted
+	_, err = f.Parse(server.URL + "/rss")
+	assert.NoError(t, err)
+}
+
+func TestCheckPublicAddress(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34:443":       true,
+		"[2606:2800:220:1::]:443": true,
+		"127.0.0.1:80":            false,
+		"10.0.0.1:80":             false,
+		"192.168.1.1:80":          false,
+		"169.254.169.254:80":      false,
+		"0.0.0.0:80":              false,
+		"[::1]:80":                false,
+		"[fe80::1]:80":            false,
+		"[fd00::1]:80":            false,
+		"[::ffff:127.0.0.1]:80":   false,
+	} {
+		err := checkPublicAddress("tcp", addr, nil)
+		if public {
+			assert.NoError(t, err, addr)
+		} else {
+			assert.ErrorIs(t, err, ErrPrivateAddress, addr)
+		}
+	}
+}
+
+func TestRSSFetcher_Parse_Sorted(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(&testAtomServer{dates: []time.Time{
+		ts.Add(time.Hour),
+		ts,
+		ts.Add(2 * time.Hour),
+	}})
+	defer server.Close()
+
+	items, err := NewRSSFetcher(nil).Parse(server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, items, 3)
+	assert.Equal(t, ts.Add(2*time.Hour), items[0].Published)
+	assert.Equal(t, ts.Add(time.Hour), items[1].Published)
+	assert.Equal(t, ts, items[2].Published)
+}
+
+func TestRSSFetcher_Parse_Media(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>` +
+			`<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/"><channel>` +
+			`<title>Podcast</title>` +
+			`<link>https://example.com/</link>` +
+			`<item>` +
+			`<link>https://example.com/ep1</link>` +
+			`<comments>https://example.com/ep1#comments</comments>` +
+			`<pubDate>Wed, 01 Jan 2020 15:00:00 GMT</pubDate>` +
+			`<enclosure url="https://example.com/ep1.mp3" type="audio/mpeg" length="1"/>` +
+			`<enclosure url="https://example.com/cover.jpg" type="image/jpeg" length="1"/>` +
+			`<media:content url="https://example.com/photo.jpg" medium="image"/>` +
+			`<media:group><media:content url="https://example.com/ep1.mp4" type="video/mp4"/></media:group>` +
+			`</item>` +
+			`</channel></rss>`))
+	}))
+	defer server.Close()
+
+	items, err := NewRSSFetcher(nil).Parse(server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "https://example.com/", items[0].FeedLink)
+	assert.Equal(t, "https://example.com/ep1#comments", items[0].Comments)
+	assert.ElementsMatch(t,
+		[]string{"https://example.com/cover.jpg", "https://example.com/photo.jpg"},
+		items[0].Images)
+	assert.Equal(t, []Enclosure{
+		{URL: "https://example.com/ep1.mp3", Type: "audio/mpeg"},
+		{URL: "https://example.com/ep1.mp4", Type: "video/mp4"},
+	}, items[0].Enclosures)
+}
+
+func TestRSSFetcher_ProbeFeeds(t *testing.T) {
+	server := httptest.NewServer(&testRSSServer{data: true})
+	defer server.Close()
+	empty := httptest.NewServer(&testRSSServer{data: false})
+	defer empty.Close()
+	failing := httptest.NewServer(&testRSSServer{err: true})
+	defer failing.Close()
+
+	f := NewRSSFetcher(nil)
+	reports := f.ProbeFeeds([]Feed{
+		{URL: server.URL},
+		{URL: empty.URL},
+		{URL: failing.URL},
+	})
+
+	ts := time.Date(2020, 1, 1, 15, 0, 0, 0, time.UTC)
+	assert.Len(t, reports, 3)
+	assert.Equal(t, FeedReport{URL: server.URL, Items: 1, Oldest: ts, Newest: ts}, reports[0])
+	assert.Equal(t, FeedReport{URL: empty.URL}, reports[1])
+	assert.EqualError(t, reports[2].Err, "parse url: http error: 500 Internal Server Error")
+
+	assert.Equal(t, "OK   "+empty.URL+": no items", reports[1].String())
+	assert.Equal(t, "FAIL "+failing.URL+": parse url: http error: 500 Internal Server Error",
+		reports[2].String())
+}
+
+func TestRSSFetcher_Fetch_CatchUp(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	server := httptest.NewServer(&testAtomServer{dates: []time.Time{
+		now.Add(-1 * time.Hour),
+		now.Add(-2 * time.Hour),
+		now.Add(-3 * time.Hour),
+		now.Add(-4 * time.Hour),
+	}})
+	defer server.Close()
+
+	t.Run("skip", func(t *testing.T) {
+		storage := &testStorage{}
+		items, _, err := NewRSSFetcher(storage).Fetch(Feed{URL: server.URL, CatchUp: "skip"})
+		assert.NoError(t, err)
+		assert.Len(t, items, 0)
+		assert.False(t, storage.saved.IsZero())
+	})
+
+	t.Run("last", func(t *testing.T) {
+		storage := &testStorage{}
+		items, _, err := NewRSSFetcher(storage).Fetch(Feed{URL: server.URL, CatchUp: "last 2"})
+		assert.NoError(t, err)
+		assert.Len(t, items, 2)
+		assert.Equal(t, now.Add(-1*time.Hour), storage.saved)
+	})
+
+	t.Run("since", func(t *testing.T) {
+		storage := &testStorage{}
+		items, _, err := NewRSSFetcher(storage).Fetch(Feed{URL: server.URL, CatchUp: "since 150m"})
+		assert.NoError(t, err)
+		assert.Len(t, items, 2)
+		assert.Equal(t, now.Add(-1*time.Hour), storage.saved)
+	})
+
+	t.Run("since with no matching items", func(t *testing.T) {
+		storage := &testStorage{}
+		items, _, err := NewRSSFetcher(storage).Fetch(Feed{URL: server.URL, CatchUp: "since 30m"})
+		assert.NoError(t, err)
+		assert.Len(t, items, 0)
+		assert.True(t, storage.saved.After(now.Add(-time.Minute)))
+	})
+
+	t.Run("max items", func(t *testing.T) {
+		storage := &testStorage{time: now.Add(-24 * time.Hour)}
+		feed := Feed{URL: server.URL, Title: "Example", MaxItems: 1}
+		items, _, err := NewRSSFetcher(storage).Fetch(feed)
+		assert.NoError(t, err)
+		assert.Len(t, items, 2)
+		assert.Equal(t, now.Add(-1*time.Hour), items[0].Published)
+		assert.Equal(t, "Example", items[0].FeedTitle)
+
+		expected := Item{
+			Published: now.Add(-2 * time.Hour),
+			Feed:      server.URL,
+			FeedTitle: "Example",
+			Text:      "...and 3 more from Example",
+		}
+		assert.Equal(t, expected, items[1])
+		assert.Equal(t, now.Add(-1*time.Hour), storage.saved)
+	})
+
+	t.Run("invalid policy", func(t *testing.T) {
+		_, _, err := NewRSSFetcher(&testStorage{}).Fetch(Feed{URL: server.URL, CatchUp: "all"})
+		assert.EqualError(t, err, "parse catch-up policy: unknown policy: all")
+	})
+}
+
+func TestParseCatchUp(t *testing.T) {
+	testCases := []struct {
+		in      string
+		catchUp CatchUp
+		err     string
+	}{
+		{in: "", catchUp: CatchUp{}},
+		{in: "skip", catchUp: CatchUp{}},
+		{in: "last 5", catchUp: CatchUp{Last: 5}},
+		{in: " since  24h ", catchUp: CatchUp{Since: 24 * time.Hour}},
+		{in: "skip 5", err: "skip policy has no arguments"},
+		{in: "last", err: "last policy requires a positive number"},
+		{in: "last -1", err: "last policy requires a positive number"},
+		{in: "since 1d", err: "since policy requires a positive duration"},
+		{in: "all", err: "unknown policy: all"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			c, err := ParseCatchUp(tc.in)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.catchUp, c)
+		})
+	}
+}
+
+// testAtomServer serves an Atom feed with items published at the given
+// dates.
+type testAtomServer struct {
+	dates []time.Time
+}
+
+func (s *testAtomServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	body := `<?xml version="1.0" encoding="utf-8"?>` + "\n" +
+		`<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="en">` +
+		`<id>feed_id</id>`
+	for i, d := range s.dates {
+		body += fmt.Sprintf(`<entry>`+
+			`<id>item_%d</id>`+
+			`<updated>%s</updated>`+
+			`<link href="https://example.com/content/%d"/>`+
+			`</entry>`, i, d.Format(time.RFC3339), i)
+	}
+	body += `</feed>`
+	_, _ = w.Write([]byte(body))
+}
+
 type testRSSServer struct {
 	data bool
 	err  bool
@@ -106,9 +363,11 @@ func (s *testRSSServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>` + "\n" +
 		`<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="en">` +
 		`<id>feed_id</id>` +
+		`<title>Feed</title>` +
 		`<updated>2020-01-01T15:00:00Z</updated>` +
 		`<entry>` +
 		`<id>item_id</id>` +
+		`<title>Content</title>` +
 		`<updated>2020-01-01T15:00:00Z</updated>` +
 		`<link href="https://example.com/content/"/>` +
 		`</entry>` +
@@ -123,16 +382,22 @@ func TestItem_String(t *testing.T) {
 
 	expected := "[2020-01-01 10:00] http://example.com/content/"
 	assert.Equal(t, expected, item.String())
+
+	item.Text = "...and 3 more from Example"
+	expected = "[2020-01-01 10:00] ...and 3 more from Example"
+	assert.Equal(t, expected, item.String())
 }
 
 type testStorage struct {
-	time time.Time
+	time  time.Time
+	saved time.Time
 }
 
 func (s *testStorage) GetLastUpdate(_ string) time.Time {
 	return s.time
 }
 
-func (s *testStorage) SaveLastUpdate(_ string, _ time.Time) error {
+func (s *testStorage) SaveLastUpdate(_ string, t time.Time) error {
+	s.saved = t
 	return nil
 }
@@ -2,50 +2,580 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
 
 	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/sirupsen/logrus"
 )
 
-// API describes interface for working with remote API.
+// Telegram API limits.
+const (
+	maxCaptionLength = 1024
+	maxMediaGroup    = 10
+)
+
+// errNoMedia is returned when an item has nothing to send as media.
+var errNoMedia = errors.New("no media")
+
+// API describes interface for working with remote API. Requests are built
+// by hand, because the library doesn't support all the parameters of
+// the Bot API.
 type API interface {
-	Send(tg.Chattable) (tg.Message, error)
+	MakeRequest(endpoint string, params tg.Params) (*tg.APIResponse, error)
 }
 
 // TelegramNotifier uses Telegram API to sends notifications as Telegram
-// messages to a channel.
+// messages to a channel, a group, or a private chat.
 type TelegramNotifier struct {
-	api  API
-	chat string
-	log  *logrus.Logger
+	api      API
+	chat     string
+	thread   int
+	opts     MessageOptions
+	text     *htmltemplate.Template
+	buttons  []buttonTemplate
+	messages MessageStorage
+	log      *logrus.Logger
+}
+
+// MessageStorage keeps sent messages, so they can be edited or deleted.
+type MessageStorage interface {
+	AddMessage(m Message) error
+	GetMessages(feed string) []Message
+	UpdateMessage(m Message) error
+	RemoveMessage(m Message) error
+}
+
+// Message is a sent message of a feed item. Media groups consist of several
+// messages, and the first one has the caption.
+type Message struct {
+	Feed      string    `yaml:"feed"`
+	GUID      string    `yaml:"guid"`
+	Chat      string    `yaml:"chat"`
+	IDs       []int     `yaml:"ids"`
+	Caption   bool      `yaml:"caption,omitempty"`
+	Hash      string    `yaml:"hash"`
+	Published time.Time `yaml:"published"`
+	Sent      time.Time `yaml:"sent"`
 }
 
-// NewTelegramNotifier creates a new telegram client.
-func NewTelegramNotifier(token, chat string, log *logrus.Logger) (*TelegramNotifier, error) {
-	api, err := tg.NewBotAPI(token)
+// buttonTemplate is an inline keyboard button with URL template.
+type buttonTemplate struct {
+	text string
+	url  *template.Template
+}
+
+// NewTelegramAPI creates a new telegram API client. The client connects
+// to the configured API server, optionally through a proxy.
+func NewTelegramAPI(conf Config) (*tg.BotAPI, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.TelegramProxy != "" {
+		proxy, err := url.Parse(conf.TelegramProxy)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   conf.TelegramTimeout,
+	}
+	endpoint := strings.TrimRight(conf.TelegramAPIURL, "/") + "/bot%s/%s"
+
+	api, err := tg.NewBotAPIWithClient(conf.TelegramToken, endpoint, client)
 	if err != nil {
 		return nil, fmt.Errorf("init telegram API: %w", err)
 	}
+	return api, nil
+}
+
+// NewTelegramNotifier creates a new notifier for the destination chat.
+// If the storage is set, and the destination allows editing or deleting
+// messages, sent messages are saved to the storage.
+func NewTelegramNotifier(
+	api API,
+	dest Destination,
+	s MessageStorage,
+	log *logrus.Logger,
+) (*TelegramNotifier, error) {
+	text, err := parseMessageTemplate(dest.Message.Template)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	buttons, err := parseButtons(dest.Message.Buttons)
+	if err != nil {
+		return nil, fmt.Errorf("parse buttons: %w", err)
+	}
+	t := &TelegramNotifier{
+		api:     api,
+		chat:    chatID(dest.Chat),
+		thread:  dest.ThreadID,
+		opts:    dest.Message,
+		text:    text,
+		buttons: buttons,
+		log:     log,
+	}
+	if dest.Message.Edit || dest.Message.Delete {
+		t.messages = s
+	}
+	return t, nil
+}
 
-	bot := &TelegramNotifier{
-		api:  api,
-		chat: "@" + chat,
-		log:  log,
+// parseMessageTemplate compiles the template of message text. Empty
+// template means the item's link, so it returns nil.
+func parseMessageTemplate(text string) (*htmltemplate.Template, error) {
+	if text == "" {
+		return nil, nil //nolint:nilnil
+	}
+	tmpl, err := htmltemplate.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
 	}
-	return bot, nil
+	return tmpl, nil
 }
 
-// Notify sends a message to a Telegram channel.
+// chatID converts the chat name to the API format: numeric IDs are used
+// as is, and usernames get "@" prefix.
+func chatID(chat string) string {
+	if _, err := strconv.ParseInt(chat, 10, 64); err == nil {
+		return chat
+	}
+	return "@" + strings.TrimPrefix(chat, "@")
+}
+
+// parseButtons compiles URL templates of the buttons.
+func parseButtons(buttons []Button) ([]buttonTemplate, error) {
+	tmpls := make([]buttonTemplate, len(buttons))
+	for i, b := range buttons {
+		if b.Text == "" {
+			return nil, errors.New("empty button text")
+		}
+		tmpl, err := template.New(b.Text).Option("missingkey=error").Parse(b.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parse url of %s: %w", b.Text, err)
+		}
+		tmpls[i] = buttonTemplate{text: b.Text, url: tmpl}
+	}
+	return tmpls, nil
+}
+
+// Notify sends a message to a Telegram channel. Items with images or other
+// media are sent as media messages with a caption. If sending media fails,
+// the item is sent as a text message.
 func (t *TelegramNotifier) Notify(_ context.Context, item Item) error {
-	msg := tg.NewMessageToChannel(t.chat, item.Link)
-	_, err := t.api.Send(msg)
+	text, err := t.render(item)
+	if err != nil {
+		return err
+	}
+	params, err := t.params(item)
+	if err != nil {
+		return err
+	}
+
+	ids, err := t.sendMedia(item, text, maps.Clone(params))
+	if err == nil {
+		t.track(item, ids, true)
+		return nil
+	}
+	if !errors.Is(err, errNoMedia) {
+		t.log.Warnf("Failed to send media, fallback to text [%s]: %v", item.Link, err)
+	}
+
+	params["text"] = text
+	params.AddBool("disable_web_page_preview", t.opts.DisablePreview)
+	resp, err := t.api.MakeRequest("sendMessage", params)
 	if err != nil {
 		return fmt.Errorf("send api request: %w", err)
 	}
+	t.track(item, messageIDs(resp), false)
+	return nil
+}
+
+// Update edits sent messages of the feed's processed items, whose content
+// has changed, and deletes messages of items, that were removed from
+// the feed. Messages are tracked only during the retention period after
+// sending.
+//
+// Items, that are older than all current items of the feed, are considered
+// to be pushed out by newer ones, so their messages are not deleted.
+func (t *TelegramNotifier) Update(_ context.Context, feed string, current, processed []Item) error {
+	if t.messages == nil || len(current) == 0 {
+		return nil
+	}
+	present := make(map[string]bool, len(current))
+	oldest := current[0].Published
+	for _, item := range current {
+		present[item.GUID] = true
+		if item.Published.Before(oldest) {
+			oldest = item.Published
+		}
+	}
+	changed := make(map[string]Item, len(processed))
+	for _, item := range processed {
+		changed[item.GUID] = item
+	}
+
+	var errs []error
+	for _, m := range t.messages.GetMessages(feed) {
+		if m.Chat != t.chat {
+			continue
+		}
+		ok := present[m.GUID]
+		item, found := changed[m.GUID]
+		switch {
+		case time.Since(m.Sent) > t.opts.Retention, !ok && m.Published.Before(oldest):
+			errs = append(errs, t.messages.RemoveMessage(m))
+		case !ok:
+			if t.opts.Delete {
+				if err := t.delete(m); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+			errs = append(errs, t.messages.RemoveMessage(m))
+		case t.opts.Edit && found:
+			if err := t.edit(m, item); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// edit updates text of the message, if the item has changed since
+// the message was sent.
+func (t *TelegramNotifier) edit(m Message, item Item) error {
+	hash := contentHash(item)
+	if hash == m.Hash {
+		return nil
+	}
+	text, err := t.render(item)
+	if err != nil {
+		return err
+	}
+
+	params := tg.Params{"chat_id": t.chat}
+	params.AddNonEmpty("parse_mode", t.parseMode())
+	params.AddNonZero("message_id", m.IDs[0])
+	// Media groups can't have buttons
+	if len(m.IDs) == 1 {
+		markup, err := t.keyboard(item)
+		if err != nil {
+			return err
+		}
+		if err := params.AddInterface("reply_markup", markup); err != nil {
+			return fmt.Errorf("encode buttons: %w", err)
+		}
+	}
+	endpoint := "editMessageText"
+	if m.Caption {
+		endpoint = "editMessageCaption"
+		params["caption"] = t.caption(text)
+	} else {
+		params["text"] = text
+		params.AddBool("disable_web_page_preview", t.opts.DisablePreview)
+	}
+	_, err = t.request(endpoint, params)
+	if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		return fmt.Errorf("edit message [%s]: %w", item.Link, err)
+	}
+
+	t.log.Debugf("Message edited [%s]: %s", m.Feed, item.Link)
+	m.Hash = hash
+	if err := t.messages.UpdateMessage(m); err != nil {
+		return fmt.Errorf("save message: %w", err)
+	}
+	return nil
+}
+
+// delete deletes all parts of the message.
+func (t *TelegramNotifier) delete(m Message) error {
+	for _, id := range m.IDs {
+		params := tg.Params{"chat_id": t.chat}
+		params.AddNonZero("message_id", id)
+		if _, err := t.request("deleteMessage", params); err != nil {
+			return fmt.Errorf("delete message [%s]: %w", m.GUID, err)
+		}
+	}
+	t.log.Debugf("Message deleted [%s]: %s", m.Feed, m.GUID)
 	return nil
 }
 
+// track saves the sent message of the item, if messages are tracked.
+// Service messages are never changed, so they are not tracked.
+func (t *TelegramNotifier) track(item Item, ids []int, caption bool) {
+	if t.messages == nil || item.Text != "" || item.GUID == "" || len(ids) == 0 {
+		return
+	}
+	err := t.messages.AddMessage(Message{
+		Feed:      item.Feed,
+		GUID:      item.GUID,
+		Chat:      t.chat,
+		IDs:       ids,
+		Caption:   caption,
+		Hash:      contentHash(item),
+		Published: item.Published,
+		Sent:      time.Now(),
+	})
+	if err != nil {
+		t.log.Errorf("Failed to save sent message [%s]: %v", item.Link, err)
+	}
+}
+
+// render executes the message template with the item. Without a template
+// the message is the item's link. Service messages are sent as is.
+func (t *TelegramNotifier) render(item Item) (string, error) {
+	if t.text == nil {
+		if item.Text != "" {
+			return item.Text, nil
+		}
+		return item.Link, nil
+	}
+	if item.Text != "" {
+		return html.EscapeString(item.Text), nil
+	}
+	var text strings.Builder
+	if err := t.text.Execute(&text, item); err != nil {
+		return "", fmt.Errorf("render template: %w", err)
+	}
+	return text.String(), nil
+}
+
+// sendMedia sends the item's images as a photo or a media group, or its
+// first enclosure as an audio or a document. Media groups can't have
+// buttons, so only the first image is sent when there are buttons.
+func (t *TelegramNotifier) sendMedia(item Item, caption string, params tg.Params) ([]int, error) {
+	caption = t.caption(caption)
+	_, hasButtons := params["reply_markup"]
+
+	switch {
+	case len(item.Images) > 1 && !hasButtons:
+		media := make([]inputMedia, 0, maxMediaGroup)
+		for _, img := range item.Images[:min(len(item.Images), maxMediaGroup)] {
+			media = append(media, inputMedia{Type: "photo", Media: img})
+		}
+		media[0].Caption = caption
+		media[0].ParseMode = t.parseMode()
+		if err := params.AddInterface("media", media); err != nil {
+			return nil, fmt.Errorf("encode media: %w", err)
+		}
+		return t.request("sendMediaGroup", params)
+	case len(item.Images) > 0:
+		params["photo"] = item.Images[0]
+		params["caption"] = caption
+		return t.request("sendPhoto", params)
+	case len(item.Enclosures) > 0:
+		e := item.Enclosures[0]
+		endpoint, field := "sendDocument", "document"
+		if strings.HasPrefix(e.Type, "audio") {
+			endpoint, field = "sendAudio", "audio"
+		}
+		params[field] = e.URL
+		params["caption"] = caption
+		return t.request(endpoint, params)
+	default:
+		return nil, errNoMedia
+	}
+}
+
+// request makes an API request, and returns IDs of sent messages, if any.
+func (t *TelegramNotifier) request(endpoint string, params tg.Params) ([]int, error) {
+	resp, err := t.api.MakeRequest(endpoint, params)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", endpoint, err)
+	}
+	return messageIDs(resp), nil
+}
+
+// messageIDs gets IDs of sent messages from the response, that has either
+// a single message, or a list of messages.
+func messageIDs(resp *tg.APIResponse) []int {
+	type message struct {
+		MessageID int `json:"message_id"`
+	}
+	if resp == nil {
+		return nil
+	}
+	var list []message
+	if err := json.Unmarshal(resp.Result, &list); err != nil {
+		var msg message
+		if err := json.Unmarshal(resp.Result, &msg); err != nil || msg.MessageID == 0 {
+			return nil
+		}
+		list = []message{msg}
+	}
+	ids := make([]int, len(list))
+	for i, m := range list {
+		ids[i] = m.MessageID
+	}
+	return ids
+}
+
+// contentHash is a hash of the item's content, that shows if the message
+// needs editing. It doesn't depend on the template, so the message is
+// edited even if the rendered text looks the same, e.g. for the default
+// template with a link, that gets a new preview.
+func contentHash(item Item) string {
+	h := sha256.New()
+	for _, s := range append([]string{item.Title, item.Description, item.Link}, item.Images...) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// parseMode returns the formatting of message texts. Only templates
+// produce HTML, links are sent as plain text.
+func (t *TelegramNotifier) parseMode() string {
+	if t.text == nil {
+		return ""
+	}
+	return "HTML"
+}
+
+// caption cuts the message text to the length of media captions. HTML tags
+// must stay valid, otherwise Telegram rejects the message.
+func (t *TelegramNotifier) caption(text string) string {
+	if t.text == nil {
+		return truncate(text, maxCaptionLength)
+	}
+	return truncateHTML(text, maxCaptionLength)
+}
+
+// params returns common parameters of all message types.
+func (t *TelegramNotifier) params(item Item) (tg.Params, error) {
+	params := tg.Params{"chat_id": t.chat}
+	params.AddNonEmpty("parse_mode", t.parseMode())
+	params.AddNonZero("message_thread_id", t.thread)
+	params.AddBool("disable_notification", t.opts.Silent)
+	params.AddBool("protect_content", t.opts.ProtectContent)
+
+	markup, err := t.keyboard(item)
+	if err != nil {
+		return nil, err
+	}
+	if markup != nil {
+		if err := params.AddInterface("reply_markup", markup); err != nil {
+			return nil, fmt.Errorf("encode buttons: %w", err)
+		}
+	}
+	return params, nil
+}
+
+// keyboard renders buttons for the item. It returns nil if there are
+// no buttons.
+func (t *TelegramNotifier) keyboard(item Item) (*tg.InlineKeyboardMarkup, error) {
+	// Service messages don't have item fields for buttons
+	if item.Text != "" {
+		return nil, nil //nolint:nilnil
+	}
+	var row []tg.InlineKeyboardButton
+	for _, b := range t.buttons {
+		var url strings.Builder
+		if err := b.url.Execute(&url, item); err != nil {
+			return nil, fmt.Errorf("render button %s: %w", b.text, err)
+		}
+		if url.Len() == 0 {
+			continue
+		}
+		row = append(row, tg.NewInlineKeyboardButtonURL(b.text, url.String()))
+	}
+	if len(row) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+	markup := tg.NewInlineKeyboardMarkup(row)
+	return &markup, nil
+}
+
+// truncateHTML cuts the HTML text to n visible runes, as Telegram counts
+// the length after parsing the markup. Tags, that are left open, are closed,
+// and entities are never cut.
+func truncateHTML(s string, n int) string {
+	if htmlLength(s) <= n {
+		return s
+	}
+	var b strings.Builder
+	var open []string
+	var visible int
+	for rest := s; rest != ""; {
+		token, tag := htmlToken(rest)
+		rest = rest[len(token):]
+		if !tag {
+			if visible == n-1 {
+				break
+			}
+			visible++
+			b.WriteString(token)
+			continue
+		}
+		b.WriteString(token)
+		name, _, _ := strings.Cut(strings.Trim(token, "</>"), " ")
+		if strings.HasPrefix(token, "</") {
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		} else {
+			open = append(open, name)
+		}
+	}
+	b.WriteString("…")
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String()
+}
+
+// htmlLength returns the number of visible runes of the HTML text.
+func htmlLength(s string) int {
+	var n int
+	for rest := s; rest != ""; {
+		token, tag := htmlToken(rest)
+		rest = rest[len(token):]
+		if !tag {
+			n++
+		}
+	}
+	return n
+}
+
+// htmlToken returns the first tag, entity or rune of the HTML text, and
+// whether it's a tag.
+func htmlToken(s string) (string, bool) {
+	switch s[0] {
+	case '<':
+		if end := strings.IndexByte(s, '>'); end >= 0 {
+			return s[:end+1], true
+		}
+	case '&':
+		if end := strings.IndexByte(s, ';'); end > 0 && !strings.ContainsAny(s[1:end], " <&") {
+			return s[:end+1], false
+		}
+	}
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size], false
+}
+
+// inputMedia is an item of a media group.
+type inputMedia struct {
+	Type      string `json:"type"`
+	Media     string `json:"media"`
+	Caption   string `json:"caption,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
 // PrintNotifier is a notifier for debugging mode. It prints items without
 // sending them anywhere.
 type PrintNotifier struct {
@@ -2,15 +2,112 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
+func TestNewTelegramAPI(t *testing.T) {
+	const token = "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAA-AAAAA"
+
+	t.Run("custom server", func(t *testing.T) {
+		fake := &testBotAPIServer{token: token}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		api, err := NewTelegramAPI(Config{
+			TelegramToken:   token,
+			TelegramAPIURL:  server.URL + "/",
+			TelegramTimeout: time.Second,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "test_bot", api.Self.UserName)
+
+		tn, err := NewTelegramNotifier(api, Destination{Chat: "-1001234567890"}, nil, logrus.New())
+		assert.NoError(t, err)
+		assert.NoError(t, tn.Notify(context.Background(), Item{Link: "https://example.com/content/"}))
+
+		assert.Equal(t, []string{"getMe", "sendMessage"}, fake.methods)
+		assert.Equal(t, "-1001234567890", fake.params[1].Get("chat_id"))
+		assert.Equal(t, "https://example.com/content/", fake.params[1].Get("text"))
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		fake := &testBotAPIServer{token: token}
+		proxy := httptest.NewServer(fake)
+		defer proxy.Close()
+
+		_, err := NewTelegramAPI(Config{
+			TelegramToken:   token,
+			TelegramAPIURL:  "http://telegram.invalid",
+			TelegramProxy:   proxy.URL,
+			TelegramTimeout: time.Second,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"getMe"}, fake.methods)
+		assert.Equal(t, []string{"telegram.invalid"}, fake.hosts)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		server := httptest.NewServer(&testBotAPIServer{token: token, delay: 100 * time.Millisecond})
+		defer server.Close()
+
+		_, err := NewTelegramAPI(Config{
+			TelegramToken:   token,
+			TelegramAPIURL:  server.URL,
+			TelegramTimeout: 10 * time.Millisecond,
+		})
+		assert.ErrorContains(t, err, "init telegram API")
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		server := httptest.NewServer(&testBotAPIServer{token: token})
+		defer server.Close()
+
+		_, err := NewTelegramAPI(Config{
+			TelegramToken:   "invalid",
+			TelegramAPIURL:  server.URL,
+			TelegramTimeout: time.Second,
+		})
+		assert.EqualError(t, err, "init telegram API: Unauthorized")
+	})
+}
+
+func TestNewTelegramNotifier(t *testing.T) {
+	api := &testTgAPI{}
+	opts := MessageOptions{Buttons: []Button{{Text: "Open", URL: "{{.Link}}"}}}
+	tn, err := NewTelegramNotifier(api, Destination{Chat: "chat_name", Message: opts}, nil, logrus.New())
+	assert.NoError(t, err)
+	assert.Equal(t, api, tn.api)
+	assert.Equal(t, "@chat_name", tn.chat)
+	assert.Len(t, tn.buttons, 1)
+
+	tn, err = NewTelegramNotifier(api, Destination{Chat: "-1001234567890", ThreadID: 5}, nil, logrus.New())
+	assert.NoError(t, err)
+	assert.Equal(t, "-1001234567890", tn.chat)
+	assert.Equal(t, 5, tn.thread)
+
+	opts = MessageOptions{Buttons: []Button{{Text: "Open", URL: "{{.Link"}}}
+	_, err = NewTelegramNotifier(api, Destination{Chat: "chat_name", Message: opts}, nil, logrus.New())
+	assert.ErrorContains(t, err, "parse buttons: parse url of Open")
+
+	opts = MessageOptions{Buttons: []Button{{URL: "{{.Link}}"}}}
+	_, err = NewTelegramNotifier(api, Destination{Chat: "chat_name", Message: opts}, nil, logrus.New())
+	assert.EqualError(t, err, "parse buttons: empty button text")
+}
+
 func TestTelegramNotifier_Notify(t *testing.T) {
 	item := Item{
 		Link: "http://example.com/content/",
@@ -20,33 +117,529 @@ func TestTelegramNotifier_Notify(t *testing.T) {
 
 	t.Run("successful send", func(t *testing.T) {
 		api := &testTgAPI{}
-		tn := &TelegramNotifier{api: api, chat: "@chat_name", log: log}
+		tn, _ := NewTelegramNotifier(api, Destination{Chat: "chat_name"}, nil, log)
 
 		err := tn.Notify(context.Background(), item)
 		assert.NoError(t, err)
 		assert.Equal(t, "http://example.com/content/", api.sent)
+		assert.Equal(t, "@chat_name", api.params[0]["chat_id"])
+	})
+
+	t.Run("service message", func(t *testing.T) {
+		api := &testTgAPI{}
+		tn, _ := NewTelegramNotifier(api, Destination{Chat: "chat_name"}, nil, log)
+
+		err := tn.Notify(context.Background(), Item{Text: "...and 3 more from Example"})
+		assert.NoError(t, err)
+		assert.Equal(t, "...and 3 more from Example", api.sent)
 	})
 
 	t.Run("error from api", func(t *testing.T) {
 		api := &testTgAPI{err: errors.New("internal error")}
-		tn := &TelegramNotifier{api: api, chat: "@chat_name", log: log}
+		tn, _ := NewTelegramNotifier(api, Destination{Chat: "chat_name"}, nil, log)
 
 		err := tn.Notify(context.Background(), item)
 		assert.EqualError(t, err, "send api request: internal error")
 	})
 }
 
+func TestChatID(t *testing.T) {
+	assert.Equal(t, "@chat_name", chatID("chat_name"))
+	assert.Equal(t, "@chat_name", chatID("@chat_name"))
+	assert.Equal(t, "-1001234567890", chatID("-1001234567890"))
+	assert.Equal(t, "123456", chatID("123456"))
+}
+
+func TestTelegramNotifier_Notify_Thread(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	api := &testTgAPI{}
+	dest := Destination{Chat: "-1001234567890", ChatType: ChatTypeGroup, ThreadID: 42}
+	tn, err := NewTelegramNotifier(api, dest, nil, log)
+	assert.NoError(t, err)
+
+	item := Item{
+		Link:   "http://example.com/content/",
+		Images: []string{"http://example.com/image.jpg"},
+	}
+	assert.NoError(t, tn.Notify(context.Background(), item))
+	assert.NoError(t, tn.Notify(context.Background(), Item{Link: "http://example.com/content/"}))
+	assert.Equal(t, []string{"sendPhoto", "sendMessage"}, api.endpoints)
+	for _, p := range api.params {
+		assert.Equal(t, "-1001234567890", p["chat_id"])
+		assert.Equal(t, "42", p["message_thread_id"])
+	}
+}
+
+func TestTelegramNotifier_Notify_Options(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	opts := MessageOptions{
+		DisablePreview: true,
+		Silent:         true,
+		ProtectContent: true,
+		Buttons: []Button{
+			{Text: "Open", URL: "{{.Link}}"},
+			{Text: "Comments", URL: "{{.Comments}}"},
+			{Text: "Source feed", URL: "{{.FeedLink}}"},
+		},
+	}
+
+	t.Run("text message", func(t *testing.T) {
+		api := &testTgAPI{}
+		tn, err := NewTelegramNotifier(api, Destination{Chat: "chat_name", Message: opts}, nil, log)
+		assert.NoError(t, err)
+
+		item := Item{
+			Link:     "http://example.com/content/",
+			FeedLink: "http://example.com/",
+		}
+		assert.NoError(t, tn.Notify(context.Background(), item))
+		assert.Equal(t, tg.Params{
+			"chat_id":                  "@chat_name",
+			"text":                     "http://example.com/content/",
+			"disable_web_page_preview": "true",
+			"disable_notification":     "true",
+			"protect_content":          "true",
+			"reply_markup": `{"inline_keyboard":[[` +
+				`{"text":"Open","url":"http://example.com/content/"},` +
+				`{"text":"Source feed","url":"http://example.com/"}` +
+				`]]}`,
+		}, api.params[0])
+	})
+
+	t.Run("media group with buttons", func(t *testing.T) {
+		api := &testTgAPI{}
+		tn, err := NewTelegramNotifier(api, Destination{Chat: "chat_name", Message: opts}, nil, log)
+		assert.NoError(t, err)
+
+		item := Item{
+			Link:   "http://example.com/content/",
+			Images: []string{"http://example.com/1.jpg", "http://example.com/2.jpg"},
+		}
+		assert.NoError(t, tn.Notify(context.Background(), item))
+		assert.Equal(t, []string{"sendPhoto"}, api.endpoints)
+		assert.Equal(t, "http://example.com/1.jpg", api.params[0]["photo"])
+		assert.Contains(t, api.params[0], "reply_markup")
+		assert.NotContains(t, api.params[0], "disable_web_page_preview")
+	})
+
+	t.Run("service message", func(t *testing.T) {
+		api := &testTgAPI{}
+		tn, err := NewTelegramNotifier(api, Destination{Chat: "chat_name", Message: opts}, nil, log)
+		assert.NoError(t, err)
+
+		assert.NoError(t, tn.Notify(context.Background(), Item{Text: "Digest <1>"}))
+		assert.NotContains(t, api.params[0], "reply_markup")
+		assert.Equal(t, "Digest <1>", api.sent)
+	})
+
+	t.Run("template", func(t *testing.T) {
+		api := &testTgAPI{}
+		opts := MessageOptions{Template: `<b>{{.Title}}</b> <a href="{{.Link}}">{{.FeedTitle}}</a>`}
+		tn, err := NewTelegramNotifier(api, Destination{Chat: "chat_name", Message: opts}, nil, log)
+		assert.NoError(t, err)
+
+		item := Item{
+			Link:      "http://example.com/content/?a=1&b=2",
+			Title:     "Fish & Chips",
+			FeedTitle: "Example",
+		}
+		assert.NoError(t, tn.Notify(context.Background(), item))
+		assert.Equal(t, `<b>Fish &amp; Chips</b> `+
+			`<a href="http://example.com/content/?a=1&amp;b=2">Example</a>`, api.sent)
+		assert.Equal(t, "HTML", api.params[0]["parse_mode"])
+
+		// Service messages are escaped for HTML
+		assert.NoError(t, tn.Notify(context.Background(), Item{Text: "Digest <1>"}))
+		assert.Equal(t, "Digest &lt;1&gt;", api.sent)
+	})
+
+	t.Run("long caption", func(t *testing.T) {
+		api := &testTgAPI{}
+		opts := MessageOptions{Template: `<b>{{.Title}}</b>`}
+		tn, err := NewTelegramNotifier(api, Destination{Chat: "chat_name", Message: opts}, nil, log)
+		assert.NoError(t, err)
+
+		item := Item{
+			Title:  strings.Repeat("&", maxCaptionLength+1),
+			Images: []string{"http://example.com/image.jpg"},
+		}
+		assert.NoError(t, tn.Notify(context.Background(), item))
+		assert.Equal(t, "<b>"+strings.Repeat("&amp;", maxCaptionLength-1)+"…</b>", api.params[0]["caption"])
+	})
+}
+
+func TestTruncateHTML(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		n   int
+		out string
+	}{
+		{"short", 10, "short"},
+		{"<b>bold</b> text", 9, "<b>bold</b> text"},
+		{"<b>bold</b> text", 6, "<b>bold</b> …"},
+		{`<a href="http://example.com">link text</a>`, 5, `<a href="http://example.com">link…</a>`},
+		{"<b><i>nested</i></b>", 3, "<b><i>ne…</i></b>"},
+		{"a &amp; b", 4, "a &amp;…"},
+		{"1 < 2 and more", 4, "1 <…"},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			assert.Equal(t, tc.out, truncateHTML(tc.in, tc.n))
+		})
+	}
+}
+
+func TestTelegramNotifier_Update(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	ts := time.Now().Add(-time.Hour)
+	item := func(guid, title string, published time.Time) Item {
+		return Item{
+			GUID:      guid,
+			Feed:      "f1",
+			Link:      "http://example.com/" + guid,
+			Title:     title,
+			Published: published,
+		}
+	}
+	dest := Destination{
+		Chat: "chat_name",
+		Message: MessageOptions{
+			Template:  "{{.Title}}",
+			Edit:      true,
+			Delete:    true,
+			Retention: 24 * time.Hour,
+		},
+	}
+
+	t.Run("track messages", func(t *testing.T) {
+		api := &testTgAPI{}
+		s := &testMessageStorage{}
+		tn, err := NewTelegramNotifier(api, dest, s, log)
+		assert.NoError(t, err)
+
+		ctx := context.Background()
+		assert.NoError(t, tn.Notify(ctx, item("one", "One", ts)))
+		withImages := item("two", "Two", ts)
+		withImages.Images = []string{"http://example.com/1.jpg", "http://example.com/2.jpg"}
+		assert.NoError(t, tn.Notify(ctx, withImages))
+		assert.NoError(t, tn.Notify(ctx, Item{Feed: "f1", Text: "Summary"}))
+
+		assert.Len(t, s.messages, 2)
+		assert.Equal(t, "one", s.messages[0].GUID)
+		assert.Equal(t, "@chat_name", s.messages[0].Chat)
+		assert.Equal(t, []int{1}, s.messages[0].IDs)
+		assert.False(t, s.messages[0].Caption)
+		assert.Equal(t, contentHash(item("one", "One", ts)), s.messages[0].Hash)
+		assert.Equal(t, []int{1, 2}, s.messages[1].IDs)
+		assert.True(t, s.messages[1].Caption)
+	})
+
+	t.Run("not tracked", func(t *testing.T) {
+		api := &testTgAPI{}
+		s := &testMessageStorage{}
+		tn, err := NewTelegramNotifier(api, Destination{Chat: "chat_name"}, s, log)
+		assert.NoError(t, err)
+
+		assert.NoError(t, tn.Notify(context.Background(), item("one", "One", ts)))
+		assert.NoError(t, tn.Update(context.Background(), "f1", nil, []Item{item("one", "Uno", ts)}))
+		assert.Empty(t, s.messages)
+		assert.Equal(t, []string{"sendMessage"}, api.endpoints)
+	})
+
+	t.Run("edit and delete", func(t *testing.T) {
+		api := &testTgAPI{}
+		s := &testMessageStorage{messages: []Message{
+			{
+				Feed: "f1", GUID: "same", Chat: "@chat_name", IDs: []int{1}, Published: ts, Sent: ts,
+				Hash: contentHash(item("same", "Same", ts)),
+			},
+			{
+				Feed: "f1", GUID: "text", Chat: "@chat_name", IDs: []int{2}, Published: ts, Sent: ts,
+				Hash: contentHash(item("text", "Old", ts)),
+			},
+			{
+				Feed: "f1", GUID: "photo", Chat: "@chat_name", IDs: []int{3}, Caption: true,
+				Hash: contentHash(item("photo", "Old", ts)), Published: ts, Sent: ts,
+			},
+			{Feed: "f1", GUID: "removed", Chat: "@chat_name", IDs: []int{4, 5}, Published: ts, Sent: ts},
+			{Feed: "f1", GUID: "old", Chat: "@chat_name", IDs: []int{6}, Published: ts.Add(-time.Hour), Sent: ts},
+			{Feed: "f1", GUID: "expired", Chat: "@chat_name", IDs: []int{7}, Published: ts, Sent: ts.Add(-48 * time.Hour)},
+			{Feed: "f1", GUID: "text", Chat: "@other", IDs: []int{8}, Published: ts, Sent: ts},
+			{
+				Feed: "f1", GUID: "dropped", Chat: "@chat_name", IDs: []int{9}, Published: ts, Sent: ts,
+				Hash: contentHash(item("dropped", "Old", ts)),
+			},
+		}}
+		tn, err := NewTelegramNotifier(api, dest, s, log)
+		assert.NoError(t, err)
+
+		items := []Item{
+			item("same", "Same", ts),
+			item("text", "New", ts),
+			item("photo", "New", ts),
+			item("expired", "New", ts),
+		}
+		// Items, that are dropped by processing, keep their messages
+		current := slices.Concat(items, []Item{item("dropped", "New", ts)})
+		assert.NoError(t, tn.Update(context.Background(), "f1", current, items))
+
+		assert.Equal(t, []string{
+			"editMessageText", "editMessageCaption", "deleteMessage", "deleteMessage",
+		}, api.endpoints)
+		assert.Equal(t, tg.Params{
+			"chat_id":    "@chat_name",
+			"message_id": "2",
+			"parse_mode": "HTML",
+			"text":       "New",
+		}, api.params[0])
+		assert.Equal(t, "3", api.params[1]["message_id"])
+		assert.Equal(t, "New", api.params[1]["caption"])
+		assert.Equal(t, "4", api.params[2]["message_id"])
+		assert.Equal(t, "5", api.params[3]["message_id"])
+
+		guids := make([]string, len(s.messages))
+		for i, m := range s.messages {
+			guids[i] = m.GUID
+		}
+		assert.Equal(t, []string{"same", "text", "photo", "text", "dropped"}, guids)
+		assert.Equal(t, contentHash(item("dropped", "Old", ts)), s.messages[4].Hash)
+		assert.Equal(t, contentHash(item("text", "New", ts)), s.messages[1].Hash)
+	})
+
+	t.Run("default template", func(t *testing.T) {
+		api := &testTgAPI{}
+		s := &testMessageStorage{}
+		opts := MessageOptions{Edit: true, Retention: time.Hour}
+		tn, err := NewTelegramNotifier(api, Destination{Chat: "chat_name", Message: opts}, s, log)
+		assert.NoError(t, err)
+
+		ctx := context.Background()
+		assert.NoError(t, tn.Notify(ctx, item("one", "One", ts)))
+		one, uno := []Item{item("one", "One", ts)}, []Item{item("one", "Uno", ts)}
+		assert.NoError(t, tn.Update(ctx, "f1", one, one))
+		assert.NoError(t, tn.Update(ctx, "f1", uno, uno))
+		assert.Equal(t, []string{"sendMessage", "editMessageText"}, api.endpoints)
+		assert.Equal(t, "http://example.com/one", api.params[1]["text"])
+	})
+
+	t.Run("empty feed", func(t *testing.T) {
+		api := &testTgAPI{}
+		s := &testMessageStorage{messages: []Message{
+			{Feed: "f1", GUID: "one", Chat: "@chat_name", IDs: []int{1}, Published: ts, Sent: ts},
+		}}
+		tn, err := NewTelegramNotifier(api, dest, s, log)
+		assert.NoError(t, err)
+
+		assert.NoError(t, tn.Update(context.Background(), "f1", nil, nil))
+		assert.Empty(t, api.endpoints)
+		assert.Len(t, s.messages, 1)
+	})
+
+	t.Run("error from api", func(t *testing.T) {
+		api := &testTgAPI{err: errors.New("internal error")}
+		s := &testMessageStorage{messages: []Message{
+			{Feed: "f1", GUID: "one", Chat: "@chat_name", IDs: []int{1}, Published: ts, Sent: ts},
+		}}
+		tn, err := NewTelegramNotifier(api, dest, s, log)
+		assert.NoError(t, err)
+
+		items := []Item{item("one", "One", ts)}
+		err = tn.Update(context.Background(), "f1", items, items)
+		assert.EqualError(t, err, "edit message [http://example.com/one]: editMessageText: internal error")
+		assert.Empty(t, s.messages[0].Hash)
+	})
+}
+
+func TestMessageIDs(t *testing.T) {
+	for in, ids := range map[string][]int{
+		`{"message_id":1}`:                    {1},
+		`[{"message_id":1},{"message_id":2}]`: {1, 2},
+		`true`:                                nil,
+	} {
+		assert.Equal(t, ids, messageIDs(&tg.APIResponse{Result: json.RawMessage(in)}))
+	}
+	assert.Nil(t, messageIDs(nil))
+}
+
+func TestTelegramNotifier_Notify_Media(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	t.Run("photo", func(t *testing.T) {
+		api := &testTgAPI{}
+		tn, _ := NewTelegramNotifier(api, Destination{Chat: "chat_name"}, nil, log)
+
+		item := Item{
+			Link:   "http://example.com/content/",
+			Images: []string{"http://example.com/image.jpg"},
+		}
+		assert.NoError(t, tn.Notify(context.Background(), item))
+		assert.Equal(t, []string{"sendPhoto"}, api.endpoints)
+		assert.Equal(t, tg.Params{
+			"chat_id": "@chat_name",
+			"photo":   "http://example.com/image.jpg",
+			"caption": "http://example.com/content/",
+		}, api.params[0])
+	})
+
+	t.Run("media group", func(t *testing.T) {
+		api := &testTgAPI{}
+		tn, _ := NewTelegramNotifier(api, Destination{Chat: "chat_name"}, nil, log)
+
+		item := Item{
+			Link:   "http://example.com/content/",
+			Images: []string{"http://example.com/1.jpg", "http://example.com/2.jpg"},
+		}
+		assert.NoError(t, tn.Notify(context.Background(), item))
+		assert.Equal(t, []string{"sendMediaGroup"}, api.endpoints)
+
+		var media []inputMedia
+		assert.NoError(t, json.Unmarshal([]byte(api.params[0]["media"]), &media))
+		assert.Equal(t, []inputMedia{
+			{
+				Type:    "photo",
+				Media:   "http://example.com/1.jpg",
+				Caption: "http://example.com/content/",
+			},
+			{Type: "photo", Media: "http://example.com/2.jpg"},
+		}, media)
+	})
+
+	t.Run("audio", func(t *testing.T) {
+		api := &testTgAPI{}
+		tn, _ := NewTelegramNotifier(api, Destination{Chat: "chat_name"}, nil, log)
+
+		item := Item{
+			Link:       "http://example.com/content/",
+			Enclosures: []Enclosure{{URL: "http://example.com/ep1.mp3", Type: "audio/mpeg"}},
+		}
+		assert.NoError(t, tn.Notify(context.Background(), item))
+		assert.Equal(t, []string{"sendAudio"}, api.endpoints)
+		assert.Equal(t, "http://example.com/ep1.mp3", api.params[0]["audio"])
+	})
+
+	t.Run("document", func(t *testing.T) {
+		api := &testTgAPI{}
+		tn, _ := NewTelegramNotifier(api, Destination{Chat: "chat_name"}, nil, log)
+
+		item := Item{
+			Link:       "http://example.com/content/",
+			Enclosures: []Enclosure{{URL: "http://example.com/paper.pdf", Type: "application/pdf"}},
+		}
+		assert.NoError(t, tn.Notify(context.Background(), item))
+		assert.Equal(t, []string{"sendDocument"}, api.endpoints)
+		assert.Equal(t, "http://example.com/paper.pdf", api.params[0]["document"])
+	})
+
+	t.Run("fallback to text", func(t *testing.T) {
+		api := &testTgAPI{err: errors.New("wrong file identifier"), failOn: "sendPhoto"}
+		tn, _ := NewTelegramNotifier(api, Destination{Chat: "chat_name"}, nil, log)
+
+		item := Item{
+			Link:   "http://example.com/content/",
+			Images: []string{"http://example.com/image.jpg"},
+		}
+		assert.NoError(t, tn.Notify(context.Background(), item))
+		assert.Equal(t, []string{"sendPhoto", "sendMessage"}, api.endpoints)
+		assert.Equal(t, "http://example.com/content/", api.sent)
+	})
+}
+
 type testTgAPI struct {
-	sent string
-	err  error
+	sent      string
+	endpoints []string
+	params    []tg.Params
+	err       error
+	failOn    string // fail only requests to this endpoint, if set
+}
+
+func (t *testTgAPI) MakeRequest(endpoint string, params tg.Params) (*tg.APIResponse, error) {
+	t.endpoints = append(t.endpoints, endpoint)
+	t.params = append(t.params, params)
+	if t.err != nil && (t.failOn == "" || t.failOn == endpoint) {
+		return nil, t.err
+	}
+	if endpoint == "sendMessage" {
+		t.sent = params["text"]
+	}
+	if endpoint == "sendMediaGroup" {
+		return &tg.APIResponse{Ok: true, Result: json.RawMessage(`[{"message_id":1},{"message_id":2}]`)}, nil
+	}
+	return &tg.APIResponse{Ok: true, Result: json.RawMessage(`{"message_id":1}`)}, nil
+}
+
+type testMessageStorage struct {
+	messages []Message
+}
+
+func (s *testMessageStorage) AddMessage(m Message) error {
+	s.messages = append(s.messages, m)
+	return nil
+}
+
+func (s *testMessageStorage) GetMessages(feed string) []Message {
+	var msgs []Message
+	for _, m := range s.messages {
+		if m.Feed == feed {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+func (s *testMessageStorage) UpdateMessage(m Message) error {
+	for i, x := range s.messages {
+		if x.Chat == m.Chat && x.GUID == m.GUID {
+			s.messages[i] = m
+		}
+	}
+	return nil
 }
 
-func (t *testTgAPI) Send(msg tg.Chattable) (tg.Message, error) {
-	switch m := msg.(type) {
-	case tg.MessageConfig:
-		t.sent = m.Text
-	default:
-		t.err = errors.New("unknown message type")
+func (s *testMessageStorage) RemoveMessage(m Message) error {
+	s.messages = slices.DeleteFunc(s.messages, func(x Message) bool {
+		return x.Chat == m.Chat && x.GUID == m.GUID
+	})
+	return nil
+}
+
+// testBotAPIServer is a fake of Telegram Bot API server, that accepts
+// requests with the token, and records them.
+type testBotAPIServer struct {
+	token string
+	delay time.Duration
+
+	mu      sync.Mutex
+	hosts   []string
+	methods []string
+	params  []url.Values
+}
+
+func (s *testBotAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(s.delay)
+
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+s.token+"/")
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"ok":false,"error_code":401,"description":"Unauthorized"}`)) //nolint:errcheck
+		return
+	}
+	r.ParseForm() //nolint:errcheck
+
+	s.mu.Lock()
+	s.hosts = append(s.hosts, r.Host)
+	s.methods = append(s.methods, method)
+	s.params = append(s.params, r.PostForm)
+	s.mu.Unlock()
+
+	result := `{"message_id":1}`
+	if method == "getMe" {
+		result = `{"id":1,"is_bot":true,"username":"test_bot"}`
 	}
-	return tg.Message{}, t.err
+	w.Write([]byte(`{"ok":true,"result":` + result + `}`)) //nolint:errcheck
 }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxTranslationCache is a number of cached translations. The cache is
+// cleared when it's full.
+const maxTranslationCache = 1000
+
+// Translator translates texts to the target language.
+type Translator interface {
+	Translate(ctx context.Context, texts []string, target string) ([]Translated, error)
+}
+
+// Translated is a translated text with the detected source language.
+type Translated struct {
+	Text     string
+	Language string
+}
+
+// ItemTranslator is a processor, that translates titles and descriptions
+// of items. Original texts are kept in the item. Translations are cached,
+// so unchanged items are not sent to the translator again.
+type ItemTranslator struct {
+	translator Translator
+	target     string
+
+	cache map[string]Translated
+	mx    *sync.Mutex
+}
+
+// NewItemTranslator creates a new translator of items to the target
+// language.
+func NewItemTranslator(t Translator, target string) *ItemTranslator {
+	return &ItemTranslator{
+		translator: t,
+		target:     target,
+		cache:      map[string]Translated{},
+		mx:         &sync.Mutex{},
+	}
+}
+
+// Translate translates the item. Items in the target language, already
+// translated items, and service messages are returned as is.
+func (t *ItemTranslator) Translate(ctx context.Context, item Item) (Item, error) {
+	if item.Text != "" || item.Original != nil {
+		return item, nil
+	}
+	texts, err := t.translate(ctx, []string{item.Title, item.Description})
+	if err != nil {
+		return item, err
+	}
+	title, desc := texts[0], texts[1]
+	lang := title.Language
+	if lang == "" {
+		lang = desc.Language
+	}
+	if lang == t.target {
+		return item, nil
+	}
+
+	item.Original = &Original{
+		Language:    lang,
+		Title:       item.Title,
+		Description: item.Description,
+	}
+	item.Title, item.Description = title.Text, desc.Text
+	return item, nil
+}
+
+// Process translates the item.
+func (t *ItemTranslator) Process(ctx context.Context, item Item) ([]Item, error) {
+	item, err := t.Translate(ctx, item)
+	if err != nil {
+		return nil, err
+	}
+	return []Item{item}, nil
+}
+
+// translate translates texts, that are not cached. Empty texts are not
+// translated.
+func (t *ItemTranslator) translate(ctx context.Context, texts []string) ([]Translated, error) {
+	out := make([]Translated, len(texts))
+	var missing []string
+	var index []int
+
+	t.mx.Lock()
+	for i, text := range texts {
+		if strings.TrimSpace(text) == "" {
+			out[i] = Translated{Text: text}
+			continue
+		}
+		if tr, ok := t.cache[text]; ok {
+			out[i] = tr
+			continue
+		}
+		missing = append(missing, text)
+		index = append(index, i)
+	}
+	t.mx.Unlock()
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	translated, err := t.translator.Translate(ctx, missing, t.target)
+	if err != nil {
+		return nil, fmt.Errorf("translate: %w", err)
+	}
+	if len(translated) != len(missing) {
+		return nil, fmt.Errorf("translate: got %d texts instead of %d",
+			len(translated), len(missing))
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if len(t.cache)+len(missing) > maxTranslationCache {
+		clear(t.cache)
+	}
+	for i, tr := range translated {
+		out[index[i]] = tr
+		t.cache[missing[i]] = tr
+	}
+	return out, nil
+}
+
+// LibreTranslate is a client of LibreTranslate compatible translation API.
+type LibreTranslate struct {
+	url    string
+	key    string
+	client *http.Client
+}
+
+// NewLibreTranslate creates a new client of the API.
+func NewLibreTranslate(url, key string) *LibreTranslate {
+	return &LibreTranslate{
+		url:    strings.TrimRight(url, "/"),
+		key:    key,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Translate translates texts from automatically detected language.
+func (l *LibreTranslate) Translate(ctx context.Context, texts []string, target string) ([]Translated, error) {
+	type request struct {
+		Q      []string `json:"q"`
+		Source string   `json:"source"`
+		Target string   `json:"target"`
+		Format string   `json:"format"`
+		APIKey string   `json:"api_key,omitempty"`
+	}
+	type response struct {
+		TranslatedText   []string `json:"translatedText"`
+		DetectedLanguage []struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+		Error string `json:"error"`
+	}
+
+	body, err := json.Marshal(request{
+		Q:      texts,
+		Source: "auto",
+		Target: target,
+		Format: "text",
+		APIKey: l.key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("init request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var data response
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if resp.StatusCode != http.StatusOK {
+		if data.Error == "" {
+			return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		return nil, errors.New(data.Error)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	out := make([]Translated, len(data.TranslatedText))
+	for i, text := range data.TranslatedText {
+		out[i].Text = text
+		if i < len(data.DetectedLanguage) {
+			out[i].Language = data.DetectedLanguage[i].Language
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItemTranslator_Translate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("translate", func(t *testing.T) {
+		tr := &testTranslator{lang: "de"}
+		it := NewItemTranslator(tr, "en")
+
+		item := Item{Feed: "f1", Link: "https://example.com/", Title: "Hallo", Description: "Welt"}
+		out, err := it.Translate(ctx, item)
+		assert.NoError(t, err)
+		assert.Equal(t, Item{
+			Feed:        "f1",
+			Link:        "https://example.com/",
+			Title:       "en:Hallo",
+			Description: "en:Welt",
+			Original:    &Original{Language: "de", Title: "Hallo", Description: "Welt"},
+		}, out)
+
+		// Translated items are not translated again
+		again, err := it.Translate(ctx, out)
+		assert.NoError(t, err)
+		assert.Equal(t, out, again)
+
+		// Texts are cached
+		_, err = it.Translate(ctx, item)
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{{"Hallo", "Welt"}}, tr.calls)
+	})
+
+	t.Run("empty description", func(t *testing.T) {
+		tr := &testTranslator{lang: "de"}
+		it := NewItemTranslator(tr, "en")
+
+		out, err := it.Translate(ctx, Item{Feed: "f1", Title: "Hallo"})
+		assert.NoError(t, err)
+		assert.Equal(t, "en:Hallo", out.Title)
+		assert.Empty(t, out.Description)
+		assert.Equal(t, [][]string{{"Hallo"}}, tr.calls)
+	})
+
+	t.Run("skip items", func(t *testing.T) {
+		tr := &testTranslator{lang: "en"}
+		it := NewItemTranslator(tr, "en")
+
+		for _, item := range []Item{
+			{Feed: "f1", Text: "...and 3 more"},
+			{Feed: "f1", Title: "Hello"},
+		} {
+			out, err := it.Translate(ctx, item)
+			assert.NoError(t, err)
+			assert.Equal(t, item, out)
+		}
+		assert.Len(t, tr.calls, 1)
+	})
+
+	t.Run("process", func(t *testing.T) {
+		it := NewItemTranslator(&testTranslator{lang: "de"}, "en")
+
+		items, err := it.Process(ctx, Item{Feed: "f1", Title: "Hallo"})
+		assert.NoError(t, err)
+		assert.Len(t, items, 1)
+		assert.Equal(t, "en:Hallo", items[0].Title)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		tr := &testTranslator{err: errors.New("fail")}
+		it := NewItemTranslator(tr, "en")
+
+		item := Item{Feed: "f1", Title: "Hallo"}
+		out, err := it.Translate(ctx, item)
+		assert.EqualError(t, err, "translate: fail")
+		assert.Equal(t, item, out)
+	})
+}
+
+func TestLibreTranslate_Translate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		var req map[string]any
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/translate", r.URL.Path)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			_, _ = w.Write([]byte(`{` +
+				`"translatedText":["Hello","World"],` +
+				`"detectedLanguage":[{"confidence":90,"language":"de"},{"confidence":80,"language":"de"}]` +
+				`}`))
+		}))
+		defer server.Close()
+
+		lt := NewLibreTranslate(server.URL+"/", "secret")
+		out, err := lt.Translate(ctx, []string{"Hallo", "Welt"}, "en")
+		assert.NoError(t, err)
+		assert.Equal(t, []Translated{{Text: "Hello", Language: "de"}, {Text: "World", Language: "de"}}, out)
+		assert.Equal(t, map[string]any{
+			"q":       []any{"Hallo", "Welt"},
+			"source":  "auto",
+			"target":  "en",
+			"format":  "text",
+			"api_key": "secret",
+		}, req)
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"xx is not supported"}`))
+		}))
+		defer server.Close()
+
+		_, err := NewLibreTranslate(server.URL, "").Translate(ctx, []string{"Hallo"}, "xx")
+		assert.EqualError(t, err, "xx is not supported")
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		_, err := NewLibreTranslate(server.URL, "").Translate(ctx, []string{"Hallo"}, "en")
+		assert.EqualError(t, err, "unexpected status: 502 Bad Gateway")
+	})
+}
+
+// testTranslator prefixes texts with the target language, and reports
+// the same source language for all texts.
+type testTranslator struct {
+	lang  string
+	err   error
+	calls [][]string
+	mx    sync.Mutex
+}
+
+func (t *testTranslator) Translate(_ context.Context, texts []string, target string) ([]Translated, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.calls = append(t.calls, texts)
+	if t.err != nil {
+		return nil, t.err
+	}
+	out := make([]Translated, len(texts))
+	for i, text := range texts {
+		out[i] = Translated{Text: target + ":" + text, Language: t.lang}
+		if t.lang == target {
+			out[i].Text = text
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxUsersPoll is the longest time of waiting for user messages in
+	// a single request. It's also limited by the API request timeout.
+	maxUsersPoll = 10 * time.Second
+	// usersRetryDelay is a delay before the next request for user messages
+	// after an error.
+	usersRetryDelay = 5 * time.Second
+)
+
+const usersHelp = `Send me RSS or Atom feeds, and I'll send you their new items.
+
+/add <url> - subscribe to the feed
+/remove <url or number> - unsubscribe from the feed
+/list - list your feeds`
+
+// UserStorage keeps feed subscriptions of chats, and the audit log.
+type UserStorage interface {
+	AddSubscription(user, feed string) error
+	RemoveSubscription(user, feed string) error
+	GetSubscriptions(user string) []string
+	GetSubscribers(feed string) []string
+	SubscribedFeeds() []string
+	ResetLastUpdate(feed string) error
+	AddAuditEntry(e AuditEntry) error
+}
+
+// Users is a notifier for multi-tenant mode. Users manage their feeds by
+// sending commands to the bot in private chats, and receive items of these
+// feeds privately. Each feed is fetched once for all its subscribers.
+//
+// When access control is configured, feeds of group chats can also be
+// managed by their moderators and admins. All changes are written to
+// the audit log.
+type Users struct {
+	api     API
+	parser  Parser
+	storage UserStorage
+	access  *Access
+	groups  bool
+	conf    UsersConfig
+	poll    time.Duration
+	log     *logrus.Logger
+
+	// managed reports whether the feed is in the bot's feed list, so it's
+	// fetched regardless of subscriptions. CatchUp and MaxItems are
+	// defaults for other feeds.
+	managed  func(url string) bool
+	catchUp  string
+	maxItems int
+
+	// changed signals that the list of subscribed feeds has changed.
+	changed chan struct{}
+
+	// notifiers are senders of items to subscribed chats.
+	notifiers map[string]*TelegramNotifier
+	mx        *sync.Mutex
+}
+
+// NewUsers creates a new notifier for multi-tenant mode. Parser is used
+// for checking feeds, that users subscribe to. Managed feeds are fetched
+// by the bot regardless of subscriptions, so they are not provided as
+// feeds of users.
+func NewUsers(
+	api API,
+	p Parser,
+	s UserStorage,
+	managed func(url string) bool,
+	conf Config,
+	log *logrus.Logger,
+) *Users {
+	return &Users{
+		api:       api,
+		parser:    p,
+		storage:   s,
+		access:    NewAccess(conf.Access),
+		groups:    conf.Access != nil,
+		conf:      *conf.Users,
+		poll:      min(conf.TelegramTimeout/2, maxUsersPoll),
+		log:       log,
+		managed:   managed,
+		catchUp:   conf.CatchUp,
+		maxItems:  conf.MaxItems,
+		changed:   make(chan struct{}, 1),
+		notifiers: map[string]*TelegramNotifier{},
+		mx:        &sync.Mutex{},
+	}
+}
+
+// Feeds returns subscribed feeds, that are not managed by the bot.
+func (u *Users) Feeds() []Feed {
+	var feeds []Feed
+	for _, url := range u.storage.SubscribedFeeds() {
+		if !u.managed(url) {
+			feeds = append(feeds, Feed{URL: url, CatchUp: u.catchUp, MaxItems: u.maxItems})
+		}
+	}
+	return feeds
+}
+
+// Changed returns a channel, that receives a value when the list of
+// subscribed feeds changes.
+func (u *Users) Changed() <-chan struct{} {
+	return u.changed
+}
+
+// FeedStopped forgets the feed, that has no subscribers, so it's treated
+// as new, when someone subscribes again. It's called after fetching of
+// the feed is finished, so a running fetch doesn't save the state back.
+func (u *Users) FeedStopped(feed string) {
+	if u.managed(feed) || len(u.storage.GetSubscribers(feed)) > 0 {
+		return
+	}
+	if err := u.storage.ResetLastUpdate(feed); err != nil {
+		u.log.Errorf("Failed to reset feed [%s]: %v", feed, err)
+	}
+}
+
+// Notify sends the item to all chats subscribed to its feed.
+func (u *Users) Notify(ctx context.Context, item Item) error {
+	var errs []error
+	for _, chat := range u.storage.GetSubscribers(item.Feed) {
+		n, err := u.notifier(chat)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := n.Notify(ctx, item); err != nil {
+			errs = append(errs, fmt.Errorf("notify chat %s: %w", chat, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notifier returns a notifier for the chat. Negative IDs are groups.
+func (u *Users) notifier(chat string) (*TelegramNotifier, error) {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+
+	if n, ok := u.notifiers[chat]; ok {
+		return n, nil
+	}
+	dest := Destination{Chat: chat, ChatType: ChatTypePrivate, Message: u.conf.Message}
+	if strings.HasPrefix(chat, "-") {
+		dest.ChatType = ChatTypeGroup
+	}
+	n, err := NewTelegramNotifier(u.api, dest, nil, u.log)
+	if err != nil {
+		return nil, fmt.Errorf("init notifier of chat %s: %w", chat, err)
+	}
+	u.notifiers[chat] = n
+	return n, nil
+}
+
+// Run reads messages of users and handles their commands until
+// the context is cancelled.
+func (u *Users) Run(ctx context.Context) {
+	var offset int
+	for ctx.Err() == nil {
+		updates, err := u.getUpdates(offset)
+		if err != nil {
+			u.log.Errorf("Failed to get user messages: %v", err)
+			select {
+			case <-time.After(usersRetryDelay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		for _, upd := range updates {
+			offset = upd.UpdateID + 1
+			u.handle(upd)
+		}
+	}
+}
+
+// getUpdates waits for new messages, starting from the offset.
+func (u *Users) getUpdates(offset int) ([]tg.Update, error) {
+	params := tg.Params{}
+	params.AddNonZero("offset", offset)
+	params.AddNonZero("timeout", int(u.poll.Seconds()))
+	if err := params.AddInterface("allowed_updates", []string{"message"}); err != nil {
+		return nil, fmt.Errorf("encode params: %w", err)
+	}
+	resp, err := u.api.MakeRequest("getUpdates", params)
+	if err != nil {
+		return nil, fmt.Errorf("api request: %w", err)
+	}
+	var updates []tg.Update
+	if err := json.Unmarshal(resp.Result, &updates); err != nil {
+		return nil, fmt.Errorf("decode updates: %w", err)
+	}
+	return updates, nil
+}
+
+// handle executes the user's command, and replies with the result.
+// Messages from channels are ignored, and messages from groups are
+// ignored unless access control is configured.
+func (u *Users) handle(upd tg.Update) {
+	msg := upd.Message
+	if msg == nil || msg.Chat == nil || msg.From == nil {
+		return
+	}
+	if !msg.Chat.IsPrivate() && !(u.groups && (msg.Chat.IsGroup() || msg.Chat.IsSuperGroup())) {
+		return
+	}
+	// Other messages in groups are not for the bot
+	if !msg.Chat.IsPrivate() && !msg.IsCommand() {
+		return
+	}
+	c := command{user: msg.From.ID, chat: msg.Chat.ID, name: msg.Command()}
+	arg := strings.TrimSpace(msg.CommandArguments())
+
+	var reply string
+	var err error
+	switch c.name {
+	case "start", "help":
+		reply = usersHelp
+	case "add", "remove":
+		if !u.access.CanChange(c.user, c.chat) {
+			u.log.Warnf("User %d is not allowed to %s feeds of chat %d", c.user, c.name, c.chat)
+			reply = "You are not allowed to change feeds of this chat."
+			break
+		}
+		if c.name == "add" {
+			reply, err = u.add(c, arg)
+		} else {
+			reply, err = u.remove(c, arg)
+		}
+	case "list":
+		reply = u.list(c.chatID())
+	default:
+		reply = "Unknown command.\n\n" + usersHelp
+	}
+	if err != nil {
+		u.log.Errorf("Failed to handle command of user %d: %v", c.user, err)
+		reply = "Something went wrong, please try again later."
+	}
+
+	params := tg.Params{"chat_id": c.chatID(), "text": reply}
+	params.AddBool("disable_web_page_preview", true)
+	if _, err := u.api.MakeRequest("sendMessage", params); err != nil {
+		u.log.Errorf("Failed to reply to user %d: %v", c.user, err)
+	}
+}
+
+// command is a command of the user in the chat.
+type command struct {
+	user int64
+	chat int64
+	name string
+}
+
+// chatID returns ID of the command's chat as a string.
+func (c command) chatID() string {
+	return strconv.FormatInt(c.chat, 10)
+}
+
+// audit writes the successful command to the audit log.
+func (u *Users) audit(c command, target string) {
+	e := AuditEntry{
+		Time:   time.Now(),
+		User:   strconv.FormatInt(c.user, 10),
+		Role:   u.access.Role(c.user, c.chat).String(),
+		Chat:   c.chat,
+		Action: c.name,
+		Target: target,
+	}
+	if err := u.storage.AddAuditEntry(e); err != nil {
+		u.log.Errorf("Failed to write audit log: %v", err)
+	}
+}
+
+// add subscribes the chat to the feed. New feeds are checked by fetching
+// them. Returned error is an internal one, user errors are replies.
+func (u *Users) add(c command, url string) (string, error) {
+	if url == "" {
+		return "Usage: /add <url>", nil
+	}
+	if err := validateFeedURL(url); err != nil {
+		return fmt.Sprintf("Invalid feed URL: %v.", err), nil
+	}
+	chat := c.chatID()
+	feeds := u.storage.GetSubscriptions(chat)
+	if slices.Contains(feeds, url) {
+		return "You are already subscribed to " + url, nil
+	}
+	if len(feeds) >= u.conf.MaxFeeds {
+		return fmt.Sprintf("You can't have more than %d feeds.", u.conf.MaxFeeds), nil
+	}
+	if !u.managed(url) && len(u.storage.GetSubscribers(url)) == 0 {
+		// Errors may show details of internal services, so they are
+		// only logged
+		if _, err := u.parser.Parse(url); err != nil {
+			u.log.Infof("Failed to read the feed of chat %s [%s]: %v", chat, url, err)
+			return "Failed to read the feed.", nil
+		}
+	}
+
+	if err := u.storage.AddSubscription(chat, url); err != nil {
+		return "", fmt.Errorf("add subscription: %w", err)
+	}
+	u.audit(c, url)
+	u.notifyChanged()
+	return "Subscribed to " + url, nil
+}
+
+// remove unsubscribes the chat from the feed, that is set by its URL or
+// its number in the list. Feeds without subscribers are stopped by the bot,
+// and then forgotten, see FeedStopped.
+func (u *Users) remove(c command, arg string) (string, error) {
+	if arg == "" {
+		return "Usage: /remove <url or number>", nil
+	}
+	chat := c.chatID()
+	feeds := u.storage.GetSubscriptions(chat)
+	url := arg
+	if n, err := strconv.Atoi(arg); err == nil && n >= 1 && n <= len(feeds) {
+		url = feeds[n-1]
+	}
+	if !slices.Contains(feeds, url) {
+		return "You are not subscribed to " + url, nil
+	}
+
+	if err := u.storage.RemoveSubscription(chat, url); err != nil {
+		return "", fmt.Errorf("remove subscription: %w", err)
+	}
+	u.audit(c, url)
+	u.notifyChanged()
+	return "Unsubscribed from " + url, nil
+}
+
+// list returns numbered feeds of the chat.
+func (u *Users) list(chat string) string {
+	feeds := u.storage.GetSubscriptions(chat)
+	if len(feeds) == 0 {
+		return "You don't have any feeds yet. Add one with /add <url>"
+	}
+	var b strings.Builder
+	for i, f := range feeds {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, f)
+	}
+	fmt.Fprintf(&b, "\n%d of %d feeds", len(feeds), u.conf.MaxFeeds)
+	return b.String()
+}
+
+// notifyChanged signals that subscribed feeds have changed without
+// blocking. A single pending signal is enough.
+func (u *Users) notifyChanged() {
+	select {
+	case u.changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsers_Handle(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	conf := Config{
+		CatchUp:         "last 1",
+		TelegramTimeout: 30 * time.Second,
+		Users:           &UsersConfig{MaxFeeds: 2},
+	}
+	api := &testTgAPI{}
+	parser := &testParser{items: map[string][]Item{}}
+	s := &testUserStorage{}
+	managed := func(url string) bool { return url == "https://example.com/config.xml" }
+	u := NewUsers(api, parser, s, managed, conf, log)
+
+	reply := func(chat int64, text string) string {
+		u.handle(testCommand(chat, text))
+		return api.sent
+	}
+
+	t.Run("help", func(t *testing.T) {
+		assert.Equal(t, usersHelp, reply(1, "/start"))
+		assert.Equal(t, "Unknown command.\n\n"+usersHelp, reply(1, "hello"))
+		assert.Equal(t, "1", api.params[len(api.params)-1]["chat_id"])
+	})
+
+	t.Run("add", func(t *testing.T) {
+		assert.Equal(t, "Usage: /add <url>", reply(1, "/add"))
+		assert.Equal(t, "Invalid feed URL: scheme must be http or https.", reply(1, "/add example.com"))
+
+		parser.err = errors.New("not a feed")
+		assert.Equal(t, "Failed to read the feed.", reply(1, "/add https://example.com/page"))
+		assert.Empty(t, s.GetSubscriptions("1"))
+
+		// Feeds of the bot are not checked
+		assert.Equal(t, "Subscribed to https://example.com/config.xml",
+			reply(1, "/add https://example.com/config.xml"))
+
+		parser.err = nil
+		assert.Equal(t, "Subscribed to https://example.com/rss.xml", reply(1, "/add https://example.com/rss.xml"))
+		assert.Equal(t, "You are already subscribed to https://example.com/rss.xml",
+			reply(1, "/add https://example.com/rss.xml"))
+		assert.Equal(t, "You can't have more than 2 feeds.", reply(1, "/add https://example.com/atom.xml"))
+
+		assert.Equal(t, []string{"https://example.com/config.xml", "https://example.com/rss.xml"},
+			s.GetSubscriptions("1"))
+		assert.Equal(t, []Feed{{URL: "https://example.com/rss.xml", CatchUp: "last 1"}}, u.Feeds())
+		select {
+		case <-u.Changed():
+		default:
+			t.Fatal("no change signal")
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		assert.Equal(t, "1. https://example.com/config.xml\n"+
+			"2. https://example.com/rss.xml\n\n"+
+			"2 of 2 feeds", reply(1, "/list"))
+		assert.Equal(t, "You don't have any feeds yet. Add one with /add <url>", reply(2, "/list"))
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		assert.Equal(t, "Usage: /remove <url or number>", reply(1, "/remove"))
+		assert.Equal(t, "You are not subscribed to https://example.com/atom.xml",
+			reply(1, "/remove https://example.com/atom.xml"))
+		assert.Equal(t, "Unsubscribed from https://example.com/rss.xml", reply(1, "/remove 2"))
+		assert.Equal(t, "Unsubscribed from https://example.com/config.xml",
+			reply(1, "/remove https://example.com/config.xml"))
+		assert.Empty(t, s.GetSubscriptions("1"))
+		assert.Empty(t, u.Feeds())
+
+		// Feeds are reset, when the bot stops fetching them, and only feeds,
+		// that are not managed by the bot, are reset
+		assert.Empty(t, s.reset)
+		u.FeedStopped("https://example.com/rss.xml")
+		u.FeedStopped("https://example.com/config.xml")
+		assert.Equal(t, []string{"https://example.com/rss.xml"}, s.reset)
+
+		assert.Equal(t, []AuditEntry{
+			{User: "1", Role: "user", Chat: 1, Action: "add", Target: "https://example.com/config.xml"},
+			{User: "1", Role: "user", Chat: 1, Action: "add", Target: "https://example.com/rss.xml"},
+			{User: "1", Role: "user", Chat: 1, Action: "remove", Target: "https://example.com/rss.xml"},
+			{User: "1", Role: "user", Chat: 1, Action: "remove", Target: "https://example.com/config.xml"},
+		}, s.audit)
+	})
+
+	t.Run("storage error", func(t *testing.T) {
+		s.err = errors.New("fail")
+		defer func() { s.err = nil }()
+
+		assert.Equal(t, "Something went wrong, please try again later.",
+			reply(1, "/add https://example.com/rss.xml"))
+	})
+
+	t.Run("groups without access control", func(t *testing.T) {
+		api.sent = ""
+		upd := testCommand(-100, "/list")
+		upd.Message.Chat.Type = "group"
+		u.handle(upd)
+		assert.Empty(t, api.sent)
+	})
+}
+
+func TestUsers_Access(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	conf := Config{
+		Users: &UsersConfig{MaxFeeds: 10},
+		Access: &AccessConfig{
+			Admins:     []int64{1},
+			Moderators: map[int64][]int64{-100: {2}},
+			ReadOnly:   []int64{3},
+		},
+	}
+	api := &testTgAPI{}
+	s := &testUserStorage{}
+	u := NewUsers(api, &testParser{}, s, testUnmanaged, conf, log)
+
+	reply := func(user, chat int64, text string) string {
+		api.sent = ""
+		upd := testCommand(chat, text)
+		upd.Message.From.ID = user
+		if chat < 0 {
+			upd.Message.Chat.Type = "supergroup"
+		}
+		u.handle(upd)
+		return api.sent
+	}
+	denied := "You are not allowed to change feeds of this chat."
+
+	// Admins and moderators change feeds of groups
+	assert.Equal(t, "Subscribed to https://example.com/1.xml", reply(1, -100, "/add https://example.com/1.xml"))
+	assert.Equal(t, "Subscribed to https://example.com/2.xml", reply(2, -100, "/add https://example.com/2.xml"))
+	assert.Equal(t, denied, reply(2, -200, "/add https://example.com/2.xml"))
+	assert.Equal(t, denied, reply(4, -100, "/remove 1"))
+
+	// Read-only users only list feeds
+	assert.Equal(t, denied, reply(3, 3, "/add https://example.com/3.xml"))
+	assert.Equal(t, denied, reply(3, -100, "/remove 1"))
+	assert.Equal(t, "1. https://example.com/1.xml\n"+
+		"2. https://example.com/2.xml\n\n"+
+		"2 of 10 feeds", reply(3, -100, "/list"))
+
+	// Other users change their own feeds
+	assert.Equal(t, "Subscribed to https://example.com/4.xml", reply(4, 4, "/add https://example.com/4.xml"))
+
+	// Other messages in groups are ignored
+	assert.Empty(t, reply(4, -100, "hello"))
+
+	assert.Equal(t, []AuditEntry{
+		{User: "1", Role: "admin", Chat: -100, Action: "add", Target: "https://example.com/1.xml"},
+		{User: "2", Role: "moderator", Chat: -100, Action: "add", Target: "https://example.com/2.xml"},
+		{User: "4", Role: "user", Chat: 4, Action: "add", Target: "https://example.com/4.xml"},
+	}, s.audit)
+	assert.Equal(t, []string{"-100"}, s.GetSubscribers("https://example.com/1.xml"))
+}
+
+func TestUsers_Notify(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	conf := Config{
+		Users: &UsersConfig{Message: MessageOptions{Template: "{{.Title}}"}},
+	}
+	api := &testTgAPI{}
+	s := &testUserStorage{subscriptions: map[string][]string{
+		"1": {"f1", "f2"},
+		"2": {"f1"},
+	}}
+	u := NewUsers(api, &testParser{}, s, testUnmanaged, conf, log)
+
+	assert.NoError(t, u.Notify(context.Background(), Item{Feed: "f1", Title: "One"}))
+	assert.NoError(t, u.Notify(context.Background(), Item{Feed: "f2", Title: "Two"}))
+	assert.NoError(t, u.Notify(context.Background(), Item{Feed: "f3", Title: "Three"}))
+
+	var sent []string
+	for _, p := range api.params {
+		sent = append(sent, p["chat_id"]+": "+p["text"])
+	}
+	assert.Equal(t, []string{"1: One", "2: One", "1: Two"}, sent)
+
+	api.err = errors.New("blocked")
+	assert.EqualError(t, u.Notify(context.Background(), Item{Feed: "f2", Title: "Two"}),
+		"notify chat 1: send api request: blocked")
+}
+
+func TestUsers_Run(t *testing.T) {
+	log := logrus.New()
+	log.Out = io.Discard
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf := Config{TelegramTimeout: 4 * time.Second, Users: &UsersConfig{}}
+	api := &testUpdatesAPI{updates: []tg.Update{testCommand(1, "/help"), testCommand(2, "/list")}}
+	api.updates[0].UpdateID = 10
+	api.updates[1].UpdateID = 11
+	u := NewUsers(api, &testParser{}, &testUserStorage{}, testUnmanaged, conf, log)
+
+	done := make(chan struct{})
+	go func() {
+		u.Run(ctx)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+
+	api.mx.Lock()
+	defer api.mx.Unlock()
+	assert.Equal(t, []string{"1", "2"}, api.replies)
+	assert.Equal(t, tg.Params{"timeout": "2", "allowed_updates": `["message"]`}, api.polls[0])
+	assert.Equal(t, "12", api.polls[1]["offset"])
+}
+
+func testUnmanaged(string) bool { return false }
+
+func testCommand(chat int64, text string) tg.Update {
+	cmd, _, _ := strings.Cut(text, " ")
+	msg := &tg.Message{
+		From: &tg.User{ID: chat},
+		Chat: &tg.Chat{ID: chat, Type: "private"},
+		Text: text,
+	}
+	if strings.HasPrefix(text, "/") {
+		msg.Entities = []tg.MessageEntity{{Type: "bot_command", Length: len(cmd)}}
+	}
+	return tg.Update{Message: msg}
+}
+
+type testUserStorage struct {
+	subscriptions map[string][]string
+	reset         []string
+	audit         []AuditEntry
+	err           error
+}
+
+func (s *testUserStorage) AddSubscription(user, feed string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.subscriptions == nil {
+		s.subscriptions = map[string][]string{}
+	}
+	s.subscriptions[user] = append(s.subscriptions[user], feed)
+	return nil
+}
+
+func (s *testUserStorage) RemoveSubscription(user, feed string) error {
+	s.subscriptions[user] = slices.DeleteFunc(s.subscriptions[user], func(f string) bool {
+		return f == feed
+	})
+	return s.err
+}
+
+func (s *testUserStorage) GetSubscriptions(user string) []string {
+	return slices.Clone(s.subscriptions[user])
+}
+
+func (s *testUserStorage) GetSubscribers(feed string) []string {
+	var users []string
+	for u, feeds := range s.subscriptions {
+		if slices.Contains(feeds, feed) {
+			users = append(users, u)
+		}
+	}
+	slices.Sort(users)
+	return users
+}
+
+func (s *testUserStorage) SubscribedFeeds() []string {
+	var feeds []string
+	for _, ff := range s.subscriptions {
+		feeds = append(feeds, ff...)
+	}
+	slices.Sort(feeds)
+	return slices.Compact(feeds)
+}
+
+func (s *testUserStorage) ResetLastUpdate(feed string) error {
+	s.reset = append(s.reset, feed)
+	return s.err
+}
+
+func (s *testUserStorage) AddAuditEntry(e AuditEntry) error {
+	e.Time = time.Time{}
+	s.audit = append(s.audit, e)
+	return s.err
+}
+
+// testUpdatesAPI returns the updates on the first request for updates,
+// and no updates after that.
+type testUpdatesAPI struct {
+	updates []tg.Update
+	polls   []tg.Params
+	replies []string
+	mx      sync.Mutex
+}
+
+func (a *testUpdatesAPI) MakeRequest(endpoint string, params tg.Params) (*tg.APIResponse, error) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	if endpoint == "sendMessage" {
+		a.replies = append(a.replies, params["chat_id"])
+		return &tg.APIResponse{Ok: true, Result: json.RawMessage(`{"message_id":1}`)}, nil
+	}
+	a.polls = append(a.polls, params)
+	if len(a.polls) > 1 {
+		time.Sleep(time.Millisecond)
+		return &tg.APIResponse{Ok: true, Result: json.RawMessage(`[]`)}, nil
+	}
+	data, err := json.Marshal(a.updates)
+	if err != nil {
+		return nil, err
+	}
+	return &tg.APIResponse{Ok: true, Result: data}, nil
+}